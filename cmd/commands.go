@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+const usage = `usage:
+  ai-app                      start the web server
+  ai-app migrate up           apply all pending migrations
+  ai-app migrate down [N]     revert the last N migrations (default 1)
+  ai-app migrate status       list migrations and whether they are applied
+  ai-app create-admin NAME    create an admin account; the password is read from the
+                              terminal, or from the first line of stdin`
+
+// runCommand dispatches maintenance subcommands given on the command line.
+func runCommand(args []string) {
+	switch args[0] {
+	case "migrate":
+		runMigrate(args[1:])
+	case "create-admin":
+		runCreateAdmin(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// openDB opens DATABASE_URL or DB_PATH.
+func openDB() *database.Store {
+	dsn := database.DSNFromEnv()
+	if dsn == "" {
+		log.Fatal("DATABASE_URL or DB_PATH must be set")
+	}
+	db, err := database.Open(dsn)
+	if err != nil {
+		log.Fatalf("opening database: %v", err)
+	}
+	return db
+}
+
+// runMigrate implements "migrate up|down|status" against DATABASE_URL or DB_PATH.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	db := openDB()
+	defer db.Close()
+
+	var err error
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(db)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("migrate down: N must be a positive number")
+			}
+		}
+		reverted, err := database.MigrateDown(db, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate down: %v", err)
+		}
+
+	case "status":
+		status, err := database.Status(db)
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		for _, s := range status {
+			state := "pending"
+			switch {
+			case s.Modified:
+				state = "MODIFIED (applied " + s.AppliedAt + ")"
+			case s.Applied && s.Up == "":
+				state = "applied " + s.AppliedAt + " (unknown to this binary)"
+			case s.Applied:
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d_%-28s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// runCreateAdmin implements "create-admin NAME", the way to create the first account.
+func runCreateAdmin(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	db := openDB()
+	defer db.Close()
+	if err := database.CheckSchema(db); err != nil {
+		log.Fatalf("create-admin: %v (run \"%s migrate up\" first)", err, os.Args[0])
+	}
+	store.Init(db)
+	if os.Getenv("PERMIT_API_KEY") != "" {
+		auth.InitPermit()
+	} else {
+		log.Printf("PERMIT_API_KEY not set; the account is synced to Permit at its first login")
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		log.Fatalf("create-admin: reading password: %v", err)
+	}
+	if err := auth.CreateUser(context.Background(), args[0], "", password, auth.RoleAdmin, "create-admin"); err != nil {
+		log.Fatalf("create-admin %s: %v", args[0], err)
+	}
+	fmt.Printf("created admin %s\n", args[0])
+}
+
+// readPassword prompts twice on a terminal, or reads the first line of piped stdin.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(first), nil
+}
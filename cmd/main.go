@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 
 	"github.com/nicksnyder/go-i18n/v2/i18n"
@@ -19,16 +24,27 @@ import (
 	"github.com/schoolboylurk/data-sentinel/pkg/auth"
 	"github.com/schoolboylurk/data-sentinel/pkg/database"
 	"github.com/schoolboylurk/data-sentinel/pkg/handlers"
+	"github.com/schoolboylurk/data-sentinel/pkg/jobs"
 	"github.com/schoolboylurk/data-sentinel/pkg/middleware"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/policy"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
 )
 
 var bundle *i18n.Bundle
 
+// How long shutdown waits for in-flight requests and AI jobs.
+const shutdownTimeout = 30 * time.Second
+
 func initI18n() {
 	bundle = i18n.NewBundle(language.English)
 	bundle.RegisterUnmarshalFunc("json", json.Unmarshal)
-	bundle.LoadMessageFile("web/locales/en.json")
-	bundle.LoadMessageFile("web/locales/es.json")
+	for _, file := range []string{"web/locales/en.json", "web/locales/es.json"} {
+		if _, err := bundle.LoadMessageFile(file); err != nil {
+			log.Printf("⚠️ initI18n: %v", err)
+		}
+	}
 }
 
 // I18nMiddleware sets up a localizer based on Accept-Language
@@ -42,34 +58,90 @@ func I18nMiddleware() gin.HandlerFunc {
 }
 
 func main() {
+	// 0. Maintenance subcommands, e.g. "ai-app migrate up"
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	// 1. ENV checks
-	required := []string{"OPENAI_API_KEY", "PERMIT_API_KEY", "PERMIT_PDP_URL", "SESSION_SECRET", "DB_PATH", "DB_SCHEMA"}
+	// OPENAI_API_KEY is only required by the openai provider; see ai.InitProvider.
+	required := []string{"PERMIT_API_KEY", "PERMIT_PDP_URL", "SESSION_SECRET"}
 	for _, e := range required {
 		if os.Getenv(e) == "" {
 			log.Fatalf("%s must be set", e)
 		}
 	}
+	dsn := database.DSNFromEnv()
+	if dsn == "" {
+		log.Fatal("DATABASE_URL or DB_PATH must be set")
+	}
 
 	// 2. Init SDKs and database
 	auth.InitPermit()
-	ai.InitOpenAI()
-	dbPath := os.Getenv("DB_PATH")
-	schema := os.Getenv("DB_SCHEMA")
-	if dbPath == "" || schema == "" {
-		log.Fatal("DB_PATH and DB_SCHEMA must be set")
+	if err := ai.InitProvider(); err != nil {
+		log.Fatalf("AI provider init failed: %v", err)
+	}
+	log.Printf("AI provider: %s", ai.Default.Name())
+	if err := policy.Init(); err != nil {
+		log.Fatalf("policy init failed: %v", err)
+	}
+	if err := moderation.Init(); err != nil {
+		log.Fatalf("moderation init failed: %v", err)
 	}
-	if err := database.InitDB(dbPath, schema); err != nil {
+	if err := auth.InitOIDC(); err != nil {
+		log.Fatalf("OIDC init failed: %v", err)
+	}
+	if err := middleware.InitRateLimits(); err != nil {
+		log.Fatalf("rate limiter init failed: %v", err)
+	}
+	if err := quota.Init(); err != nil {
+		log.Fatalf("quota init failed: %v", err)
+	}
+	if err := database.InitDB(dsn, os.Getenv("DB_AUTO_MIGRATE") == "true"); err != nil {
+		if errors.Is(err, database.ErrSchemaOutdated) {
+			log.Fatalf("DB init failed: %v (run \"%s migrate up\" or set DB_AUTO_MIGRATE=true)", err, os.Args[0])
+		}
 		log.Fatalf("DB init failed: %v", err)
 	}
+	store.Init(database.DB)
+	if err := policy.ImportLegacyPolicies(context.Background()); err != nil {
+		log.Fatalf("importing legacy content policies failed: %v", err)
+	}
+
+	// Background work stops on SIGINT/SIGTERM; see the shutdown at the end.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go handlers.ExpireStaleRequests(ctx, handlers.RequestTTL(), time.Hour)
+	go auth.PurgeExpiredSessions(ctx, time.Hour)
+	go middleware.PurgeRateLimits(ctx, time.Hour)
+	if err := jobs.Init(store.Default, handlers.GenerateRequestAnswer); err != nil {
+		log.Fatalf("job queue init failed: %v", err)
+	}
+	if err := jobs.Default.Start(ctx); err != nil {
+		log.Fatalf("job queue start failed: %v", err)
+	}
 	initI18n()
 
 	// 3. Gin setup
 	r := gin.Default()
+	// only proxies listed in TRUSTED_PROXIES may set the client address
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(auth.RememberClientIP)
 
 	// 3a. Sessions
-	store := cookie.NewStore([]byte(os.Getenv("SESSION_SECRET")))
+	// server-side: the cookie only carries a token for a row in login_sessions
+	sessionStore := auth.NewSessionStore([]byte(os.Getenv("SESSION_SECRET")))
 	// configure secure cookie options
-	store.Options(sessions.Options{
+	sessionStore.Options(sessions.Options{
 		Path:     "/",                        // valid for entire site
 		Domain:   os.Getenv("COOKIE_DOMAIN"), // e.g. ".example.com" or leave empty for host-based
 		MaxAge:   60 * 60 * 24,               // 1 day
@@ -77,18 +149,22 @@ func main() {
 		HttpOnly: true,                       // not accessible from JS
 		SameSite: http.SameSiteLaxMode,       // or StrictMode
 	})
-	r.Use(sessions.Sessions("ai-session", store))
+	r.Use(sessions.Sessions("ai-session", sessionStore))
 
-	// 3b. CSRF protection
-	r.Use(csrf.Middleware(csrf.Options{
+	// 3b. Identity: who a request acts as comes from verified credentials only,
+	// an API token or the session cookie
+	r.Use(auth.Identify(auth.BearerPrincipal, auth.SessionPrincipal))
+
+	// 3c. CSRF protection, not needed for token-authenticated API calls
+	r.Use(auth.UnlessToken(csrf.Middleware(csrf.Options{
 		Secret: os.Getenv("SESSION_SECRET"),
 		ErrorFunc: func(c *gin.Context) {
 			c.String(http.StatusForbidden, "CSRF token mismatch")
 			c.Abort()
 		},
-	}))
+	})))
 
-	// 3c. Internationalization
+	// 3d. Internationalization
 	r.Use(I18nMiddleware())
 	r.SetFuncMap(template.FuncMap{
 		"T": func(c *gin.Context, key string) string {
@@ -98,12 +174,12 @@ func main() {
 		},
 	})
 
-	// 3d. Template files
+	// 3e. Template files
 	r.LoadHTMLGlob("web/templates/*.html")
 
-	// 3e. Serve homepage at "/"
+	// 3f. Serve homepage at "/"
 	r.GET("/", func(c *gin.Context) {
-	c.HTML(http.StatusOK, "index.html", nil)
+		c.HTML(http.StatusOK, "index.html", nil)
 	})
 
 	// health endpoint for Docker HEALTHCHECK
@@ -114,42 +190,139 @@ func main() {
 	// 4. Admin login & UI routes
 	r.GET("/login", handlers.ShowLogin)
 	r.POST("/login", handlers.PerformLogin)
+	r.POST("/logout", handlers.Logout)
+
+	r.GET("/login/oidc", handlers.StartOIDCLogin)
+	r.GET("/login/oidc/callback", handlers.OIDCCallback)
+	r.GET("/login/mfa", handlers.ShowMFALogin)
+	r.POST("/login/mfa", handlers.PerformMFALogin)
 
 	admin := r.Group("/admin")
 	admin.Use(handlers.AdminRequired)
+	// two-factor setup stays reachable when it is mandatory but not done yet;
+	// MFAEnrolled only applies to the routes registered after it, and must be on
+	// every other route a parent's or admin's session can reach
+	admin.GET("/mfa", handlers.ShowMFAPage)
+	admin.POST("/mfa/setup", handlers.BeginMFA)
+	admin.POST("/mfa/enable", handlers.EnableMFA)
+	admin.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+	admin.POST("/mfa/disable", handlers.DisableMFA)
+	admin.Use(handlers.MFAEnrolled)
 	admin.GET("/kids", handlers.ListKidsPage)
 	admin.POST("/kids", handlers.AddKid)
+	admin.POST("/kids/:username/guardians", handlers.AddGuardian)
+	admin.POST("/kids/:username/guardians/:guardian/delete", handlers.RemoveGuardian)
+	admin.POST("/kids/:username/secret", handlers.SetKidSecret)
+	admin.POST("/kids/:username/unlock", handlers.UnlockKid)
+	admin.POST("/kids/:username/login-code", handlers.IssueKidLoginCode)
+	admin.POST("/notifications/:id/read", handlers.DismissNotification)
 	admin.GET("/policies", handlers.ListPoliciesPage)
 	admin.POST("/policies", handlers.UpdatePolicy)
+	admin.POST("/policies/:id/delete", handlers.DeletePolicyRule)
 	admin.GET("/requests", handlers.ListRequestsPage)
 	admin.POST("/approve/:id", handlers.ApprovePromptHandler)
+	admin.POST("/deny/:id", handlers.DenyPromptHandler)
 	admin.GET("/dashboard", handlers.ShowAdminDashboard)
 	admin.GET("/metrics", handlers.MetricsHandler)
 	admin.GET("/violations", handlers.ViolationMetrics)
-	admin.GET("/groups", handlers.ListGroupsPage)
-	admin.POST("/groups", handlers.AddGroup)
-	admin.POST("/groups/:id/members", handlers.AddMember)
+	admin.GET("/moderation", handlers.ListModerationPage)
+	admin.GET("/usage", handlers.ShowUsagePage)
+	admin.POST("/usage/kids/:username", handlers.SetKidBudget)
+	admin.GET("/schedules", handlers.ShowSchedulesPage)
+	admin.POST("/schedules/:username", handlers.SaveSchedule)
+	admin.POST("/schedules/:username/delete", handlers.DeleteSchedule)
+	admin.POST("/schedules/:username/overrides", handlers.SetScheduleOverride)
+	admin.POST("/schedules/:username/overrides/:day/delete", handlers.DeleteScheduleOverride)
+	admin.POST("/schedules/:username/extra-time", handlers.GrantExtraTime)
+	admin.GET("/sessions", handlers.ListSessionsPage)
+	admin.POST("/sessions/:id/revoke", handlers.RevokeSession)
+	admin.GET("/password", handlers.ShowPasswordPage)
+	admin.POST("/password", handlers.ChangePassword)
+
+	// shared catalog, groups, accounts and tokens are for admins only, not parents
+	superuser := admin.Group("/", handlers.RoleRequired(auth.RoleAdmin))
+	superuser.POST("/topics", handlers.AddTopic)
+	superuser.POST("/age-bands", handlers.AddAgeBand)
+	superuser.GET("/prompts", handlers.ShowPromptsPage)
+	superuser.POST("/prompts/:band", handlers.SavePromptTemplate)
+	superuser.POST("/prompts/:band/preview", handlers.PreviewPromptTemplate)
+	superuser.POST("/prompts/:band/restore/:version", handlers.RestorePromptTemplate)
+	superuser.GET("/groups", handlers.ListGroupsPage)
+	superuser.POST("/groups", handlers.AddGroup)
+	superuser.POST("/groups/:id/members", handlers.AddMember)
+	superuser.GET("/tokens", handlers.ListTokensPage)
+	superuser.POST("/tokens", handlers.CreateToken)
+	superuser.POST("/tokens/:id/revoke", handlers.RevokeToken)
+	superuser.GET("/users", handlers.ListUsersPage)
+	superuser.POST("/users", handlers.AddUser)
+	superuser.POST("/users/:username/unlock", handlers.UnlockUser)
+	superuser.POST("/users/:username/password", handlers.ResetUserPassword)
+	superuser.POST("/users/:username/mfa/reset", handlers.ResetUserMFA)
+	superuser.POST("/settings/mfa", handlers.SetMFARequirement)
+	superuser.POST("/usage/household", handlers.SetHouseholdBudget)
 
 	// 5. Child UI & chat endpoints
 	r.GET("/child/login", handlers.ShowChildLogin)
 	r.POST("/child/login", handlers.PerformChildLogin)
+	r.POST("/child/login/code", handlers.PerformChildCodeLogin)
 
-	child := r.Group("/child", handlers.ChildRequired)
-	child.GET("/chat", handlers.ShowChildChatPage)                                   // persistent chat UI
-	child.POST("/session", handlers.StartChatSession)                                // create session
-	child.POST("/session/:id/message", middleware.RateLimit(), handlers.PostMessage) // post message
-	child.GET("/session/:id/history", handlers.GetChatHistory)                       // fetch history
+	// kids only get in when their schedule allows
+	child := r.Group("/child", handlers.ChildRequired, handlers.WithinSchedule)
+	child.GET("/chat", handlers.ShowChildChatPage)    // persistent chat UI
+	child.GET("/sessions", handlers.ListChatSessions) // own sessions, latest first
+	child.POST("/session", handlers.StartChatSession) // create session
+	child.POST("/heartbeat", handlers.Heartbeat)      // count an open chat page as screen time
 
-	// 6. API endpoints for programmatic use
-	r.POST("/request-prompt", handlers.RequestPromptHandler)
-	r.POST("/approve/:id", handlers.ApprovePromptHandler)
-	r.POST("/generate-report", handlers.GenerateReportHandler)
+	// every route on a session checks that it belongs to the logged-in kid
+	chat := child.Group("/session/:id", handlers.ChatSessionOwner)
+	chat.POST("/message", middleware.RateLimit("chat"), handlers.PostMessage)  // post message
+	chat.POST("/stream", middleware.RateLimit("chat"), handlers.StreamMessage) // post message, stream answer (SSE)
+	chat.GET("/history", handlers.GetChatHistory)                              // fetch history
+	chat.POST("/resume", handlers.ResumeChatSession)                           // reopen a closed session
+	chat.POST("/rename", handlers.RenameChatSession)                           // set title
+	chat.POST("/close", handlers.CloseChatSession)                             // stop taking messages
+	chat.POST("/delete", handlers.DeleteChatSession)                           // delete with messages
+
+	child.GET("/ask", handlers.ShowChildPromptPage)                             // single-prompt form and past requests
+	child.POST("/ask", middleware.RateLimit("ask"), handlers.HandleChildPrompt) // submit a prompt for approval
+	child.GET("/requests", handlers.ListChildRequests)                          // own requests (JSON)
+
+	// request status is visible to the kid who asked, within their schedule, and to parents
+	status := r.Group("/child", handlers.RequestViewerRequired, handlers.MFAEnrolled, handlers.KidsWithinSchedule)
+	status.GET("/status/:id", handlers.ShowChildStatusPage)
+	status.GET("/requests/:id", handlers.GetChildRequestStatus)
+
+	// 6. API endpoints for programmatic use; callers need an account's session or an
+	// API token, kids use their own pages
+	api := r.Group("/", auth.RequirePrincipal, handlers.AccountRequired, handlers.MFAEnrolled, middleware.RateLimit("api"))
+	api.POST("/request-prompt", handlers.RequestPromptHandler)
+	api.POST("/approve/:id", handlers.ApprovePromptHandler)
+	api.POST("/deny/:id", handlers.DenyPromptHandler)
+	api.POST("/generate-report", handlers.GenerateReportHandler)
 
 	// 7. Start server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Listening on :%s", port)
-	log.Fatal(r.Run(":" + port))
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Listening on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server failed: %v", err)
+		}
+	}()
+
+	// 8. Graceful shutdown: finish in-flight HTTP requests and AI jobs. Jobs still
+	// running when the timeout hits are put back in the queue for the next start.
+	<-ctx.Done()
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ HTTP shutdown: %v", err)
+	}
+	if err := jobs.Default.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ job queue shutdown: %v", err)
+	}
 }
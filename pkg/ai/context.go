@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"context"
+	"strings"
+)
+
+// WindowLimits bounds how much conversation history is sent with each request.
+// A zero value disables that limit.
+type WindowLimits struct {
+	MaxMessages int
+	MaxTokens   int
+}
+
+// Window splits history into the oldest messages that no longer fit the limits
+// and the newest messages that do. reserved is the token cost of anything sent
+// alongside the history (system prompt, summary). The newest message is always
+// kept, even if it alone exceeds the token budget.
+func Window(history []Message, limits WindowLimits, reserved int) (dropped, kept []Message) {
+	tokens := reserved
+	start := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		n := len(history) - i
+		cost := EstimateTokens(history[i].Content)
+		if i < len(history)-1 {
+			if limits.MaxMessages > 0 && n > limits.MaxMessages {
+				break
+			}
+			if limits.MaxTokens > 0 && tokens+cost > limits.MaxTokens {
+				break
+			}
+		}
+		tokens += cost
+		start = i
+	}
+	return history[:start], history[start:]
+}
+
+// MergeTurns collapses consecutive messages from the same role so the result
+// alternates between user and assistant, as most chat models expect.
+func MergeTurns(msgs []Message) []Message {
+	var out []Message
+	for _, m := range msgs {
+		if n := len(out); n > 0 && out[n-1].Role == m.Role {
+			out[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// Summarize asks the provider to fold older turns into a running summary.
+// previous may be empty when nothing has been summarized yet. The response carries
+// the summary and the usage of the call.
+func Summarize(ctx context.Context, p Provider, previous string, turns []Message) (ChatResponse, error) {
+	var b strings.Builder
+	if previous != "" {
+		b.WriteString("Summary so far:\n")
+		b.WriteString(previous)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("New conversation turns:\n")
+	for _, m := range turns {
+		b.WriteString(m.Role)
+		b.WriteString(": ")
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+	}
+
+	resp, err := Complete(ctx, p, ChatRequest{
+		Messages: []Message{
+			{Role: RoleSystem, Content: "Summarize this conversation between a child and an AI assistant in a few sentences. " +
+				"Keep names, facts and open questions the assistant may need later. Reply with the summary only."},
+			{Role: RoleUser, Content: b.String()},
+		},
+	})
+	resp.Content = strings.TrimSpace(resp.Content)
+	return resp, err
+}
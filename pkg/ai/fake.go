@@ -0,0 +1,121 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeReply is one scripted answer. When Match is non-empty the reply is only
+// used if the last user message contains it (case-insensitive); an empty Match
+// acts as a catch-all. Replies are tried in order.
+type FakeReply struct {
+	Match string `json:"match"`
+	Reply string `json:"reply"`
+	Error string `json:"error,omitempty"`
+}
+
+// FakeProvider is a deterministic, offline provider for tests and local development.
+type FakeProvider struct {
+	mu      sync.Mutex
+	script  []FakeReply
+	Calls   []ChatRequest
+	ModelID string
+}
+
+// NewFake returns a fake provider that answers with the given script,
+// falling back to echoing the user's last message.
+func NewFake(script ...FakeReply) *FakeProvider {
+	return &FakeProvider{script: script, ModelID: "fake"}
+}
+
+// LoadFakeScript reads a JSON array of FakeReply values from path.
+func LoadFakeScript(path string) (*FakeProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script []FakeReply
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	return NewFake(script...), nil
+}
+
+func (f *FakeProvider) Name() string { return "fake" }
+
+func (f *FakeProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ModelListing: true}
+}
+
+// Chat records the request and returns the first matching scripted reply.
+func (f *FakeProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ChatResponse{}, err
+	}
+	f.mu.Lock()
+	f.Calls = append(f.Calls, req)
+	f.mu.Unlock()
+
+	last := lastUserMessage(req.Messages)
+	content := "You said: " + last
+	for _, s := range f.script {
+		if s.Match == "" || strings.Contains(strings.ToLower(last), strings.ToLower(s.Match)) {
+			if s.Error != "" {
+				return ChatResponse{}, fakeError(s.Error)
+			}
+			content = s.Reply
+			break
+		}
+	}
+
+	prompt := 0
+	for _, m := range req.Messages {
+		prompt += EstimateTokens(m.Content)
+	}
+	completion := EstimateTokens(content)
+	return ChatResponse{
+		Content: content,
+		Model:   f.ModelID,
+		Usage:   Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion},
+	}, nil
+}
+
+// ChatStream delivers the scripted reply one word at a time.
+func (f *FakeProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	resp, err := f.Chat(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	full := resp.Content
+	resp.Content = ""
+	for _, word := range strings.SplitAfter(full, " ") {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		resp.Content += word
+		if err := onDelta(word); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (f *FakeProvider) Models(ctx context.Context) ([]string, error) {
+	return []string{f.ModelID}, nil
+}
+
+type fakeError string
+
+func (e fakeError) Error() string { return string(e) }
+
+func lastUserMessage(msgs []Message) string {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == RoleUser {
+			return msgs[i].Content
+		}
+	}
+	return ""
+}
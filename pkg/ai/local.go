@@ -0,0 +1,26 @@
+package ai
+
+import (
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// defaultLocalModel is used when AI_MODEL is not set for a local endpoint.
+const defaultLocalModel = "llama3"
+
+// NewLocal returns a provider for a self-hosted OpenAI-compatible endpoint,
+// such as Ollama (http://localhost:11434/v1) or the llama.cpp server.
+// The API key is optional; most local servers ignore it.
+func NewLocal(baseURL, apiKey, model string) Provider {
+	if model == "" {
+		model = defaultLocalModel
+	}
+	cfg := openai.DefaultConfig(apiKey)
+	cfg.BaseURL = baseURL
+	return &openAIProvider{
+		name:   "local",
+		client: openai.NewClientWithConfig(cfg),
+		model:  model,
+		// Local servers generally lack the moderation endpoint.
+		caps: Capabilities{Streaming: true, ModelListing: true},
+	}
+}
@@ -2,35 +2,162 @@ package ai
 
 import (
 	"context"
-	"os"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
 
 	openai "github.com/sashabaranov/go-openai"
 )
 
-// OpenAIClient is the global client for interacting with OpenAI
-var OpenAIClient *openai.Client
+// openAIProvider talks to the OpenAI API, or to any server speaking the same protocol.
+type openAIProvider struct {
+	name   string
+	client *openai.Client
+	model  string
+	caps   Capabilities
+}
 
-// InitOpenAI initializes the OpenAI client using the environment variable API key.
-func InitOpenAI() {
-	OpenAIClient = openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+// NewOpenAI returns a provider backed by the hosted OpenAI API.
+func NewOpenAI(apiKey, model string) Provider {
+	if model == "" {
+		model = openai.GPT4Turbo
+	}
+	return &openAIProvider{
+		name:   "openai",
+		client: openai.NewClient(apiKey),
+		model:  model,
+		caps:   Capabilities{Streaming: true, Moderation: true, ModelListing: true},
+	}
 }
 
-// GenerateReport sends a chat completion request to the OpenAI API with the given prompt.
-// Returns the assistant's response or an error.
-func GenerateReport(prompt string) (string, error) {
-	resp, err := OpenAIClient.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    openai.GPT4Turbo,
-			Messages: []openai.ChatCompletionMessage{{Role: "user", Content: prompt}},
+func (p *openAIProvider) Name() string { return p.name }
+
+func (p *openAIProvider) Capabilities() Capabilities { return p.caps }
+
+// Chat sends a chat completion request and returns the first choice.
+func (p *openAIProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, p.completionRequest(req))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	out := ChatResponse{
+		Model: resp.Model,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
 		},
-	)
+	}
+	if len(resp.Choices) > 0 {
+		out.Content = resp.Choices[0].Message.Content
+	}
+	return out, nil
+}
+
+// ChatStream streams a chat completion, forwarding each content delta to onDelta.
+func (p *openAIProvider) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	creq := p.completionRequest(req)
+	creq.Stream = true
+	if p.name == "openai" {
+		// Only the hosted API reliably supports usage reporting on streams.
+		creq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, creq)
 	if err != nil {
-		return "", err
+		return ChatResponse{}, err
 	}
+	defer stream.Close()
 
-	if len(resp.Choices) == 0 {
-		return "", nil
+	out := ChatResponse{Model: creq.Model}
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			out.Content = content.String()
+			return out, err
+		}
+		if chunk.Usage != nil {
+			out.Usage = Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.TotalTokens,
+			}
+		}
+		if chunk.Model != "" {
+			out.Model = chunk.Model
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			out.Content = content.String()
+			return out, err
+		}
+	}
+	out.Content = content.String()
+	return out, nil
+}
+
+// Moderate runs text through the moderation endpoint.
+func (p *openAIProvider) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	resp, err := p.client.Moderations(ctx, openai.ModerationRequest{Input: text})
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	var out ModerationResult
+	for _, r := range resp.Results {
+		if !r.Flagged {
+			continue
+		}
+		out.Flagged = true
+		// Round-trip through JSON to get the API's category names.
+		var cats map[string]bool
+		data, _ := json.Marshal(r.Categories)
+		_ = json.Unmarshal(data, &cats)
+		for name, hit := range cats {
+			if hit {
+				out.Categories = append(out.Categories, name)
+			}
+		}
+	}
+	sort.Strings(out.Categories)
+	return out, nil
+}
+
+func (p *openAIProvider) completionRequest(req ChatRequest) openai.ChatCompletionRequest {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+	msgs := make([]openai.ChatCompletionMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+	}
+	return openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  msgs,
+		MaxTokens: req.MaxTokens,
+	}
+}
+
+// Models lists the model IDs visible to the configured API key.
+func (p *openAIProvider) Models(ctx context.Context) ([]string, error) {
+	list, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(list.Models))
+	for _, m := range list.Models {
+		ids = append(ids, m.ID)
 	}
-	return resp.Choices[0].Message.Content, nil
+	return ids, nil
 }
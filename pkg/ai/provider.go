@@ -0,0 +1,189 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Chat roles understood by every provider.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single chat turn sent to or returned by a provider.
+type Message struct {
+	Role    string
+	Content string
+}
+
+// ChatRequest is a provider-neutral chat completion request.
+// An empty Model means "use the provider's configured default".
+type ChatRequest struct {
+	Model     string
+	Messages  []Message
+	MaxTokens int
+}
+
+// Usage reports the tokens consumed by a single completion.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// ChatResponse is the assistant's reply plus accounting information.
+type ChatResponse struct {
+	Content string
+	Model   string
+	Usage   Usage
+}
+
+// Capabilities advertises optional features a provider supports.
+type Capabilities struct {
+	Streaming    bool
+	Moderation   bool
+	ModelListing bool
+}
+
+// Provider is implemented by every LLM backend the service can talk to.
+type Provider interface {
+	// Name identifies the provider in logs and audit events.
+	Name() string
+	// Chat runs a single chat completion.
+	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
+	// Models lists the model identifiers available to this provider.
+	Models(ctx context.Context) ([]string, error)
+	// Capabilities reports optional features supported by this provider.
+	Capabilities() Capabilities
+}
+
+// Default is the provider used by the HTTP handlers, selected at startup by InitProvider.
+var Default Provider
+
+// InitProvider selects and configures the global provider from the environment.
+//
+//	AI_PROVIDER   openai (default), local or fake
+//	AI_MODEL      model name; defaults depend on the provider
+//	AI_BASE_URL   base URL of an OpenAI-compatible endpoint (local only)
+//	AI_API_KEY    optional bearer key for the local endpoint
+//	AI_FAKE_SCRIPT optional JSON script of canned replies (fake only)
+func InitProvider() error {
+	p, err := NewProvider(os.Getenv("AI_PROVIDER"))
+	if err != nil {
+		return err
+	}
+	Default = p
+	return nil
+}
+
+// NewProvider builds the named provider using environment configuration.
+func NewProvider(name string) (Provider, error) {
+	model := os.Getenv("AI_MODEL")
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "openai":
+		key := os.Getenv("OPENAI_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY must be set for the openai provider")
+		}
+		return NewOpenAI(key, model), nil
+	case "local":
+		baseURL := os.Getenv("AI_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("AI_BASE_URL must be set for the local provider")
+		}
+		return NewLocal(baseURL, os.Getenv("AI_API_KEY"), model), nil
+	case "fake":
+		if path := os.Getenv("AI_FAKE_SCRIPT"); path != "" {
+			return LoadFakeScript(path)
+		}
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown AI_PROVIDER %q", name)
+	}
+}
+
+// GenerateReport sends a prompt to the default provider, after a system message
+// that sets the rules for the answer.
+func GenerateReport(ctx context.Context, system, prompt string) (ChatResponse, error) {
+	return Complete(ctx, Default, ChatRequest{
+		Messages: []Message{{Role: RoleSystem, Content: system}, {Role: RoleUser, Content: prompt}},
+	})
+}
+
+// Complete runs req on p like p.Chat, estimating the usage if p reports none.
+func Complete(ctx context.Context, p Provider, req ChatRequest) (ChatResponse, error) {
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Usage = EstimateUsage(req, resp)
+	return resp, nil
+}
+
+// EstimateTokens gives a rough token count (about four characters per token)
+// for providers that do not report usage.
+func EstimateTokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	return len(s)/4 + 1
+}
+
+// EstimateUsage returns resp.Usage, or an estimate from the text of req and resp
+// when the provider reported none, as local endpoints and cut-off streams may not.
+func EstimateUsage(req ChatRequest, resp ChatResponse) Usage {
+	if resp.Usage.TotalTokens > 0 {
+		return resp.Usage
+	}
+	u := Usage{CompletionTokens: EstimateTokens(resp.Content)}
+	for _, m := range req.Messages {
+		u.PromptTokens += EstimateTokens(m.Content)
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// Streamer is implemented by providers that can deliver a completion incrementally.
+type Streamer interface {
+	// ChatStream runs a chat completion, calling onDelta with each chunk of text
+	// as it arrives. Returning an error from onDelta stops the stream. The
+	// returned response carries whatever content was assembled before the
+	// stream ended, even when an error is also returned.
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (ChatResponse, error)
+}
+
+// Stream runs req on p, streaming when the provider supports it and otherwise
+// delivering the full answer as a single delta. The usage is estimated if p reports
+// none and some answer arrived.
+func Stream(ctx context.Context, p Provider, req ChatRequest, onDelta func(delta string) error) (ChatResponse, error) {
+	if s, ok := p.(Streamer); ok && p.Capabilities().Streaming {
+		resp, err := s.ChatStream(ctx, req, onDelta)
+		if err == nil || resp.Content != "" {
+			resp.Usage = EstimateUsage(req, resp)
+		}
+		return resp, err
+	}
+	resp, err := Complete(ctx, p, req)
+	if err != nil {
+		return resp, err
+	}
+	if err := onDelta(resp.Content); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// ModerationResult is a provider's verdict on a piece of text.
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string // names of the categories that were flagged
+}
+
+// Moderator is implemented by providers with a content moderation endpoint.
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (ModerationResult, error)
+}
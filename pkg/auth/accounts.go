@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/permitio/permit-golang/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Account roles, matching the Permit roles of the same name.
+const (
+	RoleParent = "parent"
+	RoleAdmin  = "admin"
+	RoleAgent  = "ai-agent" // automation; authenticates with API tokens only
+)
+
+// Roles lists every account role.
+var Roles = []string{RoleParent, RoleAdmin, RoleAgent}
+
+// MinPasswordLength is the shortest password accepted for an account.
+const MinPasswordLength = 10
+
+// Login throttling: from throttleAfter failures in a row each attempt waits twice as
+// long as the one before, and maxFailures locks the account for lockoutPeriod.
+const (
+	throttleAfter = 3
+	maxFailures   = 10
+	lockoutPeriod = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown user or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrLocked is returned while an account refuses logins after failed attempts.
+	ErrLocked = errors.New("too many failed attempts, try again later")
+	// ErrWeakPassword is returned for passwords shorter than MinPasswordLength.
+	ErrWeakPassword = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+)
+
+// dummyHash is compared against when the user does not exist, so that unknown
+// usernames take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// HashPassword checks the password against the policy and returns its bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+// CreateUser adds an account with the given role and optional email address and
+// syncs it to Permit. Only ai-agent accounts may be created without a password.
+func CreateUser(ctx context.Context, username, email, password, role, createdBy string) error {
+	if username == "" {
+		return errors.New("username is required")
+	}
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if _, err := store.Default.Kids.Get(ctx, username); err == nil {
+		return fmt.Errorf("%q is already a kid's username", username)
+	}
+
+	u := store.User{Username: username, Role: role, Email: strings.ToLower(strings.TrimSpace(email)), CreatedBy: createdBy}
+	if password != "" || role != RoleAgent {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		u.PasswordHash, u.PasswordChangedAt = hash, &now
+	}
+	if _, err := store.Default.Users.Create(ctx, u); err != nil {
+		return err
+	}
+	SyncUser(ctx, u)
+	return nil
+}
+
+// SyncUser creates or updates the account in Permit, with its email address and
+// name when known, and assigns its role. Failures are logged, not returned, so that
+// a Permit outage does not block logins. Without a Permit client (e.g. in
+// create-admin) the sync is left to the first login.
+func SyncUser(ctx context.Context, u store.User) {
+	if PermitClient == nil {
+		return
+	}
+	uc := models.NewUserCreate(u.Username)
+	if u.Email != "" {
+		uc.Email = &u.Email
+	}
+	if u.Email != "" || u.Name != "" {
+		uc.Attributes = map[string]interface{}{"email": u.Email, "name": u.Name}
+	}
+	if _, err := PermitClient.Api.Users.SyncUser(ctx, *uc); err != nil {
+		log.Printf("Permit SyncUser failed for %s: %v", u.Username, err)
+		return
+	}
+	if _, err := PermitClient.Api.Users.AssignRole(ctx, u.Username, u.Role, "default"); err != nil {
+		log.Printf("Permit AssignRole %s failed for %s: %v", u.Role, u.Username, err)
+	}
+}
+
+// Authenticate checks a username and password. Failed attempts are counted per
+// account; see throttleAfter and maxFailures. For accounts with TOTP on, a correct
+// password gives ErrSecondFactorRequired and the failure count is only reset by
+// VerifySecondFactor.
+func Authenticate(ctx context.Context, username, password string) (store.User, error) {
+	u, err := store.Default.Users.Get(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return u, ErrInvalidCredentials
+	}
+	if err != nil {
+		return u, err
+	}
+	if u.Locked(time.Now()) {
+		return u, ErrLocked
+	}
+
+	if u.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return u, loginFailed(ctx, u.Username)
+	}
+	if on, err := MFAEnabled(ctx, u.Username); err != nil {
+		return u, err
+	} else if on {
+		return u, ErrSecondFactorRequired
+	}
+	if err := store.Default.Users.LoginSucceeded(ctx, u.Username); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// loginFailed counts the failure, throttles or locks the account as needed and
+// returns the error to report.
+func loginFailed(ctx context.Context, username string) error {
+	n, err := store.Default.Users.LoginFailed(ctx, username)
+	if err != nil {
+		return err
+	}
+	var wait time.Duration
+	switch {
+	case n >= maxFailures:
+		wait = lockoutPeriod
+		if err := store.Default.Audit.LogEvent(ctx, "account_locked", username); err != nil {
+			log.Printf("⚠️ Authenticate: failed to log lockout of %s: %v", username, err)
+		}
+	case n >= throttleAfter:
+		wait = time.Second << (n - throttleAfter)
+	default:
+		return ErrInvalidCredentials
+	}
+	if err := store.Default.Users.Lock(ctx, username, time.Now().Add(wait)); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// ChangePassword replaces a user's password after checking the current one and
+// ends their other sessions, keeping the one with token keepSession.
+func ChangePassword(ctx context.Context, username, current, next, keepSession string) error {
+	if _, err := Authenticate(ctx, username, current); err != nil && !errors.Is(err, ErrSecondFactorRequired) {
+		return err
+	}
+	return setPassword(ctx, username, next, keepSession)
+}
+
+// SetPassword replaces a user's password without checking the old one, e.g. for an
+// admin reset. It also lifts any lockout and ends all the user's sessions.
+func SetPassword(ctx context.Context, username, password string) error {
+	return setPassword(ctx, username, password, "")
+}
+
+func setPassword(ctx context.Context, username, password, keepSession string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := store.Default.Users.SetPassword(ctx, username, hash); err != nil {
+		return err
+	}
+	return EndUserSessions(ctx, username, keepSession)
+}
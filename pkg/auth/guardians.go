@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	permiterrors "github.com/permitio/permit-golang/pkg/errors"
+	"github.com/permitio/permit-golang/pkg/models"
+)
+
+// Guardianship in Permit: every kid is an instance of KidResource and every parent or
+// admin account an instance of AccountResource that its user holds RoleOwner on.
+// The tuple account:<parent> guardian kid:<kid> lets Permit derive the guardian role
+// on the kid, which grants per-kid actions such as prompt_requests.approve.
+const (
+	KidResource      = "kid"
+	AccountResource  = "account"
+	RoleOwner        = "owner"
+	RelationGuardian = "guardian"
+)
+
+// instance names a Permit resource instance, e.g. "kid:bob".
+func instance(resource, key string) string {
+	return resource + ":" + key
+}
+
+// exists reports whether a Permit API error only says the object is already there.
+func exists(err error) bool {
+	var pe permiterrors.PermitError
+	return errors.As(err, &pe) && pe.ErrorCode == permiterrors.Conflict
+}
+
+// SyncKid creates the kid's resource instance in Permit. Like SyncUser, failures are
+// logged and not returned.
+func SyncKid(ctx context.Context, kid string) {
+	if PermitClient == nil {
+		return
+	}
+	if _, err := PermitClient.Api.ResourceInstances.Create(ctx, *models.NewResourceInstanceCreate(kid, KidResource)); err != nil && !exists(err) {
+		log.Printf("Permit sync of kid %s failed: %v", kid, err)
+	}
+}
+
+// syncAccount creates the account's resource instance and makes the user its owner,
+// so that guardian tuples on the account apply to them.
+func syncAccount(ctx context.Context, username string) {
+	if _, err := PermitClient.Api.ResourceInstances.Create(ctx, *models.NewResourceInstanceCreate(username, AccountResource)); err != nil && !exists(err) {
+		log.Printf("Permit sync of account %s failed: %v", username, err)
+		return
+	}
+	if _, err := PermitClient.Api.Users.AssignResourceRole(ctx, username, RoleOwner, "default", instance(AccountResource, username)); err != nil && !exists(err) {
+		log.Printf("Permit AssignResourceRole %s failed for %s: %v", RoleOwner, username, err)
+	}
+}
+
+// SyncGuardian records in Permit that guardian looks after kid.
+func SyncGuardian(ctx context.Context, kid, guardian string) {
+	if PermitClient == nil {
+		return
+	}
+	SyncKid(ctx, kid)
+	syncAccount(ctx, guardian)
+	tuple := models.NewRelationshipTupleCreate(instance(AccountResource, guardian), RelationGuardian, instance(KidResource, kid))
+	if _, err := PermitClient.Api.RelationshipTuples.Create(ctx, *tuple); err != nil && !exists(err) {
+		log.Printf("Permit sync of guardian %s for %s failed: %v", guardian, kid, err)
+	}
+}
+
+// UnsyncGuardian removes the guardian tuple for kid from Permit.
+func UnsyncGuardian(ctx context.Context, kid, guardian string) {
+	if PermitClient == nil {
+		return
+	}
+	tuple := models.NewRelationshipTupleDelete(instance(AccountResource, guardian), RelationGuardian, instance(KidResource, kid))
+	if err := PermitClient.Api.RelationshipTuples.Delete(ctx, *tuple); err != nil {
+		log.Printf("Permit removal of guardian %s for %s failed: %v", guardian, kid, err)
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Kinds of kid login secret.
+const (
+	SecretPIN     = "pin"     // 4 to 8 digits
+	SecretPicture = "picture" // 3 to 6 pictures in order
+)
+
+// Picture is one choice in a picture password.
+type Picture struct {
+	Key   string
+	Emoji string
+}
+
+// Pictures are the choices kids pick their picture passwords from.
+var Pictures = []Picture{
+	{"dog", "🐶"}, {"cat", "🐱"}, {"fox", "🦊"},
+	{"frog", "🐸"}, {"lion", "🦁"}, {"panda", "🐼"},
+	{"penguin", "🐧"}, {"turtle", "🐢"}, {"owl", "🦉"},
+}
+
+// Kid secrets are short, so kids are locked out sooner than accounts: from
+// kidThrottleAfter failures each attempt waits twice as long as the one before, and
+// kidMaxFailures locks the kid out for lockoutPeriod and notifies their guardians.
+const (
+	kidThrottleAfter = 3
+	kidMaxFailures   = 5
+)
+
+// LoginCodeTTL is how long a one-time login code stays valid.
+const LoginCodeTTL = 15 * time.Minute
+
+// Login codes use letters and digits that are hard to mix up.
+const (
+	loginCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	loginCodeLength   = 8
+)
+
+// normalizeSecret validates a PIN or a comma-separated picture sequence and returns
+// the form that is hashed.
+func normalizeSecret(kind, secret string) (string, error) {
+	switch kind {
+	case SecretPIN:
+		secret = strings.TrimSpace(secret)
+		if len(secret) < 4 || len(secret) > 8 || strings.Trim(secret, "0123456789") != "" {
+			return "", errors.New("PIN must be 4 to 8 digits")
+		}
+		return secret, nil
+	case SecretPicture:
+		picks := strings.Split(secret, ",")
+		if len(picks) < 3 || len(picks) > 6 {
+			return "", errors.New("picture password must be 3 to 6 pictures")
+		}
+		for _, p := range picks {
+			if !slices.ContainsFunc(Pictures, func(x Picture) bool { return x.Key == p }) {
+				return "", fmt.Errorf("unknown picture %q", p)
+			}
+		}
+		return strings.Join(picks, ","), nil
+	}
+	return "", fmt.Errorf("unknown secret kind %q", kind)
+}
+
+// SetKidSecret sets the kid's PIN or picture password, lifts any lockout and logs
+// the kid out everywhere.
+func SetKidSecret(ctx context.Context, kid, kind, secret, setBy string) error {
+	secret, err := normalizeSecret(kind, secret)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(kind+":"+secret), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := store.Default.KidCredentials.Set(ctx, kid, kind, string(hash), setBy); err != nil {
+		return err
+	}
+	return EndKidSessions(ctx, kid)
+}
+
+// AuthenticateKid checks a kid's PIN or picture password. Kids without a secret
+// cannot log in this way and get ErrInvalidCredentials, as do wrong secrets.
+func AuthenticateKid(ctx context.Context, kid, kind, secret string) error {
+	cred, err := store.Default.KidCredentials.Get(ctx, kid)
+	if errors.Is(err, store.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(secret))
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if cred.Locked(time.Now()) {
+		return ErrLocked
+	}
+
+	normalized, err := normalizeSecret(kind, secret)
+	if err != nil || cred.Kind != kind ||
+		bcrypt.CompareHashAndPassword([]byte(cred.SecretHash), []byte(kind+":"+normalized)) != nil {
+		return kidLoginFailed(ctx, kid)
+	}
+	return store.Default.KidCredentials.Unlock(ctx, kid)
+}
+
+// kidLoginFailed counts the failure, throttles or locks the kid out as needed and
+// returns the error to report.
+func kidLoginFailed(ctx context.Context, kid string) error {
+	n, err := store.Default.KidCredentials.LoginFailed(ctx, kid)
+	if err != nil {
+		return err
+	}
+	var wait time.Duration
+	switch {
+	case n >= kidMaxFailures:
+		wait = lockoutPeriod
+		if err := store.Default.Audit.LogEvent(ctx, "kid_locked", kid); err != nil {
+			log.Printf("⚠️ AuthenticateKid: failed to log lockout of %s: %v", kid, err)
+		}
+		NotifyGuardians(ctx, kid, "kid_locked", fmt.Sprintf(
+			"%s was locked out after %d wrong login attempts. Unlock them or set a new PIN or picture password on the Kids page.", kid, n))
+	case n >= kidThrottleAfter:
+		wait = time.Second << (n - kidThrottleAfter)
+	default:
+		return ErrInvalidCredentials
+	}
+	if err := store.Default.KidCredentials.Lock(ctx, kid, time.Now().Add(wait)); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// NotifyGuardians leaves a notification for each of the kid's guardians, or for the
+// admins if the kid has none. Failures are logged.
+func NotifyGuardians(ctx context.Context, kid, kind, message string) {
+	recipients, err := store.Default.Guardians.Of(ctx, kid)
+	if err == nil && len(recipients) == 0 {
+		recipients, err = admins(ctx)
+	}
+	if err != nil {
+		log.Printf("⚠️ NotifyGuardians: %s: %v", kid, err)
+		return
+	}
+	for _, r := range recipients {
+		if err := store.Default.Notifications.Add(ctx, r, kind, message); err != nil {
+			log.Printf("⚠️ NotifyGuardians: failed to notify %s about %s: %v", r, kid, err)
+		}
+	}
+}
+
+// NotifyAdmins leaves a notification for each admin. Failures are logged.
+func NotifyAdmins(ctx context.Context, kind, message string) {
+	recipients, err := admins(ctx)
+	if err != nil {
+		log.Printf("⚠️ NotifyAdmins: %v", err)
+		return
+	}
+	for _, r := range recipients {
+		if err := store.Default.Notifications.Add(ctx, r, kind, message); err != nil {
+			log.Printf("⚠️ NotifyAdmins: failed to notify %s: %v", r, err)
+		}
+	}
+}
+
+// admins returns the usernames of the admin accounts.
+func admins(ctx context.Context) ([]string, error) {
+	users, err := store.Default.Users.List(ctx)
+	var out []string
+	for _, u := range users {
+		if u.Role == RoleAdmin {
+			out = append(out, u.Username)
+		}
+	}
+	return out, err
+}
+
+// IssueLoginCode creates a one-time login code for kid, valid for LoginCodeTTL.
+func IssueLoginCode(ctx context.Context, kid, issuedBy string) (string, time.Time, error) {
+	code, err := randomCode(loginCodeLength)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(LoginCodeTTL)
+	if _, err := store.Default.KidLoginCodes.Create(ctx, kid, HashToken(code), issuedBy, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expires, nil
+}
+
+// randomCode returns n random characters from loginCodeAlphabet.
+func randomCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = loginCodeAlphabet[int(b)%len(loginCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// normalizeCode strips the spaces and dashes people type into codes and upper-cases
+// them.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// RedeemLoginCode uses up a login code and returns the kid it was issued for.
+// Spaces, dashes and case are ignored.
+func RedeemLoginCode(ctx context.Context, code string) (string, error) {
+	kid, err := store.Default.KidLoginCodes.Redeem(ctx, HashToken(normalizeCode(code)))
+	if errors.Is(err, store.ErrNotFound) {
+		return "", ErrInvalidCredentials
+	}
+	return kid, err
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// totpIssuer names the app in authenticator apps.
+const totpIssuer = "Data Sentinel"
+
+// TOTP parameters, the defaults every authenticator app supports. A code from the
+// previous or next period is accepted too, to allow for clock drift.
+var totpOpts = totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// Recovery codes: recoveryCodeCount of them, each recoveryCodeLength characters from
+// loginCodeAlphabet, shown in two halves.
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 12
+)
+
+// SettingMFARequired is the settings key that, when "true", makes every parent and
+// admin account enroll in TOTP.
+const SettingMFARequired = "mfa_required"
+
+var (
+	// ErrSecondFactorRequired is returned by Authenticate for a correct password when
+	// the account also needs a TOTP or recovery code; see VerifySecondFactor.
+	ErrSecondFactorRequired = errors.New("second factor required")
+	// ErrMFAEnabled is returned when starting enrollment while TOTP is already on.
+	ErrMFAEnabled = errors.New("two-factor authentication is already on; turn it off first")
+)
+
+// MFARequired reports whether an admin made TOTP mandatory.
+func MFARequired(ctx context.Context) (bool, error) {
+	v, err := store.Default.Settings.Get(ctx, SettingMFARequired)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return v == "true", err
+}
+
+// SetMFARequired makes TOTP mandatory or optional.
+func SetMFARequired(ctx context.Context, required bool, setBy string) error {
+	v := "false"
+	if required {
+		v = "true"
+	}
+	return store.Default.Settings.Set(ctx, SettingMFARequired, v, setBy)
+}
+
+// MFAEnabled reports whether the account has completed TOTP enrollment.
+func MFAEnabled(ctx context.Context, username string) (bool, error) {
+	t, err := store.Default.TOTP.Get(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return t.EnabledAt != nil, err
+}
+
+// BeginTOTP creates a new key for the account with enrollment pending until
+// EnableTOTP confirms it.
+func BeginTOTP(ctx context.Context, username string) error {
+	if on, err := MFAEnabled(ctx, username); err != nil {
+		return err
+	} else if on {
+		return ErrMFAEnabled
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: username,
+		Period:      uint(totpOpts.Period),
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return err
+	}
+	return store.Default.TOTP.Begin(ctx, username, key.Secret())
+}
+
+// TOTPQRCode returns the provisioning QR code of a key as a PNG data URL, for
+// scanning into an authenticator app.
+func TOTPQRCode(username, secret string) (template.URL, error) {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	key, err := otp.NewKeyFromURL("otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + v.Encode())
+	if err != nil {
+		return "", err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// EnableTOTP completes enrollment with a code from the pending key and returns a
+// fresh set of recovery codes, which cannot be shown again.
+func EnableTOTP(ctx context.Context, username, code string) ([]string, error) {
+	t, err := store.Default.TOTP.Get(ctx, username)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errors.New("start two-factor setup first")
+	} else if err != nil {
+		return nil, err
+	}
+	if t.EnabledAt != nil {
+		return nil, ErrMFAEnabled
+	}
+	if err := useTOTPCode(ctx, t, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err = store.Default.InTx(ctx, func(tx store.Repos) error {
+		if err := tx.TOTP.Enable(ctx, username); err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(ctx, tx, username)
+		return err
+	})
+	return codes, err
+}
+
+// RegenerateRecoveryCodes replaces the account's recovery codes after checking a
+// current TOTP code.
+func RegenerateRecoveryCodes(ctx context.Context, username, code string) ([]string, error) {
+	t, err := store.Default.TOTP.Get(ctx, username)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && t.EnabledAt == nil) {
+		return nil, errors.New("two-factor authentication is off")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := useTOTPCode(ctx, t, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err = store.Default.InTx(ctx, func(tx store.Repos) error {
+		codes, err = replaceRecoveryCodes(ctx, tx, username)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP turns TOTP off for the account and deletes its recovery codes.
+func DisableTOTP(ctx context.Context, username string) error {
+	return store.Default.InTx(ctx, func(tx store.Repos) error {
+		if err := tx.TOTP.Delete(ctx, username); err != nil {
+			return err
+		}
+		return tx.RecoveryCodes.DeleteAll(ctx, username)
+	})
+}
+
+// VerifySecondFactor checks a TOTP code, or a recovery code, for an account whose
+// password was accepted, and reports whether a recovery code was used up. Failures
+// count towards the account's lockout like wrong passwords.
+func VerifySecondFactor(ctx context.Context, username, code string) (recovery bool, err error) {
+	u, err := store.Default.Users.Get(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	if u.Locked(time.Now()) {
+		return false, ErrLocked
+	}
+	t, err := store.Default.TOTP.Get(ctx, username)
+	if err != nil {
+		return false, err
+	}
+
+	code = normalizeCode(code)
+	if len(code) == totpOpts.Digits.Length() {
+		err = useTOTPCode(ctx, t, code)
+	} else {
+		recovery = true
+		err = store.Default.RecoveryCodes.Use(ctx, username, HashToken(code))
+		if errors.Is(err, store.ErrNotFound) {
+			err = ErrInvalidCredentials
+		}
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		return false, loginFailed(ctx, username)
+	} else if err != nil {
+		return false, err
+	}
+	return recovery, store.Default.Users.LoginSucceeded(ctx, username)
+}
+
+// useTOTPCode checks code against the key and marks its time step used, so the same
+// code cannot be replayed. Wrong and reused codes give ErrInvalidCredentials.
+func useTOTPCode(ctx context.Context, t store.UserTOTP, code string) error {
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	for _, drift := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(drift*int64(totpOpts.Period)) * time.Second)
+		want, err := totp.GenerateCodeCustom(t.Secret, at, totpOpts)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			err := store.Default.TOTP.UseStep(ctx, t.Username, at.Unix()/int64(totpOpts.Period))
+			if errors.Is(err, store.ErrNotFound) {
+				return ErrInvalidCredentials
+			}
+			return err
+		}
+	}
+	return ErrInvalidCredentials
+}
+
+// replaceRecoveryCodes stores new recovery codes for the account in tx and returns
+// them formatted for display.
+func replaceRecoveryCodes(ctx context.Context, tx store.Repos, username string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		c, err := randomCode(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = c[:recoveryCodeLength/2] + "-" + c[recoveryCodeLength/2:]
+		hashes[i] = HashToken(c)
+	}
+	return codes, tx.RecoveryCodes.Replace(ctx, username, hashes)
+}
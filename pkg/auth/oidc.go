@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// oidcTimeout bounds each request to the identity provider.
+const oidcTimeout = 10 * time.Second
+
+var (
+	// ErrNoAccount is returned for an OpenID Connect login that matches no account
+	// when new accounts are not created for it; see OIDC_DEFAULT_ROLE and
+	// OIDC_ALLOWED_EMAILS.
+	ErrNoAccount = errors.New("there is no account for this login; ask an admin to add one")
+	// ErrUnverifiedEmail is returned when the identity provider does not vouch for
+	// the email address that would link or create an account.
+	ErrUnverifiedEmail = errors.New("your identity provider did not confirm your email address")
+)
+
+// OIDC is the OpenID Connect provider parents and admins can log in with, or nil
+// when OIDC_ISSUER is unset.
+var OIDC *OIDCProvider
+
+// OIDCProvider logs accounts in with the authorization code flow and PKCE.
+type OIDCProvider struct {
+	Name string // shown on the login button
+
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	client      *http.Client
+	trustEmail  bool              // email_verified is not required
+	roleClaim   string            // claim holding group or role names
+	roleMap     map[string]string // claim value to account role
+	defaultRole string            // role of new accounts without a mapped value; "" creates none
+	allowed     []string          // addresses and @domains that may get new accounts; empty allows all
+}
+
+// InitOIDC discovers the provider at OIDC_ISSUER and sets OIDC. Without OIDC_ISSUER
+// OpenID Connect login stays off.
+func InitOIDC() error {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	p := &OIDCProvider{
+		Name:        os.Getenv("OIDC_NAME"),
+		client:      &http.Client{Timeout: oidcTimeout},
+		trustEmail:  os.Getenv("OIDC_TRUST_EMAIL") == "true",
+		roleClaim:   os.Getenv("OIDC_ROLE_CLAIM"),
+		roleMap:     map[string]string{},
+		defaultRole: os.Getenv("OIDC_DEFAULT_ROLE"),
+	}
+	if p.Name == "" {
+		p.Name = "single sign-on"
+	}
+	for _, e := range strings.Split(os.Getenv("OIDC_ALLOWED_EMAILS"), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			p.allowed = append(p.allowed, e)
+		}
+	}
+	// Anyone the provider lets log in would get a default role, so it needs a list
+	// of who may.
+	switch p.defaultRole {
+	case "none":
+		p.defaultRole = ""
+	case "":
+	case RoleParent, RoleAdmin:
+		if len(p.allowed) == 0 {
+			return errors.New("OIDC_DEFAULT_ROLE needs OIDC_ALLOWED_EMAILS to limit who gets an account")
+		}
+	default:
+		return fmt.Errorf("OIDC_DEFAULT_ROLE: unknown role %q", p.defaultRole)
+	}
+	for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAP"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		value, role, ok := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		if !ok || (role != RoleParent && role != RoleAdmin) {
+			return fmt.Errorf("OIDC_ROLE_MAP: %q is not value=parent or value=admin", pair)
+		}
+		p.roleMap[strings.TrimSpace(value)] = role
+	}
+	if len(p.roleMap) > 0 && p.roleClaim == "" {
+		p.roleClaim = "groups"
+	}
+
+	clientID, redirectURL := os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER")
+	}
+	// the provider keeps the context for fetching signing keys later on
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), p.client), issuer)
+	if err != nil {
+		return err
+	}
+	scopes := strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " "))
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+	p.oauth = oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"), // empty for public clients
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: clientID})
+	OIDC = p
+	return nil
+}
+
+// NewOIDCLogin returns the random state, nonce and PKCE verifier of a login attempt.
+// They are kept in the session until the callback.
+func NewOIDCLogin() (state, nonce, verifier string) {
+	return oauth2.GenerateVerifier(), oauth2.GenerateVerifier(), oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL returns the provider's login page for a login attempt.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// oidcClaims are the ID token claims used to find, create and update accounts.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Login exchanges the authorization code from the callback, verifies the ID token
+// and returns the linked account, linking or creating one by email address on the
+// first login. Like Authenticate it gives ErrSecondFactorRequired for accounts with
+// TOTP on.
+func (p *OIDCProvider) Login(ctx context.Context, code, nonce, verifier string) (store.User, error) {
+	ctx = oidc.ClientContext(ctx, p.client)
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return store.User{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return store.User{}, errors.New("no id_token in token response")
+	}
+	idt, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return store.User{}, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if idt.Nonce != nonce {
+		return store.User{}, errors.New("ID token nonce does not match")
+	}
+	var claims oidcClaims
+	var all map[string]any
+	if err := idt.Claims(&claims); err != nil {
+		return store.User{}, err
+	}
+	if err := idt.Claims(&all); err != nil {
+		return store.User{}, err
+	}
+	claims.Email = strings.ToLower(strings.TrimSpace(claims.Email))
+	role, mapped := p.mapRole(all)
+
+	u, ident, err := p.account(ctx, idt.Issuer, idt.Subject, claims, role)
+	if err != nil {
+		return u, err
+	}
+	if u.Role == RoleAgent {
+		return u, ErrInvalidCredentials // automation accounts use API tokens
+	}
+	if u.Locked(time.Now()) {
+		return u, ErrLocked
+	}
+	if mapped && role != u.Role {
+		if err := p.changeRole(ctx, u, role); err != nil {
+			return u, err
+		}
+		u.Role = role
+	}
+	// An address the provider does not vouch for must not replace the one an
+	// account is found by.
+	email := u.Email
+	if p.emailVerified(claims) {
+		email = claims.Email
+	}
+	if email != u.Email || (claims.Name != "" && claims.Name != u.Name) {
+		if claims.Name != "" {
+			u.Name = claims.Name
+		}
+		u.Email = email
+		if err := store.Default.Users.SetProfile(ctx, u.Username, u.Email, u.Name); err != nil {
+			return u, err
+		}
+	}
+	if err := store.Default.Identities.LoginSucceeded(ctx, ident.ID, claims.Email); err != nil {
+		return u, err
+	}
+
+	if on, err := MFAEnabled(ctx, u.Username); err != nil {
+		return u, err
+	} else if on {
+		return u, ErrSecondFactorRequired
+	}
+	return u, store.Default.Users.LoginSucceeded(ctx, u.Username)
+}
+
+// emailVerified reports whether claims carry an email address that may be trusted.
+func (p *OIDCProvider) emailVerified(claims oidcClaims) bool {
+	return claims.Email != "" && (p.trustEmail || (claims.EmailVerified != nil && *claims.EmailVerified))
+}
+
+// mapRole returns the account role for the ID token's role claim, and whether a
+// value of it was mapped. Admin wins over parent. Without a mapped value the
+// default role is returned.
+func (p *OIDCProvider) mapRole(claims map[string]any) (string, bool) {
+	var values []string
+	switch v := claims[p.roleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role := ""
+	for _, v := range values {
+		if r := p.roleMap[v]; r == RoleAdmin || (r == RoleParent && role == "") {
+			role = r
+		}
+	}
+	if role == "" {
+		return p.defaultRole, false
+	}
+	return role, true
+}
+
+// mayCreate reports whether a new account may be created for email: it is listed in
+// OIDC_ALLOWED_EMAILS, its domain is listed as "@domain", or the list is empty.
+func (p *OIDCProvider) mayCreate(email string) bool {
+	if len(p.allowed) == 0 {
+		return true
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return slices.Contains(p.allowed, email) || slices.Contains(p.allowed, "@"+domain)
+}
+
+// account returns the account linked to the identity. On the first login the
+// identity is linked to the account whose email address is the verified email, or
+// else to a new account with that username and role if one may be created.
+func (p *OIDCProvider) account(ctx context.Context, issuer, subject string, claims oidcClaims, role string) (store.User, store.Identity, error) {
+	ident, err := store.Default.Identities.Get(ctx, issuer, subject)
+	if err == nil {
+		u, err := store.Default.Users.Get(ctx, ident.Username)
+		return u, ident, err
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return store.User{}, ident, err
+	}
+
+	if !p.emailVerified(claims) {
+		return store.User{}, ident, ErrUnverifiedEmail
+	}
+	ident = store.Identity{Issuer: issuer, Subject: subject, Email: claims.Email}
+	// Only the email address links; usernames are not vouched for by anyone.
+	u, err := store.Default.Users.GetByEmail(ctx, claims.Email)
+	event := "oidc_identity_linked"
+	switch {
+	case errors.Is(err, store.ErrNotFound) && (role == "" || !p.mayCreate(claims.Email)):
+		return u, ident, ErrNoAccount
+	case errors.Is(err, store.ErrNotFound):
+		if _, err := store.Default.Kids.Get(ctx, claims.Email); err == nil {
+			return u, ident, fmt.Errorf("%q is already a kid's username", claims.Email)
+		}
+		u = store.User{Username: claims.Email, Role: role, Email: claims.Email, Name: claims.Name, CreatedBy: "oidc"}
+		event = "oidc_account_created"
+	case err != nil:
+		return u, ident, err
+	}
+
+	ident.Username = u.Username
+	err = store.Default.InTx(ctx, func(tx store.Repos) error {
+		if u.ID == 0 {
+			var err error
+			if u.ID, err = tx.Users.Create(ctx, u); err != nil {
+				return err
+			}
+		}
+		if err := tx.Identities.Link(ctx, ident); err != nil {
+			return err
+		}
+		ident, err = tx.Identities.Get(ctx, issuer, subject)
+		return err
+	})
+	if err != nil {
+		return u, ident, err
+	}
+	if err := store.Default.Audit.LogEvent(ctx, event, u.Username); err != nil {
+		log.Printf("⚠️ OIDC login: failed to log event for %s: %v", u.Username, err)
+	}
+	return u, ident, nil
+}
+
+// changeRole gives the account the role mapped from its claims, in the database
+// and in Permit; SyncUser assigns the new role at the end of the login.
+func (p *OIDCProvider) changeRole(ctx context.Context, u store.User, role string) error {
+	if err := store.Default.Users.SetRole(ctx, u.Username, role); err != nil {
+		return err
+	}
+	if PermitClient != nil {
+		if _, err := PermitClient.Api.Users.UnassignRole(ctx, u.Username, u.Role, "default"); err != nil {
+			log.Printf("Permit UnassignRole %s failed for %s: %v", u.Role, u.Username, err)
+		}
+	}
+	if err := store.Default.Audit.LogEvent(ctx, "oidc_role_changed_to_"+role, u.Username); err != nil {
+		log.Printf("⚠️ OIDC login: failed to log event for %s: %v", u.Username, err)
+	}
+	return nil
+}
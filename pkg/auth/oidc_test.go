@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// stubIssuer is an OpenID Connect provider that issues an ID token with the claims
+// registered for each authorization code.
+type stubIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]map[string]any // by code
+}
+
+var b64 = base64.RawURLEncoding
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{key: key, claims: map[string]map[string]any{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/authorize",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{map[string]string{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "k1",
+			"n": b64.EncodeToString(key.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.mu.Lock()
+		claims, ok := s.claims[r.PostForm.Get("code")]
+		s.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "token_type": "Bearer", "expires_in": 300, "id_token": s.sign(t, claims),
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// sign returns an RS256 ID token for the client "test-client" with claims.
+func (s *stubIssuer) sign(t *testing.T, claims map[string]any) string {
+	all := map[string]any{"iss": s.URL, "aud": "test-client", "iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix()}
+	for k, v := range claims {
+		all[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, err := json.Marshal(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// code registers claims and returns the authorization code that yields them.
+func (s *stubIssuer) code(claims map[string]any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := "code-" + claims["sub"].(string)
+	s.claims[code] = claims
+	return code
+}
+
+// useTestDB points store.Default at a migrated SQLite database for the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	prev := store.Default
+	store.Init(dbtest.Migrate(t, dbtest.SQLite(t)))
+	t.Cleanup(func() { store.Default = prev })
+}
+
+// initStubOIDC sets OIDC to the stub issuer with the given environment.
+func initStubOIDC(t *testing.T, iss *stubIssuer, env map[string]string) error {
+	t.Helper()
+	t.Setenv("OIDC_ISSUER", iss.URL)
+	t.Setenv("OIDC_CLIENT_ID", "test-client")
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost/login/oidc/callback")
+	for _, k := range []string{"OIDC_DEFAULT_ROLE", "OIDC_ALLOWED_EMAILS", "OIDC_ROLE_MAP", "OIDC_TRUST_EMAIL"} {
+		t.Setenv(k, env[k])
+	}
+	prev := OIDC
+	t.Cleanup(func() { OIDC = prev })
+	return InitOIDC()
+}
+
+func TestInitOIDCDefaultRoleNeedsAllowedEmails(t *testing.T) {
+	iss := newStubIssuer(t)
+	if err := initStubOIDC(t, iss, map[string]string{"OIDC_DEFAULT_ROLE": "parent"}); err == nil {
+		t.Error("OIDC_DEFAULT_ROLE=parent without OIDC_ALLOWED_EMAILS was accepted")
+	}
+	if err := initStubOIDC(t, iss, map[string]string{"OIDC_DEFAULT_ROLE": "parent", "OIDC_ALLOWED_EMAILS": "@example.com"}); err != nil {
+		t.Errorf("OIDC_DEFAULT_ROLE=parent with OIDC_ALLOWED_EMAILS: %v", err)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	// ann's account carries the email address; bob's username merely looks like one
+	if _, err := store.Default.Users.Create(ctx, store.User{Username: "ann", Role: RoleParent, Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Default.Users.Create(ctx, store.User{Username: "bob@example.com", Role: RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	iss := newStubIssuer(t)
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		claims   map[string]any
+		wantErr  error
+		wantUser string
+		wantRole string
+	}{
+		{
+			name:     "links by verified email",
+			claims:   map[string]any{"sub": "s-ann", "email": "Ann@Example.com", "email_verified": true},
+			wantUser: "ann", wantRole: RoleParent,
+		},
+		{
+			name:    "unverified email",
+			claims:  map[string]any{"sub": "s-ann2", "email": "ann@example.com", "email_verified": false},
+			wantErr: ErrUnverifiedEmail,
+		},
+		{
+			name:    "username is not linked",
+			claims:  map[string]any{"sub": "s-bob", "email": "bob@example.com", "email_verified": true},
+			wantErr: ErrNoAccount,
+		},
+		{
+			name:    "no account by default",
+			claims:  map[string]any{"sub": "s-cat", "email": "cat@example.com", "email_verified": true},
+			wantErr: ErrNoAccount,
+		},
+		{
+			name:     "default role for allowed domain",
+			env:      map[string]string{"OIDC_DEFAULT_ROLE": "parent", "OIDC_ALLOWED_EMAILS": "@family.example"},
+			claims:   map[string]any{"sub": "s-dan", "email": "dan@family.example", "email_verified": true},
+			wantUser: "dan@family.example", wantRole: RoleParent,
+		},
+		{
+			name:    "default role for other domain",
+			env:     map[string]string{"OIDC_DEFAULT_ROLE": "parent", "OIDC_ALLOWED_EMAILS": "@family.example"},
+			claims:  map[string]any{"sub": "s-eve", "email": "eve@elsewhere.example", "email_verified": true},
+			wantErr: ErrNoAccount,
+		},
+		{
+			name:     "mapped role",
+			env:      map[string]string{"OIDC_ROLE_MAP": "staff=admin"},
+			claims:   map[string]any{"sub": "s-fay", "email": "fay@example.com", "email_verified": true, "groups": []string{"staff"}},
+			wantUser: "fay@example.com", wantRole: RoleAdmin,
+		},
+		{
+			name:    "mapped role outside the allowed emails",
+			env:     map[string]string{"OIDC_ROLE_MAP": "staff=admin", "OIDC_ALLOWED_EMAILS": "ann@example.com"},
+			claims:  map[string]any{"sub": "s-gus", "email": "gus@example.com", "email_verified": true, "groups": []string{"staff"}},
+			wantErr: ErrNoAccount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := initStubOIDC(t, iss, tt.env); err != nil {
+				t.Fatal(err)
+			}
+			_, nonce, verifier := NewOIDCLogin()
+			tt.claims["nonce"] = nonce
+			u, err := OIDC.Login(ctx, iss.code(tt.claims), nonce, verifier)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Login error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			if u.Username != tt.wantUser || u.Role != tt.wantRole {
+				t.Errorf("Login = %s (%s), want %s (%s)", u.Username, u.Role, tt.wantUser, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRejectsWrongNonce(t *testing.T) {
+	useTestDB(t)
+	iss := newStubIssuer(t)
+	if err := initStubOIDC(t, iss, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, nonce, verifier := NewOIDCLogin()
+	code := iss.code(map[string]any{"sub": "s-x", "email": "x@example.com", "email_verified": true, "nonce": "other"})
+	if _, err := OIDC.Login(context.Background(), code, nonce, verifier); err == nil {
+		t.Error("Login accepted an ID token for another login attempt")
+	}
+}
+
+func TestOIDCLoginUpdatesOnlyVerifiedEmail(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	if _, err := store.Default.Users.Create(ctx, store.User{Username: "ann", Role: RoleParent, Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	iss := newStubIssuer(t)
+	if err := initStubOIDC(t, iss, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		claims    map[string]any
+		wantEmail string
+	}{
+		{map[string]any{"email": "ann@example.com", "email_verified": true}, "ann@example.com"},
+		{map[string]any{"email": "mallory@example.com", "email_verified": false}, "ann@example.com"},
+		{map[string]any{"email": "mallory@example.com"}, "ann@example.com"},
+		{map[string]any{}, "ann@example.com"},
+		{map[string]any{"email": "ann@family.example", "email_verified": true}, "ann@family.example"},
+	}
+	for i, s := range steps {
+		_, nonce, verifier := NewOIDCLogin()
+		s.claims["sub"], s.claims["nonce"] = "s-ann", nonce
+		if _, err := OIDC.Login(ctx, iss.code(s.claims), nonce, verifier); err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+		u, err := store.Default.Users.Get(ctx, "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Email != s.wantEmail {
+			t.Errorf("after login %d email = %q, want %q", i, u.Email, s.wantEmail)
+		}
+	}
+}
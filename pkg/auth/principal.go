@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/permitio/permit-golang/pkg/enforcement"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Kind tells what sort of account a principal is.
+type Kind string
+
+const (
+	KindUser Kind = "user" // a parent, admin or automation account
+	KindKid  Kind = "kid"
+)
+
+// How a principal was verified.
+const (
+	MethodSession = "session" // login cookie
+	MethodToken   = "token"   // API bearer token
+)
+
+// Principal is the verified identity a request acts as. Authorization checks use
+// its ID and never a name taken from the request parameters or body.
+type Principal struct {
+	ID      string // username, as synced to Permit
+	Kind    Kind
+	Role    string   // account role of a user, e.g. RoleAdmin
+	Method  string   // MethodSession or MethodToken
+	Scopes  []string // for tokens, the only actions the principal may perform
+	TokenID int64    // for tokens, the API token's ID
+}
+
+// IsKid reports whether the principal is a kid.
+func (p Principal) IsKid() bool { return p.Kind == KindKid }
+
+// IsSession reports whether the principal logged in through the browser.
+func (p Principal) IsSession() bool { return p.Method == MethodSession }
+
+// Can asks Permit whether the principal may perform action on resources of the given
+// type. Token principals are refused actions outside their scopes without asking.
+func (p Principal) Can(action, resourceType string) (bool, error) {
+	return p.CanOn(action, resourceType, "")
+}
+
+// CanOn is Can for a single resource instance, e.g. CanOn(action, KidResource, "bob").
+func (p Principal) CanOn(action, resourceType, key string) (bool, error) {
+	if p.Method == MethodToken && !slices.Contains(p.Scopes, action) {
+		return false, nil
+	}
+	user := enforcement.UserBuilder(p.ID).Build()
+	resource := enforcement.ResourceBuilder(resourceType)
+	if key != "" {
+		resource = resource.WithKey(key)
+	}
+	return PermitClient.Check(user, enforcement.Action(action), resource.Build())
+}
+
+// Resolver finds the principal for a request, reporting ok=false when its
+// credential is absent.
+type Resolver func(c *gin.Context) (p Principal, ok bool)
+
+// SessionPrincipal resolves the account or kid logged in through the session cookie.
+// An account's role is read from its row on every request, so a changed role
+// applies at once and a deleted account is logged out.
+func SessionPrincipal(c *gin.Context) (Principal, bool) {
+	sess := sessions.Default(c)
+	if username, ok := sess.Get("user").(string); ok && username != "" {
+		u, err := store.Default.Users.Get(c.Request.Context(), username)
+		if errors.Is(err, store.ErrNotFound) {
+			return Principal{}, false
+		} else if err != nil {
+			log.Printf("⚠️ SessionPrincipal: failed to load account %s: %v", username, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return Principal{}, false
+		}
+		return Principal{ID: u.Username, Kind: KindUser, Role: u.Role, Method: MethodSession}, true
+	}
+	if k, ok := sess.Get("kid").(string); ok && k != "" {
+		return Principal{ID: k, Kind: KindKid, Method: MethodSession}, true
+	}
+	return Principal{}, false
+}
+
+const principalKey = "principal"
+
+// Identify sets the principal from the first resolver that recognises the request.
+// Requests nobody recognises continue without one; a resolver may also abort the
+// request, e.g. for a bad credential.
+func Identify(resolvers ...Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, resolve := range resolvers {
+			p, ok := resolve(c)
+			if c.IsAborted() {
+				return
+			}
+			if ok {
+				c.Set(principalKey, p)
+				break
+			}
+		}
+		c.Next()
+	}
+}
+
+// CurrentPrincipal returns the principal set by Identify, if any.
+func CurrentPrincipal(c *gin.Context) (Principal, bool) {
+	p, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := p.(Principal)
+	return principal, ok
+}
+
+// MustPrincipal returns the principal of a request that passed RequirePrincipal
+// or a login-required middleware.
+func MustPrincipal(c *gin.Context) Principal {
+	return c.MustGet(principalKey).(Principal)
+}
+
+// RequirePrincipal rejects requests without a verified principal with 401.
+func RequirePrincipal(c *gin.Context) {
+	if _, ok := CurrentPrincipal(c); !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time, IP and device
+// are written back.
+const sessionTouchInterval = time.Minute
+
+// defaultSessionLifetime applies when the cookie options leave MaxAge at zero.
+const defaultSessionLifetime = 24 * time.Hour
+
+// anonymousPrefix starts the cookie of a session nobody has logged in to, which
+// carries its values sealed instead of a login_sessions token.
+const anonymousPrefix = "a."
+
+// SessionStore keeps browser sessions in the login_sessions table. The cookie only
+// carries a random token, so sessions can be listed and revoked server-side.
+// Sessions without an account or kid, e.g. the login page's, get no row; their few
+// values travel in the cookie, encrypted and signed.
+type SessionStore struct {
+	options *gsessions.Options
+	codecs  []securecookie.Codec // seal anonymous sessions
+}
+
+// NewSessionStore returns a SessionStore with the default cookie options. The keys
+// sealing anonymous sessions are derived from secret.
+func NewSessionStore(secret []byte) *SessionStore {
+	hashKey := sha256.Sum256(append([]byte("session hash key:"), secret...))
+	blockKey := sha256.Sum256(append([]byte("session block key:"), secret...))
+	s := &SessionStore{codecs: securecookie.CodecsFromPairs(hashKey[:], blockKey[:])}
+	s.Options(sessions.Options{Path: "/", MaxAge: int(defaultSessionLifetime.Seconds())})
+	return s
+}
+
+// Options sets the cookie options, including MaxAge, the session lifetime.
+func (s *SessionStore) Options(o sessions.Options) {
+	s.options = o.ToGorillaOptions()
+	for _, c := range s.codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok && o.MaxAge > 0 {
+			sc.MaxAge(o.MaxAge)
+		}
+	}
+}
+
+// Get returns the named session, loading it once per request.
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A missing, unknown, revoked
+// or expired session gives a new empty one.
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	sess := gsessions.NewSession(s, name)
+	opts := *s.options
+	sess.Options = &opts
+	sess.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return sess, nil
+	}
+	if sealed, ok := strings.CutPrefix(cookie.Value, anonymousPrefix); ok {
+		if err := securecookie.DecodeMulti(name, sealed, &sess.Values, s.codecs...); err == nil {
+			sess.IsNew = false
+		}
+		return sess, nil
+	}
+	ctx := r.Context()
+	row, err := store.Default.LoginSessions.GetByHash(ctx, HashToken(cookie.Value))
+	if errors.Is(err, store.ErrNotFound) {
+		return sess, nil
+	} else if err != nil {
+		// The sessions middleware cannot handle an error here; carry on logged out.
+		log.Printf("⚠️ SessionStore: failed to load session: %v", err)
+		return sess, nil
+	}
+	if err := decodeSessionValues(row.Data, &sess.Values); err != nil {
+		log.Printf("⚠️ SessionStore: failed to decode session %d: %v", row.ID, err)
+		return sess, nil
+	}
+	sess.ID = cookie.Value
+	sess.IsNew = false
+
+	if time.Since(row.LastSeenAt) > sessionTouchInterval {
+		if err := store.Default.LoginSessions.Touch(ctx, row.ID, requestIP(r), r.UserAgent()); err != nil {
+			log.Printf("⚠️ SessionStore: failed to touch session %d: %v", row.ID, err)
+		}
+	}
+	return sess, nil
+}
+
+// Save writes the session and sets its cookie, or deletes both when MaxAge is
+// negative. A session that changes identity, e.g. at login, gets a new token so
+// one planted beforehand is useless afterwards. Anonymous sessions only get a
+// cookie.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, sess *gsessions.Session) error {
+	ctx := r.Context()
+	if sess.Options.MaxAge < 0 {
+		if sess.ID != "" {
+			if err := store.Default.LoginSessions.DeleteByHash(ctx, HashToken(sess.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(sess.Name(), "", sess.Options))
+		return nil
+	}
+
+	username, _ := sess.Values["user"].(string)
+	kid, _ := sess.Values["kid"].(string)
+	if sess.ID != "" {
+		old, err := store.Default.LoginSessions.GetByHash(ctx, HashToken(sess.ID))
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			sess.ID = ""
+		case err != nil:
+			return err
+		case old.Username != username || old.KidUsername != kid:
+			if err := store.Default.LoginSessions.DeleteByHash(ctx, HashToken(sess.ID)); err != nil {
+				return err
+			}
+			sess.ID = ""
+		}
+	}
+	if username == "" && kid == "" {
+		sess.ID = ""
+		sealed, err := securecookie.EncodeMulti(sess.Name(), sess.Values, s.codecs...)
+		if err != nil {
+			return err
+		}
+		http.SetCookie(w, gsessions.NewCookie(sess.Name(), anonymousPrefix+sealed, sess.Options))
+		return nil
+	}
+	if sess.ID == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		sess.ID = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	lifetime := time.Duration(sess.Options.MaxAge) * time.Second
+	if lifetime == 0 {
+		lifetime = defaultSessionLifetime
+	}
+	data, err := encodeSessionValues(sess.Values)
+	if err != nil {
+		return err
+	}
+	err = store.Default.LoginSessions.Save(ctx, HashToken(sess.ID), store.LoginSession{
+		Username:    username,
+		KidUsername: kid,
+		Data:        data,
+		IP:          requestIP(r),
+		UserAgent:   r.UserAgent(),
+		ExpiresAt:   time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(sess.Name(), sess.ID, sess.Options))
+	return nil
+}
+
+func encodeSessionValues(values map[any]any) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeSessionValues(data string, values *map[any]any) error {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(values)
+}
+
+// clientIPKey is the request context key of the client address.
+type clientIPKey struct{}
+
+// RememberClientIP passes c.ClientIP(), which only believes X-Forwarded-For from
+// the engine's trusted proxies, on to the session store. Use it before the
+// sessions middleware.
+func RememberClientIP(c *gin.Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+	c.Next()
+}
+
+// requestIP returns the client address for display, as RememberClientIP found it.
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// EndUserSessions logs the account out everywhere except the session with token
+// keep, if given. Called when the account's password changes.
+func EndUserSessions(ctx context.Context, username, keep string) error {
+	keepHash := ""
+	if keep != "" {
+		keepHash = HashToken(keep)
+	}
+	n, err := store.Default.LoginSessions.DeleteForUser(ctx, username, keepHash)
+	if n > 0 {
+		log.Printf("ended %d sessions of %s", n, username)
+	}
+	return err
+}
+
+// EndKidSessions logs the kid out everywhere. Called when the kid's PIN or picture
+// password changes.
+func EndKidSessions(ctx context.Context, kid string) error {
+	n, err := store.Default.LoginSessions.DeleteForKid(ctx, kid)
+	if n > 0 {
+		log.Printf("ended %d sessions of %s", n, kid)
+	}
+	return err
+}
+
+// PurgeExpiredSessions deletes expired sessions every interval until ctx is done.
+func PurgeExpiredSessions(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := store.Default.LoginSessions.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Printf("⚠️ PurgeExpiredSessions: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d expired sessions", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -1,26 +1,134 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"os"
+	"log"
+	"strings"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// Store is a connection pool that speaks one Dialect. Its query methods accept
+// ? placeholders and rebind them for the underlying driver, so callers can use
+// it exactly like *sql.DB against either SQLite or PostgreSQL.
+type Store struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+var DB *Store
 
-func InitDB(dbPath, schemaPath string) error {
-	db, err := sql.Open("sqlite3", dbPath)
+// Open connects to dsn without touching its schema. A postgres:// URL selects
+// PostgreSQL; anything else is opened as a SQLite file.
+func Open(dsn string) (*Store, error) {
+	d := DialectFor(dsn)
+	if d == SQLite && !strings.Contains(dsn, "_busy_timeout") {
+		// Request handlers and the job workers write concurrently; wait for the
+		// lock instead of failing with "database is locked".
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_busy_timeout=5000"
+	}
+	db, err := sql.Open(d.driver(), dsn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &Store{DB: db, Dialect: d}, nil
+}
+
+func (s *Store) Exec(query string, args ...any) (sql.Result, error) {
+	return s.DB.Exec(s.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.DB.ExecContext(ctx, s.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.DB.Query(s.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.DB.QueryContext(ctx, s.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) QueryRow(query string, args ...any) *sql.Row {
+	return s.DB.QueryRow(s.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.DB.QueryRowContext(ctx, s.Dialect.Rebind(query), args...)
+}
+
+// BeginTx starts a transaction whose query methods also rebind placeholders.
+func (s *Store) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := s.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
+	return &Tx{Tx: tx, Dialect: s.Dialect}, nil
+}
+
+func (s *Store) Begin() (*Tx, error) {
+	return s.BeginTx(context.Background(), nil)
+}
+
+// Tx is a transaction on a Store.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.Dialect.Rebind(query), args...)
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.Dialect.Rebind(query), args...)
+}
+
+func (t *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.Dialect.Rebind(query), args...)
+}
+
+func (t *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.Dialect.Rebind(query), args...)
+}
 
-	schema, err := os.ReadFile(schemaPath)
+func (t *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.Dialect.Rebind(query), args...)
+}
+
+func (t *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.Dialect.Rebind(query), args...)
+}
+
+// InitDB opens the database and makes it the global DB. With autoMigrate set,
+// pending migrations are applied first; otherwise InitDB refuses to start on
+// an out-of-date schema.
+func InitDB(dsn string, autoMigrate bool) error {
+	db, err := Open(dsn)
 	if err != nil {
 		return err
 	}
+	if err := db.Ping(); err != nil {
+		return err
+	}
 
-	if _, err := db.Exec(string(schema)); err != nil {
+	if autoMigrate {
+		applied, err := MigrateUp(db)
+		for _, m := range applied {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := CheckSchema(db); err != nil {
 		return err
 	}
 
@@ -28,17 +136,3 @@ func InitDB(dbPath, schemaPath string) error {
 
 	return nil
 }
-
-func LogViolation(kid, prompt, violation string) {
-	DB.Exec("INSERT INTO violation_attempts(kid_username,prompt,violation) VALUES(?,?,?)",
-		kid, prompt, violation)
-}
-
-// LogEvent writes a generic audit event and returns any error it encounters.
-func LogEvent(eventType, username string) error {
-	_, err := DB.Exec(
-		"INSERT INTO audit_events(event_type, username) VALUES(?,?)",
-		eventType, username,
-	)
-	return err
-}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour of a connection. Queries are written once
+// with ? placeholders and portable syntax (ON CONFLICT, RETURNING); the few
+// expressions that differ go through Dialect helpers.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DSNFromEnv returns DATABASE_URL, falling back to the SQLite file in DB_PATH.
+func DSNFromEnv() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	return os.Getenv("DB_PATH")
+}
+
+// DialectFor picks the dialect from a DSN: postgres:// and postgresql:// URLs
+// select PostgreSQL, anything else is treated as a SQLite file path.
+func DialectFor(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
+	}
+	return SQLite
+}
+
+// driver is the database/sql driver name registered for the dialect.
+func (d Dialect) driver() string {
+	if d == Postgres {
+		return "pgx"
+	}
+	return "sqlite3"
+}
+
+// Rebind rewrites ? placeholders into $1, $2, ... for PostgreSQL. Question
+// marks inside quoted strings and identifiers are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// HoursAgo returns an expression for the current time minus the given number of hours,
+// suitable for comparing against timestamp columns.
+func (d Dialect) HoursAgo(hours int) string {
+	if d == Postgres {
+		return fmt.Sprintf("NOW() - INTERVAL '%d hours'", hours)
+	}
+	return fmt.Sprintf("datetime('now','-%d hours')", hours)
+}
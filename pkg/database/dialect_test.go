@@ -0,0 +1,27 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{"sqlite is left alone", SQLite, "SELECT * FROM kids WHERE username = ?", "SELECT * FROM kids WHERE username = ?"},
+		{"numbered in order", Postgres, "UPDATE t SET a = ?, b = ? WHERE id = ?", "UPDATE t SET a = $1, b = $2 WHERE id = $3"},
+		{"no placeholders", Postgres, "SELECT 1", "SELECT 1"},
+		{"string literal", Postgres, "SELECT '?' || ?", "SELECT '?' || $1"},
+		{"quoted identifier", Postgres, `SELECT "what?" FROM t WHERE a = ?`, `SELECT "what?" FROM t WHERE a = $1`},
+		{"escaped quote", Postgres, "SELECT 'it''s ?', ?", "SELECT 'it''s ?', $1"},
+		{"multibyte text", Postgres, "SELECT ? || '→?' || ?", "SELECT $1 || '→?' || $2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Rebind(tt.query); got != tt.want {
+				t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+var (
+	// ErrSchemaOutdated is returned when migrations are pending.
+	ErrSchemaOutdated = errors.New("database schema is out of date")
+	// ErrChecksumMismatch is returned when an applied migration was edited afterwards.
+	ErrChecksumMismatch = errors.New("applied migration checksum mismatch")
+)
+
+// Migration is one versioned schema change, loaded from
+// migrations/<dialect>/NNNN_name.{up,down}.sql. Both dialects keep the same
+// versions so a schema change always ships for SQLite and PostgreSQL together.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+// MigrationStatus pairs a known migration with its state in schema_migrations.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+	Modified  bool // applied checksum differs from the embedded file
+}
+
+var createMigrationsTable = map[Dialect]string{
+	SQLite: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    INTEGER PRIMARY KEY,
+  name       TEXT    NOT NULL,
+  checksum   TEXT    NOT NULL,
+  applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+	Postgres: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    INTEGER PRIMARY KEY,
+  name       TEXT    NOT NULL,
+  checksum   TEXT    NOT NULL,
+  applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+)`,
+}
+
+// Migrations returns the embedded migrations for a dialect ordered by version.
+func Migrations(d Dialect) ([]Migration, error) {
+	dir := "migrations/" + string(d)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		base := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction, base = "up", strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			direction, base = "down", strings.TrimSuffix(base, ".down.sql")
+		default:
+			continue
+		}
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: file name must look like 0001_name.up.sql", e.Name())
+		}
+		body, err := migrationFiles.ReadFile(dir + "/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// appliedMigrations reads schema_migrations keyed by version.
+func appliedMigrations(db *Store) (map[int]MigrationStatus, error) {
+	if _, err := db.Exec(createMigrationsTable[db.Dialect]); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]MigrationStatus{}
+	for rows.Next() {
+		var s MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &s.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		s.AppliedAt = appliedAt.Format(time.RFC3339)
+		out[s.Version] = s
+	}
+	return out, rows.Err()
+}
+
+// Status reports every known migration and whether it has been applied.
+// Versions recorded in the database but missing from the binary are included
+// with an empty body.
+func Status(db *Store) ([]MigrationStatus, error) {
+	all, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationStatus
+	for _, m := range all {
+		s := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+			s.Modified = a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		out = append(out, s)
+	}
+	for _, a := range applied {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated when migrations are pending and
+// ErrChecksumMismatch when an applied migration no longer matches its file.
+func CheckSchema(db *Store) error {
+	status, err := Status(db)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range status {
+		if s.Modified {
+			return fmt.Errorf("%w: %04d_%s", ErrChecksumMismatch, s.Version, s.Name)
+		}
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending %s", ErrSchemaOutdated, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied.
+func MigrateUp(db *Store) (done []Migration, err error) {
+	err = withMigrationLock(db, func() error {
+		done, err = migrateUp(db)
+		return err
+	})
+	return done, err
+}
+
+func migrateUp(db *Store) ([]Migration, error) {
+	status, err := Status(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, s := range status {
+		if s.Modified {
+			return done, fmt.Errorf("%w: %04d_%s", ErrChecksumMismatch, s.Version, s.Name)
+		}
+		if s.Applied {
+			continue
+		}
+		err := inTx(db, func(tx *Tx) error {
+			if err := addMissingColumns(tx, s.Version); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(s.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations(version, name, checksum) VALUES(?,?,?)",
+				s.Version, s.Name, s.Checksum)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", s.Version, s.Name, err)
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the most recent steps applied migrations and returns them.
+func MigrateDown(db *Store, steps int) (done []Migration, err error) {
+	err = withMigrationLock(db, func() error {
+		done, err = migrateDown(db, steps)
+		return err
+	})
+	return done, err
+}
+
+func migrateDown(db *Store, steps int) ([]Migration, error) {
+	status, err := Status(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(status) - 1; i >= 0 && len(done) < steps; i-- {
+		s := status[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return done, fmt.Errorf("migration %04d_%s has no down file", s.Version, s.Name)
+		}
+		err := inTx(db, func(tx *Tx) error {
+			if _, err := tx.Exec(s.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", s.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting %04d_%s: %w", s.Version, s.Name, err)
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// migrationLockKey is the PostgreSQL advisory lock held while migrating.
+const migrationLockKey = 0x6d6967726174 // "migrat"
+
+// withMigrationLock runs fn while holding the migration lock, so that instances
+// starting together with DB_AUTO_MIGRATE do not apply the same migration twice.
+// SQLite serializes writers itself.
+func withMigrationLock(db *Store, fn func() error) error {
+	if db.Dialect != Postgres {
+		return fn()
+	}
+	ctx := context.Background()
+	// Advisory locks belong to a session, so take and release it on one connection.
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	return fn()
+}
+
+// column is a column added to a table after it was first created.
+type column struct {
+	table, name, definition string
+}
+
+// sqliteColumns lists, by migration version, columns that SQLite databases
+// created from schema.sql before migrations existed may lack. 0001 leaves their
+// tables alone and SQLite has no ADD COLUMN IF NOT EXISTS, so MigrateUp adds
+// them before running the migration; the PostgreSQL files do it in SQL.
+var sqliteColumns = map[int][]column{
+	16: {{"chat_messages", "status", "TEXT NOT NULL DEFAULT 'complete'"}},
+}
+
+// addMissingColumns adds the sqliteColumns of a migration that are missing.
+func addMissingColumns(tx *Tx, version int) error {
+	if tx.Dialect != SQLite {
+		return nil
+	}
+	for _, col := range sqliteColumns[version] {
+		var n int
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", col.table, col.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := tx.Exec("ALTER TABLE " + col.table + " ADD COLUMN " + col.name + " " + col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inTx(db *Store, fn func(tx *Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
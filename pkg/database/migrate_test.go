@@ -0,0 +1,77 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTemp opens an empty SQLite database in a temporary directory.
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateUpFromBaselineSchema(t *testing.T) {
+	db := openTemp(t)
+	schema, err := os.ReadFile("testdata/baseline_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("creating baseline schema: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO chat_sessions(kid_username) VALUES('bob')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO chat_messages(session_id, sender, content) VALUES(1, 'kid', 'hi')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Fatalf("CheckSchema: %v", err)
+	}
+
+	var status string
+	if err := db.QueryRow("SELECT status FROM chat_messages WHERE id = 1").Scan(&status); err != nil {
+		t.Fatalf("reading status of an old message: %v", err)
+	}
+	if status != "complete" {
+		t.Errorf("old message status = %q, want complete", status)
+	}
+	if _, err := db.Exec("INSERT INTO chat_messages(session_id, sender, content, status) VALUES(1, 'ai', 'hello', 'partial')"); err != nil {
+		t.Errorf("inserting a message with a status: %v", err)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTemp(t)
+	all, err := Migrations(db.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	reverted, err := MigrateDown(db, len(all))
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if len(reverted) != len(all) {
+		t.Errorf("reverted %d migrations, want %d", len(reverted), len(all))
+	}
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp after reverting: %v", err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS moderation_incidents;
+DROP TABLE IF EXISTS chat_summaries;
+DROP TABLE IF EXISTS topic_rules;
+DROP TABLE IF EXISTS age_bands;
+DROP TABLE IF EXISTS topics;
+DROP INDEX IF EXISTS idx_violation_attempts_timestamp;
+DROP INDEX IF EXISTS idx_prompt_requests_created_at;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS violation_attempts;
+DROP TABLE IF EXISTS chat_messages;
+DROP TABLE IF EXISTS chat_sessions;
+DROP TABLE IF EXISTS prompt_requests;
+DROP TABLE IF EXISTS content_policies;
+DROP TABLE IF EXISTS kids;
+DROP TABLE IF EXISTS groups;
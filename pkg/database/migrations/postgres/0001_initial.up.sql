@@ -0,0 +1,146 @@
+-- Baseline schema; the PostgreSQL counterpart of sqlite/0001_initial.up.sql.
+
+-- RBAC groups for Permit.io “group” sync
+CREATE TABLE IF NOT EXISTS groups (
+  id   BIGSERIAL PRIMARY KEY,
+  name TEXT      UNIQUE NOT NULL
+);
+
+-- Who the kids are and how old they are
+CREATE TABLE IF NOT EXISTS kids (
+  username   TEXT PRIMARY KEY,
+  age        INTEGER NOT NULL
+);
+
+-- Legacy per-kid policies; rows are imported into topic_rules at startup and removed
+CREATE TABLE IF NOT EXISTS content_policies (
+  kid_username TEXT PRIMARY KEY,
+  allowed      TEXT,  -- comma-separated list of allowed topics
+  restricted   TEXT   -- comma-separated list of disallowed topics
+);
+
+-- Catalog of topics that policy rules can refer to
+CREATE TABLE IF NOT EXISTS topics (
+  id           BIGSERIAL PRIMARY KEY,
+  name         TEXT    UNIQUE NOT NULL,   -- normalized: lowercase words separated by spaces
+  description  TEXT    NOT NULL DEFAULT ''
+);
+
+-- Age ranges that carry default rules for every kid in them
+CREATE TABLE IF NOT EXISTS age_bands (
+  id       BIGSERIAL PRIMARY KEY,
+  name     TEXT    UNIQUE NOT NULL,
+  min_age  INTEGER NOT NULL,
+  max_age  INTEGER NOT NULL
+);
+
+-- Allow / deny / require-approval rules per topic; kid rules override group rules,
+-- which override age-band defaults
+CREATE TABLE IF NOT EXISTS topic_rules (
+  id         BIGSERIAL PRIMARY KEY,
+  scope      TEXT    NOT NULL,            -- "kid", "group" or "age_band"
+  scope_key  TEXT    NOT NULL,            -- kid username, group id or age band id
+  topic_id   BIGINT  NOT NULL REFERENCES topics(id),
+  action     TEXT    NOT NULL,            -- "allow", "deny" or "require_approval"
+  severity   INTEGER NOT NULL DEFAULT 2,  -- 1 (low) to 3 (high)
+  UNIQUE(scope, scope_key, topic_id)
+);
+
+INSERT INTO topics(name, description) VALUES
+  ('math', 'Numbers, arithmetic and geometry'),
+  ('science', 'Nature, space, chemistry and physics'),
+  ('history', 'Past events and people'),
+  ('animals', 'Pets, wildlife and nature'),
+  ('violence', 'Fighting, injuries and killing'),
+  ('weapons', 'Guns, knives and explosives'),
+  ('drugs', 'Illegal drugs and substance use'),
+  ('alcohol', 'Drinking and alcoholic beverages'),
+  ('gambling', 'Betting and games of chance'),
+  ('dating', 'Romantic relationships'),
+  ('horror', 'Scary stories and monsters'),
+  ('self harm', 'Self-injury and suicide')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO age_bands(name, min_age, max_age) VALUES
+  ('Young children', 0, 7),
+  ('Children', 8, 12),
+  ('Teens', 13, 17)
+ON CONFLICT DO NOTHING;
+
+-- Track each prompt request and whether a parent approved it
+CREATE TABLE IF NOT EXISTS prompt_requests (
+  id            BIGSERIAL PRIMARY KEY,
+  kid_username  TEXT    NOT NULL,
+  prompt        TEXT    NOT NULL,
+  approved      BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at    TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chat sessions (one per child)
+CREATE TABLE IF NOT EXISTS chat_sessions (
+  id            BIGSERIAL PRIMARY KEY,
+  kid_username  TEXT    NOT NULL,
+  created_at    TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chat messages (all user/AI exchanges)
+CREATE TABLE IF NOT EXISTS chat_messages (
+  id            BIGSERIAL PRIMARY KEY,
+  session_id    BIGINT  NOT NULL REFERENCES chat_sessions(id),
+  sender        TEXT    NOT NULL,      -- "kid" or "ai"
+  content       TEXT    NOT NULL,
+  status        TEXT    NOT NULL DEFAULT 'complete', -- "complete", "partial" or "cancelled"
+  timestamp     TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Rolling summary of chat turns that no longer fit the AI context window
+CREATE TABLE IF NOT EXISTS chat_summaries (
+  session_id          BIGINT  PRIMARY KEY REFERENCES chat_sessions(id),
+  summary             TEXT    NOT NULL,
+  through_message_id  BIGINT  NOT NULL,  -- last chat_messages.id folded into the summary
+  updated_at          TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Violation attempts log (when child prompt violates policy)
+CREATE TABLE IF NOT EXISTS violation_attempts (
+  id            BIGSERIAL PRIMARY KEY,
+  kid_username  TEXT    NOT NULL,
+  prompt        TEXT    NOT NULL,
+  violation     TEXT    NOT NULL,      -- e.g. "restricted topic"
+  timestamp     TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- AI answers that output moderation blocked or redacted, kept for parent review
+CREATE TABLE IF NOT EXISTS moderation_incidents (
+  id            BIGSERIAL PRIMARY KEY,
+  kid_username  TEXT    NOT NULL,
+  source        TEXT    NOT NULL,      -- "chat", "request" or "report"
+  session_id    BIGINT,                -- chat_sessions.id when source is "chat"
+  action        TEXT    NOT NULL,      -- "block" or "redact"
+  reason        TEXT    NOT NULL,
+  original      TEXT    NOT NULL,      -- the model's answer; never shown to the kid
+  delivered     TEXT    NOT NULL,      -- what the kid saw instead
+  timestamp     TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+  id         BIGSERIAL PRIMARY KEY,
+  event_type TEXT    NOT NULL,
+  username   TEXT    NOT NULL,
+  timestamp  TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Group membership (many-to-many between groups and users)
+CREATE TABLE IF NOT EXISTS group_members (
+  group_id BIGINT NOT NULL REFERENCES groups(id),
+  username TEXT   NOT NULL,
+  PRIMARY KEY (group_id, username)
+);
+
+-- (Optional) speed up the admin “requests” listing
+CREATE INDEX IF NOT EXISTS idx_prompt_requests_created_at
+  ON prompt_requests(created_at);
+
+-- (Optional) speed up violation lookups
+CREATE INDEX IF NOT EXISTS idx_violation_attempts_timestamp
+  ON violation_attempts(timestamp);
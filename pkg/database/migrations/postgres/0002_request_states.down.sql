@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_prompt_requests_status;
+ALTER TABLE prompt_requests ADD COLUMN approved BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE prompt_requests SET approved = TRUE WHERE status IN ('approved', 'answered', 'failed');
+ALTER TABLE prompt_requests
+  DROP COLUMN expired_at,
+  DROP COLUMN failed_at,
+  DROP COLUMN answered_at,
+  DROP COLUMN denied_at,
+  DROP COLUMN approved_at,
+  DROP COLUMN error,
+  DROP COLUMN answer,
+  DROP COLUMN reason,
+  DROP COLUMN reviewer,
+  DROP COLUMN status;
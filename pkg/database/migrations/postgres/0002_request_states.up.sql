@@ -0,0 +1,18 @@
+-- Replace prompt_requests.approved with an explicit state machine:
+-- pending -> approved | denied | expired, approved -> answered | failed, failed -> approved
+ALTER TABLE prompt_requests
+  ADD COLUMN status      TEXT NOT NULL DEFAULT 'pending',
+  ADD COLUMN reviewer    TEXT,          -- who approved or denied it
+  ADD COLUMN reason      TEXT,          -- reviewer's note, e.g. why it was denied
+  ADD COLUMN answer      TEXT,          -- moderated AI answer shown to the kid
+  ADD COLUMN error       TEXT,          -- why generation failed
+  ADD COLUMN approved_at TIMESTAMPTZ,
+  ADD COLUMN denied_at   TIMESTAMPTZ,
+  ADD COLUMN answered_at TIMESTAMPTZ,
+  ADD COLUMN failed_at   TIMESTAMPTZ,
+  ADD COLUMN expired_at  TIMESTAMPTZ;
+
+UPDATE prompt_requests SET status = 'approved', approved_at = created_at WHERE approved;
+ALTER TABLE prompt_requests DROP COLUMN approved;
+
+CREATE INDEX IF NOT EXISTS idx_prompt_requests_status ON prompt_requests(status);
@@ -0,0 +1,17 @@
+-- Queue of AI generation jobs for approved prompt requests, worked off by pkg/jobs.
+-- queued -> running -> done | failed; a running job whose attempt fails is queued again
+-- with a later run_after until it runs out of attempts.
+CREATE TABLE IF NOT EXISTS ai_jobs (
+  id          BIGSERIAL PRIMARY KEY,
+  request_id  BIGINT NOT NULL REFERENCES prompt_requests(id) ON DELETE CASCADE,
+  status      TEXT NOT NULL DEFAULT 'queued',
+  attempts    INTEGER NOT NULL DEFAULT 0,
+  last_error  TEXT,
+  run_after   TIMESTAMPTZ NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL,
+  started_at  TIMESTAMPTZ,
+  finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_jobs_ready ON ai_jobs(status, run_after);
+CREATE INDEX IF NOT EXISTS idx_ai_jobs_request ON ai_jobs(request_id);
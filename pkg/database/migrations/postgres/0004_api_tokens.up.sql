@@ -0,0 +1,15 @@
+-- Bearer tokens for programmatic API access. Only a SHA-256 hash of each token is
+-- stored; prefix is kept so admins can tell tokens apart.
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id           BIGSERIAL PRIMARY KEY,
+  name         TEXT NOT NULL,
+  username     TEXT NOT NULL,          -- principal the token acts as
+  token_hash   TEXT NOT NULL UNIQUE,
+  prefix       TEXT NOT NULL,
+  scopes       TEXT NOT NULL,          -- space-separated Permit actions
+  created_by   TEXT NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL,
+  expires_at   TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  revoked_at   TIMESTAMPTZ
+);
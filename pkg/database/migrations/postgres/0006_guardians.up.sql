@@ -0,0 +1,11 @@
+-- Which parent accounts look after which kids. A kid may have several guardians
+-- (co-parents); admins see every kid regardless.
+CREATE TABLE IF NOT EXISTS guardians (
+  kid_username TEXT NOT NULL REFERENCES kids(username) ON DELETE CASCADE,
+  guardian     TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+  created_by   TEXT,
+  created_at   TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (kid_username, guardian)
+);
+
+CREATE INDEX IF NOT EXISTS idx_guardians_guardian ON guardians(guardian);
@@ -0,0 +1,29 @@
+-- TOTP second factor of parent and admin accounts. secret is the base32 TOTP key;
+-- enabled_at stays NULL until the user confirms enrollment with a code.
+CREATE TABLE IF NOT EXISTS user_totp (
+  username   TEXT PRIMARY KEY REFERENCES users(username) ON DELETE CASCADE,
+  secret     TEXT NOT NULL,
+  last_step  BIGINT NOT NULL DEFAULT 0, -- last accepted time step, so codes work once
+  created_at TIMESTAMPTZ NOT NULL,
+  enabled_at TIMESTAMPTZ
+);
+
+-- Single-use codes for logging in without the authenticator. Only a SHA-256 hash of
+-- each code is stored.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id         BIGSERIAL PRIMARY KEY,
+  username   TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+  code_hash  TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL,
+  used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_username ON recovery_codes(username);
+
+-- Settings admins change at runtime, e.g. mfa_required.
+CREATE TABLE IF NOT EXISTS settings (
+  key        TEXT PRIMARY KEY,
+  value      TEXT NOT NULL,
+  updated_by TEXT,
+  updated_at TIMESTAMPTZ NOT NULL
+);
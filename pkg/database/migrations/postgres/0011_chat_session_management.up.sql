@@ -0,0 +1,8 @@
+-- Kids name, close and resume their chat sessions. last_message_at orders the
+-- session list by recent activity.
+ALTER TABLE chat_sessions ADD COLUMN title TEXT;
+ALTER TABLE chat_sessions ADD COLUMN closed_at TIMESTAMPTZ;
+ALTER TABLE chat_sessions ADD COLUMN last_message_at TIMESTAMPTZ;
+UPDATE chat_sessions SET last_message_at =
+  (SELECT MAX(m.timestamp) FROM chat_messages m WHERE m.session_id = chat_sessions.id);
+CREATE INDEX IF NOT EXISTS idx_chat_sessions_kid ON chat_sessions(kid_username);
@@ -0,0 +1,10 @@
+-- Rate limiter state shared by all replicas when RATE_LIMIT_STORE is "sql". What
+-- value, prev and stamp hold depends on the algorithm; see pkg/middleware.
+CREATE TABLE IF NOT EXISTS rate_limits (
+  bucket     TEXT PRIMARY KEY,            -- route and caller, e.g. "chat:kid:bob"
+  value      DOUBLE PRECISION NOT NULL,
+  prev       DOUBLE PRECISION NOT NULL,
+  stamp      BIGINT NOT NULL,                 -- unix nanoseconds
+  expires_at TIMESTAMPTZ NOT NULL                 -- the state is back to its initial value by then
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at);
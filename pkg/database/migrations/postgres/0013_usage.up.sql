@@ -0,0 +1,30 @@
+-- One row per AI provider call. kid_username is the kid the call was made for, if
+-- any; username is who made it, e.g. the kid or the parent running a report.
+CREATE TABLE IF NOT EXISTS ai_usage (
+  id                BIGSERIAL PRIMARY KEY,
+  kid_username      TEXT,
+  username          TEXT,
+  source            TEXT NOT NULL,              -- chat, request, report or summary
+  model             TEXT NOT NULL,
+  prompt_tokens     INTEGER NOT NULL,
+  completion_tokens INTEGER NOT NULL,
+  cost              DOUBLE PRECISION NOT NULL,  -- estimated, in AI_PRICE_* currency
+  created_at        TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ai_usage_kid_created ON ai_usage(kid_username, created_at);
+CREATE INDEX IF NOT EXISTS idx_ai_usage_created ON ai_usage(created_at);
+
+-- AI budgets per kid and period. The empty kid_username is the household cap on
+-- everyone's usage together. A NULL maximum is no limit. warned_at is when parents
+-- were last told the budget was nearly used up, so they are told once per period.
+CREATE TABLE IF NOT EXISTS usage_budgets (
+  kid_username TEXT NOT NULL,
+  period       TEXT NOT NULL,                  -- day, week or month
+  max_tokens   BIGINT,
+  max_messages INTEGER,
+  warn_percent INTEGER NOT NULL,
+  warned_at    TIMESTAMPTZ,
+  updated_by   TEXT,
+  updated_at   TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (kid_username, period)
+);
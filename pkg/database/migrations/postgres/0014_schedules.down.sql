@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS screen_time;
+DROP TABLE IF EXISTS schedule_overrides;
+DROP TABLE IF EXISTS kid_schedules;
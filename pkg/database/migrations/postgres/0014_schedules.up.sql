@@ -0,0 +1,34 @@
+-- When kids may use the AI. weekdays and weekends list the allowed windows of the
+-- day, e.g. "07:00-08:00, 15:30-20:00", or "none"; times are in timezone (an IANA
+-- name, empty for the server's). extra_until is extra time a parent granted.
+CREATE TABLE IF NOT EXISTS kid_schedules (
+  kid_username  TEXT PRIMARY KEY REFERENCES kids(username) ON DELETE CASCADE,
+  timezone      TEXT NOT NULL DEFAULT '',
+  weekdays      TEXT NOT NULL,
+  weekends      TEXT NOT NULL,
+  daily_minutes INTEGER,                    -- NULL is no limit
+  extra_until   TIMESTAMPTZ,
+  updated_by    TEXT,
+  updated_at    TIMESTAMPTZ NOT NULL
+);
+
+-- Windows that replace the weekly schedule on one date, e.g. holidays.
+CREATE TABLE IF NOT EXISTS schedule_overrides (
+  kid_username TEXT NOT NULL REFERENCES kids(username) ON DELETE CASCADE,
+  day          TEXT NOT NULL,               -- YYYY-MM-DD in the kid's time zone
+  windows      TEXT NOT NULL,
+  note         TEXT,
+  created_by   TEXT,
+  created_at   TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (kid_username, day)
+);
+
+-- Time kids spent on the child pages per day. Requests less than a few minutes
+-- apart count as continuous use; last_seen is the last one, in unix seconds.
+CREATE TABLE IF NOT EXISTS screen_time (
+  kid_username TEXT NOT NULL REFERENCES kids(username) ON DELETE CASCADE,
+  day          TEXT NOT NULL,               -- YYYY-MM-DD in the kid's time zone
+  seconds      INTEGER NOT NULL,
+  last_seen    BIGINT NOT NULL,
+  PRIMARY KEY (kid_username, day)
+);
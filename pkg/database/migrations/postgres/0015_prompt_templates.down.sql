@@ -0,0 +1,2 @@
+ALTER TABLE kids DROP COLUMN language;
+DROP TABLE IF EXISTS prompt_templates;
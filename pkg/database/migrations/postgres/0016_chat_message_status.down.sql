@@ -0,0 +1,2 @@
+-- chat_messages.status belongs to 0001; nothing to revert.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- Add chat_messages.status to databases whose table predates it; 0001 declares it
+-- for new ones.
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'complete';
@@ -0,0 +1,149 @@
+-- Baseline schema, identical to the last schema.sql. Tables use IF NOT EXISTS so
+-- databases created by earlier releases adopt this migration without changes.
+
+-- RBAC groups for Permit.io “group” sync
+CREATE TABLE IF NOT EXISTS groups (
+  id   INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT    UNIQUE NOT NULL
+);
+
+-- Who the kids are and how old they are
+CREATE TABLE IF NOT EXISTS kids (
+  username   TEXT PRIMARY KEY,
+  age        INTEGER NOT NULL
+);
+
+-- Legacy per-kid policies; rows are imported into topic_rules at startup and removed
+CREATE TABLE IF NOT EXISTS content_policies (
+  kid_username TEXT PRIMARY KEY,
+  allowed      TEXT,  -- comma-separated list of allowed topics
+  restricted   TEXT   -- comma-separated list of disallowed topics
+);
+
+-- Catalog of topics that policy rules can refer to
+CREATE TABLE IF NOT EXISTS topics (
+  id           INTEGER PRIMARY KEY AUTOINCREMENT,
+  name         TEXT    UNIQUE NOT NULL,   -- normalized: lowercase words separated by spaces
+  description  TEXT    NOT NULL DEFAULT ''
+);
+
+-- Age ranges that carry default rules for every kid in them
+CREATE TABLE IF NOT EXISTS age_bands (
+  id       INTEGER PRIMARY KEY AUTOINCREMENT,
+  name     TEXT    UNIQUE NOT NULL,
+  min_age  INTEGER NOT NULL,
+  max_age  INTEGER NOT NULL
+);
+
+-- Allow / deny / require-approval rules per topic; kid rules override group rules,
+-- which override age-band defaults
+CREATE TABLE IF NOT EXISTS topic_rules (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  scope      TEXT    NOT NULL,            -- "kid", "group" or "age_band"
+  scope_key  TEXT    NOT NULL,            -- kid username, group id or age band id
+  topic_id   INTEGER NOT NULL,
+  action     TEXT    NOT NULL,            -- "allow", "deny" or "require_approval"
+  severity   INTEGER NOT NULL DEFAULT 2,  -- 1 (low) to 3 (high)
+  UNIQUE(scope, scope_key, topic_id),
+  FOREIGN KEY(topic_id) REFERENCES topics(id)
+);
+
+INSERT OR IGNORE INTO topics(name, description) VALUES
+  ('math', 'Numbers, arithmetic and geometry'),
+  ('science', 'Nature, space, chemistry and physics'),
+  ('history', 'Past events and people'),
+  ('animals', 'Pets, wildlife and nature'),
+  ('violence', 'Fighting, injuries and killing'),
+  ('weapons', 'Guns, knives and explosives'),
+  ('drugs', 'Illegal drugs and substance use'),
+  ('alcohol', 'Drinking and alcoholic beverages'),
+  ('gambling', 'Betting and games of chance'),
+  ('dating', 'Romantic relationships'),
+  ('horror', 'Scary stories and monsters'),
+  ('self harm', 'Self-injury and suicide');
+
+INSERT OR IGNORE INTO age_bands(name, min_age, max_age) VALUES
+  ('Young children', 0, 7),
+  ('Children', 8, 12),
+  ('Teens', 13, 17);
+
+-- Track each prompt request and whether a parent approved it
+CREATE TABLE IF NOT EXISTS prompt_requests (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  kid_username  TEXT    NOT NULL,
+  prompt        TEXT    NOT NULL,
+  approved      BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chat sessions (one per child)
+CREATE TABLE IF NOT EXISTS chat_sessions (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  kid_username  TEXT    NOT NULL,
+  created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chat messages (all user/AI exchanges)
+CREATE TABLE IF NOT EXISTS chat_messages (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  session_id    INTEGER NOT NULL,
+  sender        TEXT    NOT NULL,      -- "kid" or "ai"
+  content       TEXT    NOT NULL,
+  status        TEXT    NOT NULL DEFAULT 'complete', -- "complete", "partial" or "cancelled"
+  timestamp     DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+);
+
+-- Rolling summary of chat turns that no longer fit the AI context window
+CREATE TABLE IF NOT EXISTS chat_summaries (
+  session_id          INTEGER PRIMARY KEY,
+  summary             TEXT    NOT NULL,
+  through_message_id  INTEGER NOT NULL,  -- last chat_messages.id folded into the summary
+  updated_at          DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+);
+
+-- Violation attempts log (when child prompt violates policy)
+CREATE TABLE IF NOT EXISTS violation_attempts (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  kid_username  TEXT    NOT NULL,
+  prompt        TEXT    NOT NULL,
+  violation     TEXT    NOT NULL,      -- e.g. "restricted topic"
+  timestamp     DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- AI answers that output moderation blocked or redacted, kept for parent review
+CREATE TABLE IF NOT EXISTS moderation_incidents (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  kid_username  TEXT    NOT NULL,
+  source        TEXT    NOT NULL,      -- "chat", "request" or "report"
+  session_id    INTEGER,               -- chat_sessions.id when source is "chat"
+  action        TEXT    NOT NULL,      -- "block" or "redact"
+  reason        TEXT    NOT NULL,
+  original      TEXT    NOT NULL,      -- the model's answer; never shown to the kid
+  delivered     TEXT    NOT NULL,      -- what the kid saw instead
+  timestamp     DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  event_type TEXT    NOT NULL,
+  username   TEXT    NOT NULL,
+  timestamp  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Group membership (many-to-many between groups and users)
+CREATE TABLE IF NOT EXISTS group_members (
+  group_id INTEGER NOT NULL,
+  username TEXT    NOT NULL,
+  PRIMARY KEY (group_id, username),
+  FOREIGN KEY (group_id) REFERENCES groups(id)
+);
+
+-- (Optional) speed up the admin “requests” listing
+CREATE INDEX IF NOT EXISTS idx_prompt_requests_created_at
+  ON prompt_requests(created_at);
+
+-- (Optional) speed up violation lookups
+CREATE INDEX IF NOT EXISTS idx_violation_attempts_timestamp
+  ON violation_attempts(timestamp);
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_prompt_requests_status;
+ALTER TABLE prompt_requests ADD COLUMN approved BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE prompt_requests SET approved = TRUE WHERE status IN ('approved', 'answered', 'failed');
+ALTER TABLE prompt_requests DROP COLUMN expired_at;
+ALTER TABLE prompt_requests DROP COLUMN failed_at;
+ALTER TABLE prompt_requests DROP COLUMN answered_at;
+ALTER TABLE prompt_requests DROP COLUMN denied_at;
+ALTER TABLE prompt_requests DROP COLUMN approved_at;
+ALTER TABLE prompt_requests DROP COLUMN error;
+ALTER TABLE prompt_requests DROP COLUMN answer;
+ALTER TABLE prompt_requests DROP COLUMN reason;
+ALTER TABLE prompt_requests DROP COLUMN reviewer;
+ALTER TABLE prompt_requests DROP COLUMN status;
@@ -0,0 +1,17 @@
+-- Replace prompt_requests.approved with an explicit state machine:
+-- pending -> approved | denied | expired, approved -> answered | failed, failed -> approved
+ALTER TABLE prompt_requests ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE prompt_requests ADD COLUMN reviewer TEXT;          -- who approved or denied it
+ALTER TABLE prompt_requests ADD COLUMN reason TEXT;            -- reviewer's note, e.g. why it was denied
+ALTER TABLE prompt_requests ADD COLUMN answer TEXT;            -- moderated AI answer shown to the kid
+ALTER TABLE prompt_requests ADD COLUMN error TEXT;             -- why generation failed
+ALTER TABLE prompt_requests ADD COLUMN approved_at DATETIME;
+ALTER TABLE prompt_requests ADD COLUMN denied_at DATETIME;
+ALTER TABLE prompt_requests ADD COLUMN answered_at DATETIME;
+ALTER TABLE prompt_requests ADD COLUMN failed_at DATETIME;
+ALTER TABLE prompt_requests ADD COLUMN expired_at DATETIME;
+
+UPDATE prompt_requests SET status = 'approved', approved_at = created_at WHERE approved;
+ALTER TABLE prompt_requests DROP COLUMN approved;
+
+CREATE INDEX IF NOT EXISTS idx_prompt_requests_status ON prompt_requests(status);
@@ -0,0 +1,17 @@
+-- Queue of AI generation jobs for approved prompt requests, worked off by pkg/jobs.
+-- queued -> running -> done | failed; a running job whose attempt fails is queued again
+-- with a later run_after until it runs out of attempts.
+CREATE TABLE IF NOT EXISTS ai_jobs (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  request_id  INTEGER NOT NULL REFERENCES prompt_requests(id) ON DELETE CASCADE,
+  status      TEXT NOT NULL DEFAULT 'queued',
+  attempts    INTEGER NOT NULL DEFAULT 0,
+  last_error  TEXT,
+  run_after   DATETIME NOT NULL,
+  created_at  DATETIME NOT NULL,
+  started_at  DATETIME,
+  finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_jobs_ready ON ai_jobs(status, run_after);
+CREATE INDEX IF NOT EXISTS idx_ai_jobs_request ON ai_jobs(request_id);
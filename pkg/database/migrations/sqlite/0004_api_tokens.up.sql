@@ -0,0 +1,15 @@
+-- Bearer tokens for programmatic API access. Only a SHA-256 hash of each token is
+-- stored; prefix is kept so admins can tell tokens apart.
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id           INTEGER PRIMARY KEY AUTOINCREMENT,
+  name         TEXT NOT NULL,
+  username     TEXT NOT NULL,          -- principal the token acts as
+  token_hash   TEXT NOT NULL UNIQUE,
+  prefix       TEXT NOT NULL,
+  scopes       TEXT NOT NULL,          -- space-separated Permit actions
+  created_by   TEXT NOT NULL,
+  created_at   DATETIME NOT NULL,
+  expires_at   DATETIME NOT NULL,
+  last_used_at DATETIME,
+  revoked_at   DATETIME
+);
@@ -0,0 +1,14 @@
+-- Parent, admin and ai-agent accounts. password_hash is a bcrypt hash; ai-agent
+-- accounts have none and authenticate with API tokens only.
+CREATE TABLE IF NOT EXISTS users (
+  id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+  username            TEXT NOT NULL UNIQUE,
+  password_hash       TEXT,
+  role                TEXT NOT NULL,          -- parent, admin or ai-agent
+  failed_logins       INTEGER NOT NULL DEFAULT 0,
+  locked_until        DATETIME,               -- throttled or locked out until then
+  last_login_at       DATETIME,
+  password_changed_at DATETIME,
+  created_by          TEXT,
+  created_at          DATETIME NOT NULL
+);
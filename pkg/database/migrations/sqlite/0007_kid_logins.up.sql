@@ -0,0 +1,35 @@
+-- Kid login secrets set by a parent: a numeric PIN or a sequence of pictures, stored
+-- as a bcrypt hash. Kids without one can only sign in with a one-time code.
+CREATE TABLE IF NOT EXISTS kid_credentials (
+  kid_username  TEXT PRIMARY KEY REFERENCES kids(username) ON DELETE CASCADE,
+  kind          TEXT NOT NULL,          -- pin or picture
+  secret_hash   TEXT NOT NULL,
+  failed_logins INTEGER NOT NULL DEFAULT 0,
+  locked_until  DATETIME,               -- throttled or locked out until then
+  updated_by    TEXT,
+  updated_at    DATETIME NOT NULL
+);
+
+-- One-time login codes a parent hands to a kid, typed in or scanned as a QR code.
+-- Only a SHA-256 hash of each code is stored.
+CREATE TABLE IF NOT EXISTS kid_login_codes (
+  id           INTEGER PRIMARY KEY AUTOINCREMENT,
+  kid_username TEXT NOT NULL REFERENCES kids(username) ON DELETE CASCADE,
+  code_hash    TEXT NOT NULL UNIQUE,
+  created_by   TEXT NOT NULL,
+  created_at   DATETIME NOT NULL,
+  expires_at   DATETIME NOT NULL,
+  used_at      DATETIME
+);
+
+-- Messages for parents and admins, e.g. that a kid was locked out.
+CREATE TABLE IF NOT EXISTS notifications (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  recipient  TEXT NOT NULL,             -- account username
+  kind       TEXT NOT NULL,
+  message    TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  read_at    DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_recipient ON notifications(recipient, read_at);
@@ -0,0 +1,19 @@
+-- Browser sessions. The cookie holds a random token and only its SHA-256 hash is
+-- stored, so sessions can be listed and revoked. Anonymous sessions (e.g. the CSRF
+-- salt before login) have neither username nor kid_username.
+CREATE TABLE IF NOT EXISTS login_sessions (
+  id           INTEGER PRIMARY KEY AUTOINCREMENT,
+  token_hash   TEXT NOT NULL UNIQUE,
+  username     TEXT REFERENCES users(username) ON DELETE CASCADE, -- logged-in account
+  kid_username TEXT REFERENCES kids(username) ON DELETE CASCADE,  -- logged-in kid
+  data         TEXT NOT NULL,          -- encoded session values
+  ip           TEXT,
+  user_agent   TEXT,
+  created_at   DATETIME NOT NULL,
+  last_seen_at DATETIME NOT NULL,
+  expires_at   DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_sessions_username ON login_sessions(username);
+CREATE INDEX IF NOT EXISTS idx_login_sessions_kid ON login_sessions(kid_username);
+CREATE INDEX IF NOT EXISTS idx_login_sessions_expires ON login_sessions(expires_at);
@@ -0,0 +1,18 @@
+-- Profile of an account, filled in from the identity provider at each OpenID Connect
+-- login and synced to Permit.
+ALTER TABLE users ADD COLUMN email TEXT;
+ALTER TABLE users ADD COLUMN display_name TEXT;
+
+-- OpenID Connect identities linked to accounts: the (issuer, subject) pair from the
+-- ID token identifies the person at the identity provider.
+CREATE TABLE IF NOT EXISTS user_identities (
+  id            INTEGER PRIMARY KEY AUTOINCREMENT,
+  username      TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+  issuer        TEXT NOT NULL,
+  subject       TEXT NOT NULL,
+  email         TEXT,
+  created_at    DATETIME NOT NULL,
+  last_login_at DATETIME,
+  UNIQUE (issuer, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_username ON user_identities(username);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_chat_sessions_kid;
+ALTER TABLE chat_sessions DROP COLUMN last_message_at;
+ALTER TABLE chat_sessions DROP COLUMN closed_at;
+ALTER TABLE chat_sessions DROP COLUMN title;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS usage_budgets;
+DROP TABLE IF EXISTS ai_usage;
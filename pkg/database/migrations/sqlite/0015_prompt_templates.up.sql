@@ -0,0 +1,15 @@
+-- System prompt templates, selected by the kid's age band; age_band_id 0 is the
+-- default for kids in no band with a template. Saving a template adds a version;
+-- the highest version of a band is in use.
+CREATE TABLE IF NOT EXISTS prompt_templates (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  age_band_id INTEGER NOT NULL,
+  version     INTEGER NOT NULL,
+  body        TEXT    NOT NULL,
+  created_by  TEXT,
+  created_at  DATETIME NOT NULL,
+  UNIQUE(age_band_id, version)
+);
+
+-- The language the AI answers a kid in; NULL is English.
+ALTER TABLE kids ADD COLUMN language TEXT;
@@ -0,0 +1,4 @@
+-- Databases created from schema.sql before migrations have chat_messages without
+-- the status column that 0001 declares. SQLite cannot add a column only if it is
+-- missing, so MigrateUp adds it before this migration runs (see sqliteColumns).
+SELECT 1;
@@ -0,0 +1,4 @@
+-- A running job belongs to the worker that claimed it until locked_until, which the
+-- worker keeps pushing forward while it runs. Jobs whose lease ran out, e.g. because
+-- their replica died, are claimed again; NULL counts as expired.
+ALTER TABLE ai_jobs ADD COLUMN locked_until DATETIME;
@@ -0,0 +1,108 @@
+package database_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+)
+
+func TestPostgresMigrateDownAndUp(t *testing.T) {
+	db := dbtest.Postgres(t)
+	all, err := database.Migrations(database.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	checksums := map[int]string{}
+	rows, err := db.Query("SELECT version, checksum FROM schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			t.Fatal(err)
+		}
+		checksums[v] = sum
+	}
+	rows.Close()
+	for _, m := range all {
+		if checksums[m.Version] != m.Checksum {
+			t.Errorf("checksum of %04d_%s = %q, want %q", m.Version, m.Name, checksums[m.Version], m.Checksum)
+		}
+	}
+
+	if reverted, err := database.MigrateDown(db, len(all)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	} else if len(reverted) != len(all) {
+		t.Errorf("reverted %d migrations, want %d", len(reverted), len(all))
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp after reverting: %v", err)
+	}
+	if err := database.CheckSchema(db); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+}
+
+func TestPostgresChecksumMismatch(t *testing.T) {
+	db := dbtest.Postgres(t)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if _, err := db.Exec("UPDATE schema_migrations SET checksum = ? WHERE version = ?", "edited", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CheckSchema(db); !errors.Is(err, database.ErrChecksumMismatch) {
+		t.Errorf("CheckSchema error = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := database.MigrateUp(db); !errors.Is(err, database.ErrChecksumMismatch) {
+		t.Errorf("MigrateUp error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestPostgresConcurrentMigrateUp(t *testing.T) {
+	db := dbtest.Postgres(t)
+	all, err := database.Migrations(database.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like instances starting together with DB_AUTO_MIGRATE.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	applied := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := database.MigrateUp(db)
+			if err != nil {
+				t.Errorf("MigrateUp: %v", err)
+			}
+			mu.Lock()
+			applied += len(done)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if applied != len(all) {
+		t.Errorf("applied %d migrations, want %d", applied, len(all))
+	}
+}
+
+func TestRebindAgainstPostgres(t *testing.T) {
+	db := dbtest.Postgres(t)
+	var a, b string
+	if err := db.QueryRow("SELECT ?::text || '?', 'it''s ' || ?::text", "what", "fine").Scan(&a, &b); err != nil {
+		t.Fatal(err)
+	}
+	if a != "what?" || b != "it's fine" {
+		t.Errorf("got %q, %q, want %q, %q", a, b, "what?", "it's fine")
+	}
+}
@@ -1,57 +1,94 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/permitio/permit-golang/pkg/models"
 	csrf "github.com/utrack/gin-csrf"
 
 	"github.com/schoolboylurk/data-sentinel/pkg/auth"
-	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/policy"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
 )
 
 // ShowLogin renders the admin login page.
 func ShowLogin(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"csrfToken": csrf.GetToken(c),
-	})
+	renderLogin(c, http.StatusOK, "")
 }
 
-// PerformLogin authenticates the admin user and starts a session.
+// renderLogin renders login.html with an optional error and, when configured, the
+// OpenID Connect login button.
+func renderLogin(c *gin.Context, status int, errMsg string) {
+	data := gin.H{"error": errMsg, "csrfToken": csrf.GetToken(c)}
+	if auth.OIDC != nil {
+		data["OIDC"] = auth.OIDC.Name
+	}
+	c.HTML(status, "login.html", data)
+}
+
+// PerformLogin authenticates a parent or admin account and starts a session.
 func PerformLogin(c *gin.Context) {
+	ctx := c.Request.Context()
 	username := c.PostForm("username")
-	password := c.PostForm("password")
-	// For demo purposes only: hardcoded credentials.
-	if username == "admin" && password == "2025DEVChallenge" {
-		sess := sessions.Default(c)
-		sess.Set("user", username)
-		sess.Save()
-
-		ctx := context.Background()
 
-		userCreate := *models.NewUserCreate(username)
-		//add more metadata here eventually like setting email and username
-
-		if _, err := auth.PermitClient.Api.Users.SyncUser(ctx, userCreate); err != nil {
-			log.Printf("Permit SyncUser failed for %s: %v", username, err)
+	u, err := auth.Authenticate(ctx, username, c.PostForm("password"))
+	if err == nil && u.Role == auth.RoleAgent {
+		err = auth.ErrInvalidCredentials // automation accounts use API tokens
+	}
+	if errors.Is(err, auth.ErrSecondFactorRequired) {
+		startPendingMFA(c, u.Username) // the password was right
+		return
+	}
+	if err != nil {
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, auth.ErrLocked):
+			status = http.StatusTooManyRequests
+		case !errors.Is(err, auth.ErrInvalidCredentials):
+			log.Printf("⚠️ PerformLogin: %s: %v", username, err)
+			status, err = http.StatusInternalServerError, errors.New("login failed, try again")
 		}
-		c.Redirect(http.StatusSeeOther, "/admin/kids")
+		if status != http.StatusInternalServerError && u.ID != 0 {
+			if err := store.Default.Audit.LogEvent(ctx, "login_failed", u.Username); err != nil {
+				log.Printf("⚠️ PerformLogin: failed to log event for %s: %v", u.Username, err)
+			}
+		}
+		renderLogin(c, status, err.Error())
 		return
 	}
-	// Authentication failed
-	c.HTML(http.StatusUnauthorized, "login.html", gin.H{"error": "invalid credentials", "csrfToken": csrf.GetToken(c)})
+	startUserSession(c, u)
 }
 
-// AdminRequired is a middleware that ensures the user is logged in as admin.
-func AdminRequired(c *gin.Context) {
+// startUserSession logs u in on this session and sends them to the admin pages.
+func startUserSession(c *gin.Context, u store.User) {
+	ctx := c.Request.Context()
 	sess := sessions.Default(c)
-	if sess.Get("user") == nil {
+	sess.Delete("kid") // one identity per session
+	sess.Delete(mfaUserKey)
+	sess.Delete(mfaSinceKey)
+	sess.Set("user", u.Username)
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ startUserSession: failed to save session for %s: %v", u.Username, err)
+		renderLogin(c, http.StatusInternalServerError, "login failed, try again")
+		return
+	}
+
+	auth.SyncUser(ctx, u)
+	if err := store.Default.Audit.LogEvent(ctx, "login_succeeded", u.Username); err != nil {
+		log.Printf("⚠️ startUserSession: failed to log event for %s: %v", u.Username, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
+
+// AdminRequired is a middleware that ensures a parent or admin is logged in.
+func AdminRequired(c *gin.Context) {
+	if p, ok := auth.CurrentPrincipal(c); !ok || p.Kind != auth.KindUser || !p.IsSession() {
 		c.Redirect(http.StatusSeeOther, "/login")
 		c.Abort()
 		return
@@ -59,153 +96,369 @@ func AdminRequired(c *gin.Context) {
 	c.Next()
 }
 
+// RoleRequired restricts a route to session users with one of the given roles. Use
+// it after AdminRequired.
+func RoleRequired(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, auth.MustPrincipal(c).Role) {
+			c.String(http.StatusForbidden, "permission denied")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // ViolationMetrics returns the count of policy violation attempts per kid in the last 24 hours.
 func ViolationMetrics(c *gin.Context) {
-	rows, err := database.DB.Query(`
-		SELECT kid_username, COUNT(*) AS attempts
-		FROM violation_attempts
-		WHERE timestamp > datetime('now','-24 hours')
-		GROUP BY kid_username
-	`)
+	counts, err := store.Default.Audit.ViolationCounts(c.Request.Context(), 24, kidScope(auth.MustPrincipal(c)))
 	if err != nil {
+		log.Printf("⚠️ ViolationMetrics: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query violations"})
 		return
 	}
-	defer rows.Close()
 
 	var metrics []gin.H
-	for rows.Next() {
-		var kid string
-		var count int
-		err := rows.Scan(&kid, &count)
-		if err != nil {
-			continue
-		}
-		metrics = append(metrics, gin.H{"kid": kid, "attempts": count})
+	for _, n := range counts {
+		metrics = append(metrics, gin.H{"kid": n.Label, "attempts": n.Count})
 	}
 
 	c.JSON(http.StatusOK, metrics)
 }
 
-// ListKidsPage shows the list of kids.
+// ListKidsPage shows the kids the logged-in parent looks after, or every kid to admins.
 func ListKidsPage(c *gin.Context) {
-	rows, err := database.DB.Query("SELECT username, age FROM kids")
-	if err != nil {
-		c.HTML(http.StatusInternalServerError, "kids.html", gin.H{"error": "failed to load kids", "csrfToken": csrf.GetToken(c)})
-		return
-	}
-	defer rows.Close()
-
-	type Kid struct {
-		Username string
-		Age      int
-	}
-	var kids []Kid
-	for rows.Next() {
-		var k Kid
-		if err := rows.Scan(&k.Username, &k.Age); err != nil {
-			continue
-		}
-		kids = append(kids, k)
-	}
-	c.HTML(http.StatusOK, "kids.html", gin.H{"Kids": kids, "csrfToken": csrf.GetToken(c)})
+	renderKids(c, http.StatusOK, "")
 }
 
-// AddKid handles adding or updating a kid.
+// errNotGuardian is returned when a parent edits a kid they do not look after.
+var errNotGuardian = errors.New("not a guardian")
+
+// AddKid handles adding or updating a kid. An empty language keeps the kid's
+// current one, English for new kids. A parent who adds a kid becomes its
+// guardian, and may only update kids they already look after.
 func AddKid(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
 	username := c.PostForm("username")
 	ageStr := c.PostForm("age")
+	language := strings.TrimSpace(c.PostForm("language"))
 
 	// Parse age
 	ageInt, err := strconv.Atoi(ageStr)
 	if err != nil || ageInt < 0 {
-		c.HTML(http.StatusBadRequest, "kids.html", gin.H{
-			"error": "Age must be a non-negative integer", "csrfToken": csrf.GetToken(c),
-		})
+		renderKids(c, http.StatusBadRequest, "Age must be a non-negative integer")
+		return
+	}
+
+	if len(language) > 40 {
+		renderKids(c, http.StatusBadRequest, "Language is too long")
 		return
 	}
 
-	if _, err := database.DB.Exec(
-		"INSERT OR REPLACE INTO kids(username, age) VALUES(?,?)",
-		username, ageInt,
-	); err != nil {
+	if _, err := store.Default.Users.Get(ctx, username); err == nil {
+		renderKids(c, http.StatusBadRequest, "That username belongs to a parent or admin account")
+		return
+	}
+
+	scope := kidScope(p)
+	created := false
+	err = store.Default.InTx(ctx, func(tx store.Repos) error {
+		_, err := tx.Kids.Get(ctx, username)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			created = true
+		case err != nil:
+			return err
+		case !scope.All():
+			ok, err := tx.Guardians.IsGuardian(ctx, username, scope.Guardian)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errNotGuardian
+			}
+		}
+		if err := tx.Kids.Save(ctx, store.Kid{Username: username, Age: ageInt, Language: language}); err != nil {
+			return err
+		}
+		if created && !scope.All() {
+			return tx.Guardians.Add(ctx, username, scope.Guardian, p.ID)
+		}
+		return nil
+	})
+	if errors.Is(err, errNotGuardian) {
+		renderKids(c, http.StatusForbidden, "That username belongs to a kid you do not look after")
+		return
+	}
+	if err != nil {
 		log.Printf("AddKid: failed to save %s age %d: %v", username, ageInt, err)
-		c.HTML(http.StatusInternalServerError, "kids.html", gin.H{
-			"error": "Failed to save kid", "csrfToken": csrf.GetToken(c),
-		})
+		renderKids(c, http.StatusInternalServerError, "Failed to save kid")
 		return
 	}
 
+	if created {
+		auth.SyncKid(ctx, username)
+		if !scope.All() {
+			auth.SyncGuardian(ctx, username, scope.Guardian)
+		}
+	}
 	c.Redirect(http.StatusSeeOther, "/admin/kids")
 }
 
-// ListPoliciesPage shows content policies.
+// ListPoliciesPage shows the topic catalog, age bands, policy rules and each kid's effective policy.
 func ListPoliciesPage(c *gin.Context) {
-	rows, err := database.DB.Query("SELECT kid_username, allowed, restricted FROM content_policies")
-	if err != nil {
+	renderPolicies(c, http.StatusOK, "")
+}
+
+// renderPolicies renders policies.html with everything the page needs, plus an optional error.
+func renderPolicies(c *gin.Context, status int, errMsg string) {
+	ctx := c.Request.Context()
+	data := gin.H{"csrfToken": csrf.GetToken(c)}
+	fail := func(err error) {
+		log.Printf("⚠️ renderPolicies: %v", err)
 		c.HTML(http.StatusInternalServerError, "policies.html", gin.H{"error": "failed to load policies", "csrfToken": csrf.GetToken(c)})
+	}
+
+	topics, err := policy.ListTopics(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	bands, err := policy.ListAgeBands(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	rules, err := policy.ListRules(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	groups, err := store.Default.Groups.List(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+	groupNames := map[string]string{}
+	for _, g := range groups {
+		groupNames[strconv.FormatInt(g.ID, 10)] = g.Name
+	}
+
+	p := auth.MustPrincipal(c)
+	kidRows, err := store.Default.Kids.List(ctx, kidScope(p))
+	if err != nil {
+		fail(err)
 		return
 	}
-	defer rows.Close()
+	var kids []string
+	for _, k := range kidRows {
+		kids = append(kids, k.Username)
+	}
+	isAdmin := p.Role == auth.RoleAdmin
+
+	bandNames := map[string]string{}
+	for _, b := range bands {
+		bandNames[strconv.FormatInt(b.ID, 10)] = b.Name
+	}
+
+	// Rules are shown with a readable scope name instead of an ID.
+	type RuleRow struct {
+		policy.Rule
+		ScopeName string
+	}
+	var ruleRows []RuleRow
+	for _, r := range rules {
+		// parents see their own kids' rules and the shared group and age-band rules
+		if r.Scope == policy.ScopeKid && !isAdmin && !slices.Contains(kids, r.ScopeKey) {
+			continue
+		}
+		name := r.ScopeKey
+		switch r.Scope {
+		case policy.ScopeGroup:
+			name = groupNames[r.ScopeKey]
+		case policy.ScopeAgeBand:
+			name = bandNames[r.ScopeKey]
+		}
+		ruleRows = append(ruleRows, RuleRow{Rule: r, ScopeName: name})
+	}
 
-	type Policy struct {
+	type EffectiveRow struct {
 		Username   string
+		Age        int
 		Allowed    string
+		Approval   string
 		Restricted string
 	}
-	var policies []Policy
-	for rows.Next() {
-		var p Policy
-		if err := rows.Scan(&p.Username, &p.Allowed, &p.Restricted); err != nil {
-			continue
+	var effective []EffectiveRow
+	for _, k := range kids {
+		eff, err := policy.Resolve(ctx, k)
+		if err != nil {
+			fail(err)
+			return
 		}
-		policies = append(policies, p)
+		effective = append(effective, EffectiveRow{
+			Username:   k,
+			Age:        eff.Age,
+			Allowed:    strings.Join(eff.Topics(policy.ActionAllow), ", "),
+			Approval:   strings.Join(eff.Topics(policy.ActionRequireApproval), ", "),
+			Restricted: strings.Join(eff.Topics(policy.ActionDeny), ", "),
+		})
+	}
+
+	data["Topics"] = topics
+	data["AgeBands"] = bands
+	data["Rules"] = ruleRows
+	data["Groups"] = groups
+	data["Kids"] = kids
+	data["Effective"] = effective
+	data["IsAdmin"] = isAdmin
+	if errMsg != "" {
+		data["error"] = errMsg
 	}
-	c.HTML(http.StatusOK, "policies.html", gin.H{"Policies": policies, "csrfToken": csrf.GetToken(c)})
+	c.HTML(status, "policies.html", data)
 }
 
+// UpdatePolicy creates or replaces a topic rule at kid, group or age-band scope.
 func UpdatePolicy(c *gin.Context) {
-	username := c.PostForm("username")
-	allowed := c.PostForm("allowed")
-	restricted := c.PostForm("restricted")
+	topicID, err := strconv.ParseInt(c.PostForm("topic_id"), 10, 64)
+	if err != nil {
+		renderPolicies(c, http.StatusBadRequest, "Choose a topic from the catalog")
+		return
+	}
+	severity, err := strconv.Atoi(c.PostForm("severity"))
+	if err != nil {
+		renderPolicies(c, http.StatusBadRequest, "Severity must be a number")
+		return
+	}
 
-	if _, err := database.DB.Exec(
-		"INSERT OR REPLACE INTO content_policies(kid_username, allowed, restricted) VALUES(?,?,?)",
-		username, allowed, restricted,
-	); err != nil {
-		log.Printf("UpdatePolicy: failed to save policy for %s: %v", username, err)
-		c.HTML(http.StatusInternalServerError, "policies.html", gin.H{"error": "failed to save policy"})
+	// target is "<scope>:<key>", e.g. "kid:bob" or "group:3", from the form's grouped select.
+	scope, key, _ := strings.Cut(c.PostForm("target"), ":")
+	rule := policy.Rule{
+		Scope:    scope,
+		ScopeKey: key,
+		TopicID:  topicID,
+		Action:   policy.Action(c.PostForm("action")),
+		Severity: severity,
+	}
+	if !mayEditRule(c, rule) {
+		return
+	}
+	if err := policy.SaveRule(c.Request.Context(), rule); err != nil {
+		if errors.Is(err, policy.ErrInvalidRule) {
+			renderPolicies(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("UpdatePolicy: failed to save rule %+v: %v", rule, err)
+		renderPolicies(c, http.StatusInternalServerError, "failed to save policy")
 		return
 	}
 
 	c.Redirect(http.StatusSeeOther, "/admin/policies")
 }
 
-// ListRequestsPage shows all pending prompt requests.
-func ListRequestsPage(c *gin.Context) {
-	rows, err := database.DB.Query("SELECT id, kid_username, prompt, approved, created_at FROM prompt_requests ORDER BY created_at DESC")
+// DeletePolicyRule removes a single topic rule.
+func DeletePolicyRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "requests.html", gin.H{"error": "failed to load requests", "csrfToken": csrf.GetToken(c)})
+		renderPolicies(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+	rule, err := policy.GetRule(c.Request.Context(), id)
+	if err == nil {
+		if !mayEditRule(c, rule) {
+			return
+		}
+		err = policy.DeleteRule(c.Request.Context(), id)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			renderPolicies(c, http.StatusNotFound, "Rule not found")
+			return
+		}
+		log.Printf("DeletePolicyRule: failed to delete rule %d: %v", id, err)
+		renderPolicies(c, http.StatusInternalServerError, "failed to delete rule")
 		return
 	}
-	defer rows.Close()
+	c.Redirect(http.StatusSeeOther, "/admin/policies")
+}
 
-	type Req struct {
-		ID        int
-		Username  string
-		Prompt    string
-		Approved  bool
-		CreatedAt string
+// mayEditRule reports whether the logged-in user may change rule: admins any rule,
+// parents only kid rules for their own kids. Otherwise it renders the error.
+func mayEditRule(c *gin.Context, rule policy.Rule) bool {
+	p := auth.MustPrincipal(c)
+	if p.Role == auth.RoleAdmin {
+		return true
 	}
-	var reqs []Req
-	for rows.Next() {
-		var r Req
-		if err := rows.Scan(&r.ID, &r.Username, &r.Prompt, &r.Approved, &r.CreatedAt); err != nil {
-			continue
+	if rule.Scope != policy.ScopeKid {
+		renderPolicies(c, http.StatusForbidden, "Only admins can change group and age band rules")
+		return false
+	}
+	ok, err := looksAfter(c.Request.Context(), p, rule.ScopeKey)
+	if err != nil {
+		log.Printf("⚠️ mayEditRule: %v", err)
+		renderPolicies(c, http.StatusInternalServerError, "failed to check guardianship")
+		return false
+	}
+	if !ok {
+		renderPolicies(c, http.StatusForbidden, "You can only change rules for your own kids")
+	}
+	return ok
+}
+
+// AddTopic adds a topic to the policy catalog.
+func AddTopic(c *gin.Context) {
+	if _, err := policy.AddTopic(c.Request.Context(), c.PostForm("name"), strings.TrimSpace(c.PostForm("description"))); err != nil {
+		if errors.Is(err, policy.ErrInvalidRule) {
+			renderPolicies(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("AddTopic: failed to add topic: %v", err)
+		renderPolicies(c, http.StatusInternalServerError, "failed to add topic")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/policies")
+}
+
+// AddAgeBand creates an age band whose rules apply to every kid in that age range.
+func AddAgeBand(c *gin.Context) {
+	minAge, err1 := strconv.Atoi(c.PostForm("min_age"))
+	maxAge, err2 := strconv.Atoi(c.PostForm("max_age"))
+	if err1 != nil || err2 != nil {
+		renderPolicies(c, http.StatusBadRequest, "Ages must be whole numbers")
+		return
+	}
+	if err := policy.AddAgeBand(c.Request.Context(), c.PostForm("name"), minAge, maxAge); err != nil {
+		if errors.Is(err, policy.ErrInvalidRule) {
+			renderPolicies(c, http.StatusBadRequest, err.Error())
+			return
 		}
-		reqs = append(reqs, r)
+		log.Printf("AddAgeBand: failed to add age band: %v", err)
+		renderPolicies(c, http.StatusInternalServerError, "failed to add age band")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/policies")
+}
+
+// ListRequestsPage shows the prompt requests of the kids in scope with their state and answer.
+func ListRequestsPage(c *gin.Context) {
+	renderRequests(c, http.StatusOK, "")
+}
+
+// renderRequests renders requests.html with an optional error.
+func renderRequests(c *gin.Context, status int, errMsg string) {
+	reqs, err := store.Default.PromptRequests.List(c.Request.Context(), kidScope(auth.MustPrincipal(c)))
+	if err != nil {
+		log.Printf("⚠️ ListRequestsPage: %v", err)
+		c.HTML(http.StatusInternalServerError, "requests.html", gin.H{"error": "failed to load requests", "csrfToken": csrf.GetToken(c)})
+		return
 	}
-	c.HTML(http.StatusOK, "requests.html", gin.H{"Requests": reqs, "csrfToken": csrf.GetToken(c)})
+	data := gin.H{"Requests": reqs, "csrfToken": csrf.GetToken(c)}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	c.HTML(status, "requests.html", data)
 }
 
 // ShowAdminDashboard renders the main admin dashboard UI.
@@ -213,53 +466,30 @@ func ShowAdminDashboard(c *gin.Context) {
 	c.HTML(http.StatusOK, "admin_dashboard.html", gin.H{"csrfToken": csrf.GetToken(c)})
 }
 
-// MetricsHandler returns a breakdown of all audit_events in the last 24h.
+// MetricsHandler returns a breakdown of the audit_events in scope in the last 24h.
 func MetricsHandler(c *gin.Context) {
-	rows, err := database.DB.Query(`
-      SELECT event_type, COUNT(*) AS count
-      FROM audit_events
-      WHERE timestamp > datetime('now','-24 hours')
-      GROUP BY event_type
-    `)
+	counts, err := store.Default.Audit.EventCounts(c.Request.Context(), 24, kidScope(auth.MustPrincipal(c)))
 	if err != nil {
+		log.Printf("⚠️ MetricsHandler: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query metrics"})
 		return
 	}
-	defer rows.Close()
 
 	var stats []gin.H
-	for rows.Next() {
-		var event string
-		var cnt int
-		if err := rows.Scan(&event, &cnt); err != nil {
-			continue
-		}
-		stats = append(stats, gin.H{"event": event, "count": cnt})
+	for _, n := range counts {
+		stats = append(stats, gin.H{"event": n.Label, "count": n.Count})
 	}
 	c.JSON(http.StatusOK, stats)
 }
 
 // ListGroupsPage shows all RBAC groups.
 func ListGroupsPage(c *gin.Context) {
-	rows, err := database.DB.Query("SELECT id, name FROM groups")
+	gs, err := store.Default.Groups.List(c.Request.Context())
 	if err != nil {
+		log.Printf("⚠️ ListGroupsPage: %v", err)
 		c.HTML(http.StatusInternalServerError, "groups.html", gin.H{"error": "failed to load groups", "csrfToken": csrf.GetToken(c)})
 		return
 	}
-	defer rows.Close()
-
-	type Group struct {
-		ID   int
-		Name string
-	}
-	var gs []Group
-	for rows.Next() {
-		var g Group
-		if err := rows.Scan(&g.ID, &g.Name); err != nil {
-			continue
-		}
-		gs = append(gs, g)
-	}
 	c.HTML(http.StatusOK, "groups.html", gin.H{"Groups": gs, "csrfToken": csrf.GetToken(c)})
 }
 
@@ -271,21 +501,11 @@ func AddGroup(c *gin.Context) {
 		return
 	}
 
-	// prevent duplicates
-	var exists bool
-	if err := database.DB.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM groups WHERE name=?)", name,
-	).Scan(&exists); err != nil {
-		log.Printf("AddGroup: lookup failed for %s: %v", name, err)
-		c.HTML(http.StatusInternalServerError, "groups.html", gin.H{"error": "DB error", "csrfToken": csrf.GetToken(c)})
-		return
-	}
-	if exists {
-		c.HTML(http.StatusConflict, "groups.html", gin.H{"error": "Group already exists", "csrfToken": csrf.GetToken(c)})
-		return
-	}
-
-	if _, err := database.DB.Exec("INSERT INTO groups(name) VALUES(?)", name); err != nil {
+	if _, err := store.Default.Groups.Create(c.Request.Context(), name); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			c.HTML(http.StatusConflict, "groups.html", gin.H{"error": "Group already exists", "csrfToken": csrf.GetToken(c)})
+			return
+		}
 		log.Printf("AddGroup: failed to create group %s: %v", name, err)
 		c.HTML(http.StatusInternalServerError, "groups.html", gin.H{"error": "Could not create group", "csrfToken": csrf.GetToken(c)})
 		return
@@ -296,7 +516,7 @@ func AddGroup(c *gin.Context) {
 
 // AddMember adds a user to an existing group.
 func AddMember(c *gin.Context) {
-	gid, err := strconv.Atoi(c.Param("id"))
+	gid, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.HTML(http.StatusBadRequest, "groups.html", gin.H{"error": "Invalid group ID", "csrfToken": csrf.GetToken(c)})
 		return
@@ -307,25 +527,15 @@ func AddMember(c *gin.Context) {
 		return
 	}
 
-	// prevent duplicate membership
-	var exists bool
-	if err := database.DB.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id=? AND username=?)",
-		gid, user,
-	).Scan(&exists); err != nil {
-		log.Printf("AddMember: lookup failed for group %d, user %s: %v", gid, user, err)
-		c.HTML(http.StatusInternalServerError, "groups.html", gin.H{"error": "DB error", "csrfToken": csrf.GetToken(c)})
-		return
-	}
-	if exists {
-		c.HTML(http.StatusConflict, "groups.html", gin.H{"error": "User already in group", "csrfToken": csrf.GetToken(c)})
-		return
-	}
-
-	if _, err := database.DB.Exec(
-		"INSERT INTO group_members(group_id, username) VALUES(?,?)",
-		gid, user,
-	); err != nil {
+	if err := store.Default.Groups.AddMember(c.Request.Context(), gid, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			c.HTML(http.StatusNotFound, "groups.html", gin.H{"error": "Group not found", "csrfToken": csrf.GetToken(c)})
+			return
+		case errors.Is(err, store.ErrDuplicate):
+			c.HTML(http.StatusConflict, "groups.html", gin.H{"error": "User already in group", "csrfToken": csrf.GetToken(c)})
+			return
+		}
 		log.Printf("AddMember: failed to add %s to group %d: %v", user, gid, err)
 		c.HTML(http.StatusInternalServerError, "groups.html", gin.H{"error": "Could not add member", "csrfToken": csrf.GetToken(c)})
 		return
@@ -333,3 +543,14 @@ func AddMember(c *gin.Context) {
 
 	c.Redirect(http.StatusSeeOther, "/admin/groups")
 }
+
+// ListModerationPage shows AI answers that output moderation blocked or redacted.
+func ListModerationPage(c *gin.Context) {
+	incidents, err := store.Default.Audit.ListIncidents(c.Request.Context(), kidScope(auth.MustPrincipal(c)), 200)
+	if err != nil {
+		log.Printf("⚠️ ListModerationPage: %v", err)
+		c.HTML(http.StatusInternalServerError, "moderation.html", gin.H{"error": "failed to load incidents", "csrfToken": csrf.GetToken(c)})
+		return
+	}
+	c.HTML(http.StatusOK, "moderation.html", gin.H{"Incidents": incidents, "csrfToken": csrf.GetToken(c)})
+}
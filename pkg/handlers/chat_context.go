@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/prompts"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Default chat context window, overridable with CHAT_CONTEXT_MESSAGES and CHAT_CONTEXT_TOKENS.
+const (
+	defaultContextMessages = 20
+	defaultContextTokens   = 3000
+)
+
+// chatContextLimits reads the context window configuration from the environment.
+func chatContextLimits() ai.WindowLimits {
+	return ai.WindowLimits{
+		MaxMessages: envInt("CHAT_CONTEXT_MESSAGES", defaultContextMessages),
+		MaxTokens:   envInt("CHAT_CONTEXT_TOKENS", defaultContextTokens),
+	}
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// BuildChatMessages assembles the message list for the next AI turn in a chat session:
+// the kid's policy as the system message, a running summary of older turns, and as much
+// recent history as fits the context window. Turns that fall out of the window are folded
+// into the stored summary so they are not lost.
+func BuildChatMessages(ctx context.Context, kid string, sid int64) ([]ai.Message, error) {
+	system, err := prompts.SystemMessage(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := store.Default.ChatSessions.Summary(ctx, sid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	messages, err := store.Default.ChatMessages.List(ctx, sid, sum.ThroughMessageID)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]ai.Message, len(messages))
+	for i, m := range messages {
+		role := ai.RoleUser
+		if m.Sender == "ai" {
+			role = ai.RoleAssistant
+		}
+		history[i] = ai.Message{Role: role, Content: m.Content}
+	}
+
+	reserved := ai.EstimateTokens(system) + ai.EstimateTokens(sum.Summary)
+	dropped, kept := ai.Window(history, chatContextLimits(), reserved)
+	if len(dropped) > 0 {
+		resp, err := ai.Summarize(ctx, ai.Default, sum.Summary, dropped)
+		quota.Record(ctx, quota.Call{Kid: kid, User: kid, Source: quota.SourceSummary}, resp)
+		if err != nil {
+			// Keep going with the old summary; the dropped turns are retried next time.
+			log.Printf("⚠️ BuildChatMessages: summarizing session %d failed: %v", sid, err)
+		} else {
+			sum = store.ChatSummary{Summary: resp.Content, ThroughMessageID: messages[len(dropped)-1].ID}
+			if err := store.Default.ChatSessions.SaveSummary(ctx, sid, sum); err != nil {
+				log.Printf("⚠️ BuildChatMessages: failed to save summary for session %d: %v", sid, err)
+			}
+		}
+	}
+
+	if sum.Summary != "" {
+		system += "\nSummary of the earlier conversation: " + sum.Summary
+	}
+	msgs := []ai.Message{{Role: ai.RoleSystem, Content: system}}
+	return append(msgs, ai.MergeTurns(kept)...), nil
+}
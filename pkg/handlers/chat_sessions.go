@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// chatSessionKey holds the session loaded by ChatSessionOwner in the gin context.
+const chatSessionKey = "chatSession"
+
+// maxChatTitleLength is the longest session title, in characters. Sessions without
+// a title are named after the start of their first message.
+const maxChatTitleLength = 60
+
+// ChatSessionOwner loads the chat session named by :id and lets the request through
+// only if it belongs to the logged-in kid. Other kids' sessions are reported as not
+// found. Use it after ChildRequired.
+func ChatSessionOwner(c *gin.Context) {
+	sid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+	kid := auth.MustPrincipal(c).ID
+	s, err := store.Default.ChatSessions.Get(c.Request.Context(), sid)
+	if err == nil && s.KidUsername != kid {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	} else if err != nil {
+		log.Printf("⚠️ ChatSessionOwner: session %d of %s: %v", sid, kid, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to load session"})
+		return
+	}
+	c.Set(chatSessionKey, s)
+	c.Next()
+}
+
+// currentChatSession returns the session loaded by ChatSessionOwner.
+func currentChatSession(c *gin.Context) store.ChatSession {
+	return c.MustGet(chatSessionKey).(store.ChatSession)
+}
+
+// chatSessionJSON is what the chat UI shows of a session.
+func chatSessionJSON(s store.ChatSession) gin.H {
+	h := gin.H{
+		"id": s.ID, "title": s.Title, "closed": s.ClosedAt != nil,
+		"created_at": s.CreatedAt.Format(time.RFC3339),
+	}
+	if s.LastMessageAt != nil {
+		h["last_message_at"] = s.LastMessageAt.Format(time.RFC3339)
+	}
+	return h
+}
+
+// chatTitle trims title to maxChatTitleLength characters.
+func chatTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) <= maxChatTitleLength {
+		return title
+	}
+	return string([]rune(title)[:maxChatTitleLength-1]) + "…"
+}
+
+// ListChatSessions returns the logged-in kid's chat sessions, most recently used
+// first.
+func ListChatSessions(c *gin.Context) {
+	kid := auth.MustPrincipal(c).ID
+	list, err := store.Default.ChatSessions.List(c.Request.Context(), kid)
+	if err != nil {
+		log.Printf("⚠️ ListChatSessions: failed for %s: %v", kid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	out := []gin.H{}
+	for _, s := range list {
+		out = append(out, chatSessionJSON(s))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// ResumeChatSession reopens a closed session so the kid can continue it.
+func ResumeChatSession(c *gin.Context) {
+	s := currentChatSession(c)
+	if s.ClosedAt != nil {
+		if err := store.Default.ChatSessions.Reopen(c.Request.Context(), s.ID); err != nil {
+			log.Printf("⚠️ ResumeChatSession: session %d: %v", s.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resume session"})
+			return
+		}
+		s.ClosedAt = nil
+	}
+	c.JSON(http.StatusOK, chatSessionJSON(s))
+}
+
+// RenameChatSession sets a session's title. An empty title names the session after
+// its first message again.
+func RenameChatSession(c *gin.Context) {
+	s := currentChatSession(c)
+	var body struct {
+		Title string `json:"title"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.Title = chatTitle(body.Title)
+	if s.Title == "" {
+		msgs, err := store.Default.ChatMessages.List(c.Request.Context(), s.ID, 0)
+		if err == nil && len(msgs) > 0 {
+			s.Title = chatTitle(msgs[0].Content)
+		}
+	}
+	if err := store.Default.ChatSessions.Rename(c.Request.Context(), s.ID, s.Title); err != nil {
+		log.Printf("⚠️ RenameChatSession: session %d: %v", s.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rename session"})
+		return
+	}
+	c.JSON(http.StatusOK, chatSessionJSON(s))
+}
+
+// CloseChatSession ends a session; it takes no new messages until resumed.
+func CloseChatSession(c *gin.Context) {
+	s := currentChatSession(c)
+	if s.ClosedAt == nil {
+		if err := store.Default.ChatSessions.Close(c.Request.Context(), s.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("⚠️ CloseChatSession: session %d: %v", s.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to close session"})
+			return
+		}
+		now := time.Now()
+		s.ClosedAt = &now
+	}
+	c.JSON(http.StatusOK, chatSessionJSON(s))
+}
+
+// DeleteChatSession removes a session and its messages. Moderation incidents from
+// it stay for the parents.
+func DeleteChatSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	s := currentChatSession(c)
+	err := store.Default.InTx(ctx, func(tx store.Repos) error {
+		return tx.ChatSessions.Delete(ctx, s.ID)
+	})
+	if err != nil {
+		log.Printf("⚠️ DeleteChatSession: session %d: %v", s.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete session"})
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "chat_session_deleted", s.KidUsername); err != nil {
+		log.Printf("⚠️ DeleteChatSession: failed to log event for %s: %v", s.KidUsername, err)
+	}
+	c.Status(http.StatusNoContent)
+}
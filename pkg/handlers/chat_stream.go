@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+)
+
+// StreamMessage is the Server-Sent Events variant of PostMessage. It accepts the same
+// JSON body and relays the AI answer as it is generated:
+//
+//	event: token  data: {"delta": "..."}
+//	event: done   data: {"message_id": 42, "content": "...", "warning": "..."}
+//	event: error  data: {"error": "..."}
+//
+// Text is released a sentence at a time, only after output moderation has seen it
+// (see moderation.Stream); the done event carries the final moderated answer, which
+// replaces what was streamed, and a warning when the kid's AI budgets are nearly
+// used up. The answer is stored once the stream ends. If the kid disconnects or the
+// provider fails part-way, what they were shown is stored as cancelled or partial.
+func StreamMessage(c *gin.Context) {
+	t, ok := beginChatTurn(c)
+	if !ok {
+		return
+	}
+	kid, sid := t.kid, t.sid
+
+	ctx := c.Request.Context()
+	subject, err := moderation.LoadSubject(ctx, kid)
+	if err != nil {
+		log.Printf("⚠️ StreamMessage: failed to load moderation subject %s: %v", kid, err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ms := moderation.Default.NewStream(subject)
+	resp, err := ai.Stream(ctx, ai.Default, ai.ChatRequest{Messages: t.msgs}, func(delta string) error {
+		out, err := ms.Write(ctx, delta)
+		if err != nil {
+			return err
+		}
+		if out != "" {
+			c.SSEvent("token", gin.H{"delta": out})
+			c.Writer.Flush()
+		}
+		return ctx.Err()
+	})
+
+	status := messageComplete
+	switch {
+	case err == nil, errors.Is(err, moderation.ErrBlocked):
+	case ctx.Err() != nil:
+		status = messageCancelled
+	default:
+		status = messagePartial
+		log.Printf("⚠️ StreamMessage: AI stream failed for %s: %v", kid, err)
+	}
+
+	// The kid may have gone; what they saw is still recorded.
+	saveCtx := context.WithoutCancel(ctx)
+	quota.Record(saveCtx, quota.Call{Kid: kid, User: kid, Source: quota.SourceChat}, resp)
+
+	// A complete answer is replaced by its moderated whole in the done event; one cut
+	// short stays as far as it was shown.
+	res := ms.Finish(saveCtx, status == messageComplete)
+	if status != messageComplete {
+		res.Content = ms.Sent()
+	}
+	if res.Action != moderation.Allow {
+		inc := moderation.Incident{Kid: kid, Source: "chat", SessionID: sid}
+		if err := moderation.Record(saveCtx, inc, ms.Raw(), res); err != nil {
+			log.Printf("⚠️ StreamMessage: failed to record moderation incident: %v", err)
+		}
+	}
+
+	var id int64
+	if res.Content != "" || status == messageComplete {
+		if id, err = saveChatMessage(saveCtx, sid, "ai", res.Content, status); err != nil {
+			log.Printf("⚠️ StreamMessage: failed to save AI msg: %v", err)
+		}
+	}
+
+	switch status {
+	case messageComplete:
+		done := gin.H{"message_id": id, "content": res.Content}
+		if t.warning != "" {
+			done["warning"] = t.warning
+		}
+		c.SSEvent("done", done)
+	case messagePartial:
+		c.SSEvent("error", gin.H{"error": "AI error", "message_id": id})
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// useTestDB points store.Default at a migrated SQLite database for the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	prev := store.Default
+	store.Init(dbtest.Migrate(t, dbtest.SQLite(t)))
+	t.Cleanup(func() { store.Default = prev })
+}
+
+// asKid is a resolver that logs every request in as kid.
+func asKid(kid string) auth.Resolver {
+	return func(c *gin.Context) (auth.Principal, bool) {
+		return auth.Principal{ID: kid, Kind: auth.KindKid, Method: auth.MethodSession}, true
+	}
+}
+
+// fakeModerator flags every text when flag is set.
+type fakeModerator struct{ flag bool }
+
+func (m fakeModerator) Moderate(ctx context.Context, text string) (ai.ModerationResult, error) {
+	return ai.ModerationResult{Flagged: m.flag, Categories: []string{"violence"}}, nil
+}
+
+func TestStreamMessageModerationModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTestDB(t)
+	ctx := context.Background()
+	if err := store.Default.Kids.Save(ctx, store.Kid{Username: "bob", Age: 9}); err != nil {
+		t.Fatal(err)
+	}
+	sid, err := store.Default.ChatSessions.Create(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := strings.Repeat("This sentence is long enough to be released on its own. ", 6)
+	prevAI, prevModeration := ai.Default, moderation.Default
+	t.Cleanup(func() { ai.Default, moderation.Default = prevAI, prevModeration })
+	ai.Default = ai.NewFake(ai.FakeReply{Reply: answer})
+
+	tests := []struct {
+		name       string
+		flag       bool
+		wantTokens bool
+		wantDone   string
+	}{
+		{"cleared answer streams", false, true, strings.TrimSpace(answer)},
+		{"blocked answer never streams", true, false, "fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moderation.Default = &moderation.Pipeline{
+				Fallback:      "fallback",
+				FinalCheckers: []moderation.Checker{moderation.Model{Moderator: fakeModerator{tt.flag}}},
+			}
+			r := gin.New()
+			r.POST("/chat/:id/stream", auth.Identify(asKid("bob")), ChatSessionOwner, StreamMessage)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/chat/"+strconv.FormatInt(sid, 10)+"/stream",
+				strings.NewReader(`{"content": "tell me a story"}`))
+			r.ServeHTTP(w, req)
+
+			body := w.Body.String()
+			if got := strings.Contains(body, "event:token"); got != tt.wantTokens {
+				t.Errorf("token events sent = %v, want %v:\n%s", got, tt.wantTokens, body)
+			}
+			if !strings.Contains(body, "event:done") || !strings.Contains(body, tt.wantDone) {
+				t.Errorf("done event does not carry %q:\n%s", tt.wantDone, body)
+			}
+		})
+	}
+}
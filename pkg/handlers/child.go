@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -14,13 +16,14 @@ import (
 
 	"github.com/schoolboylurk/data-sentinel/pkg/ai"
 	"github.com/schoolboylurk/data-sentinel/pkg/auth"
-	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
 )
 
 // ChildRequired middleware ensures the kid is logged in
 func ChildRequired(c *gin.Context) {
-	sess := sessions.Default(c)
-	if sess.Get("kid") == nil {
+	if p, ok := auth.CurrentPrincipal(c); !ok || !p.IsKid() || !p.IsSession() {
 		c.Redirect(http.StatusSeeOther, "/child/login")
 		c.Abort()
 		return
@@ -28,27 +31,73 @@ func ChildRequired(c *gin.Context) {
 	c.Next()
 }
 
-// ShowChildLogin renders the login page for kids.
+// ShowChildLogin renders the login page for kids. A code query parameter, as in the
+// links behind login QR codes, prefills the login code form.
 func ShowChildLogin(c *gin.Context) {
-	c.HTML(http.StatusOK, "child_login.html", gin.H{"csrfToken": csrf.GetToken(c)})
+	renderChildLogin(c, http.StatusOK, "")
+}
+
+// renderChildLogin renders child_login.html with an optional error.
+func renderChildLogin(c *gin.Context, status int, errMsg string) {
+	c.HTML(status, "child_login.html", gin.H{
+		"Pictures":  auth.Pictures,
+		"Username":  c.PostForm("username"),
+		"Code":      c.Query("code"),
+		"error":     errMsg,
+		"csrfToken": csrf.GetToken(c),
+	})
 }
 
-// PerformChildLogin authenticates a kid by username.
+// PerformChildLogin authenticates a kid by username and PIN or picture password.
 func PerformChildLogin(c *gin.Context) {
+	ctx := c.Request.Context()
 	username := c.PostForm("username")
+	kind, secret := auth.SecretPIN, c.PostForm("pin")
+	if secret == "" {
+		kind, secret = auth.SecretPicture, c.PostForm("pictures")
+	}
 
-	var age int
-	if err := database.DB.QueryRow(
-		"SELECT age FROM kids WHERE username = ?", username,
-	).Scan(&age); err != nil {
-		log.Printf("PerformChildLogin: unknown kid %s: %v", username, err)
-		c.HTML(http.StatusUnauthorized, "child_login.html", gin.H{"error": "invalid username", "csrfToken": csrf.GetToken(c)})
+	switch err := auth.AuthenticateKid(ctx, username, kind, secret); {
+	case errors.Is(err, auth.ErrLocked):
+		renderChildLogin(c, http.StatusTooManyRequests, "Too many tries! Ask a parent to help you log in.")
+		return
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		log.Printf("PerformChildLogin: failed login for %s", username)
+		renderChildLogin(c, http.StatusUnauthorized, "That's not right. Try again!")
+		return
+	case err != nil:
+		log.Printf("⚠️ PerformChildLogin: %s: %v", username, err)
+		renderChildLogin(c, http.StatusInternalServerError, "Something went wrong. Try again later.")
 		return
 	}
+	startKidSession(c, username)
+}
 
+// PerformChildCodeLogin logs a kid in with a one-time code issued by a parent.
+func PerformChildCodeLogin(c *gin.Context) {
+	kid, err := auth.RedeemLoginCode(c.Request.Context(), c.PostForm("code"))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		renderChildLogin(c, http.StatusUnauthorized, "That code doesn't work. Ask a parent for a new one.")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ PerformChildCodeLogin: %v", err)
+		renderChildLogin(c, http.StatusInternalServerError, "Something went wrong. Try again later.")
+		return
+	}
+	startKidSession(c, kid)
+}
+
+// startKidSession logs kid in on this session and sends them to the chat.
+func startKidSession(c *gin.Context, username string) {
 	sess := sessions.Default(c)
+	sess.Delete("user") // one identity per session
+	sess.Delete("role")
 	sess.Set("kid", username)
-	sess.Save()
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ startKidSession: failed to save session for %s: %v", username, err)
+		renderChildLogin(c, http.StatusInternalServerError, "Something went wrong. Try again later.")
+		return
+	}
 
 	ctx := context.Background()
 
@@ -58,24 +107,163 @@ func PerformChildLogin(c *gin.Context) {
 	if _, err := auth.PermitClient.Api.Users.SyncUser(ctx, userCreate); err != nil {
 		log.Printf("Permit SyncUser failed for %s: %v", username, err)
 	}
+	if err := store.Default.Audit.LogEvent(ctx, "kid_login_succeeded", username); err != nil {
+		log.Printf("⚠️ startKidSession: failed to log event for %s: %v", username, err)
+	}
 	c.Redirect(http.StatusSeeOther, "/child/chat")
 }
 
-// ShowChildPromptPage is the legacy single‐prompt UI.
+// Number of past requests shown on a kid's prompt page.
+const childRequestHistory = 20
+
+// ShowChildPromptPage renders the single-prompt form and the kid's past requests.
 func ShowChildPromptPage(c *gin.Context) {
-	c.HTML(http.StatusOK, "child.html", gin.H{"csrfToken": csrf.GetToken(c)})
+	renderChildPrompt(c, http.StatusOK, "")
+}
+
+// renderChildPrompt renders child.html for the logged-in kid, with an optional error.
+func renderChildPrompt(c *gin.Context, status int, errMsg string) {
+	kid := auth.MustPrincipal(c).ID
+	reqs, err := store.Default.PromptRequests.ListByKid(c.Request.Context(), kid, childRequestHistory)
+	if err != nil {
+		log.Printf("⚠️ ShowChildPromptPage: failed to list requests for %s: %v", kid, err)
+		status, errMsg = http.StatusInternalServerError, "could not load your questions"
+	}
+	c.HTML(status, "child.html", gin.H{
+		"Kid":       kid,
+		"Requests":  reqs,
+		"error":     errMsg,
+		"csrfToken": csrf.GetToken(c),
+	})
 }
 
-// HandleChildPrompt handles that single‐prompt flow.
+// HandleChildPrompt submits the logged-in kid's question for parent approval and
+// redirects to its status page.
 func HandleChildPrompt(c *gin.Context) {
-	// invoke RequestPromptHandler logic,
-	// then redirect to /child/status/:id
+	p := auth.MustPrincipal(c)
+	id, status, body := submitPromptRequest(c.Request.Context(), p, p.ID, c.PostForm("prompt"))
+	if body != nil {
+		msg, _ := body["error"].(string)
+		renderChildPrompt(c, status, msg)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/child/status/%d", id))
 }
 
-// ShowChildStatusPage renders status/answer for a single prompt.
+// RequestViewerRequired lets a logged-in kid or parent through and sends anyone else
+// to the kid login page. Which requests they may see is checked per request.
+func RequestViewerRequired(c *gin.Context) {
+	if p, ok := auth.CurrentPrincipal(c); !ok || !p.IsSession() {
+		c.Redirect(http.StatusSeeOther, "/child/login")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// loadViewableRequest returns the request named by the id parameter if the logged-in
+// kid owns it, or one of their guardians or an admin is logged in. Otherwise it calls
+// fail and returns ok=false.
+func loadViewableRequest(c *gin.Context, fail func(status int, msg string)) (store.PromptRequest, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		fail(http.StatusBadRequest, "invalid request ID")
+		return store.PromptRequest{}, false
+	}
+	pr, err := store.Default.PromptRequests.Get(c.Request.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		fail(http.StatusNotFound, "request not found")
+		return pr, false
+	}
+	if err != nil {
+		log.Printf("⚠️ loadViewableRequest: failed to load prompt_requests id=%d: %v", id, err)
+		fail(http.StatusInternalServerError, "db lookup failed")
+		return pr, false
+	}
+
+	p := auth.MustPrincipal(c)
+	allowed := ownsRequest(p, pr)
+	if !allowed && p.Kind == auth.KindUser {
+		if allowed, err = looksAfter(c.Request.Context(), p, pr.Username); err != nil {
+			log.Printf("⚠️ loadViewableRequest: %v", err)
+			fail(http.StatusInternalServerError, "authorization error")
+			return store.PromptRequest{}, false
+		}
+	}
+	if !allowed {
+		fail(http.StatusForbidden, "permission denied")
+		return store.PromptRequest{}, false
+	}
+	return pr, true
+}
+
+// ownsRequest reports whether p is the kid who asked pr.
+func ownsRequest(p auth.Principal, pr store.PromptRequest) bool {
+	return p.IsKid() && p.ID == pr.Username
+}
+
+// ShowChildStatusPage renders the state of a single request and, once a parent has
+// approved it, the answer.
 func ShowChildStatusPage(c *gin.Context) {
-	// query prompt_requests for this kid and ID,
-	// if approved render answer via "child_status.html"
+	pr, ok := loadViewableRequest(c, func(status int, msg string) {
+		c.HTML(status, "child_status.html", gin.H{"error": msg})
+	})
+	if !ok {
+		return
+	}
+	c.HTML(http.StatusOK, "child_status.html", gin.H{
+		"Request": pr,
+		"IsKid":   ownsRequest(auth.MustPrincipal(c), pr),
+	})
+}
+
+// GetChildRequestStatus returns a request's state, and its answer once there is one,
+// for the status page to poll.
+func GetChildRequestStatus(c *gin.Context) {
+	pr, ok := loadViewableRequest(c, func(status int, msg string) {
+		c.JSON(status, gin.H{"error": msg})
+	})
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, requestStatusJSON(pr))
+}
+
+// ListChildRequests returns the logged-in kid's recent requests, newest first.
+func ListChildRequests(c *gin.Context) {
+	kid := auth.MustPrincipal(c).ID
+	reqs, err := store.Default.PromptRequests.ListByKid(c.Request.Context(), kid, childRequestHistory)
+	if err != nil {
+		log.Printf("⚠️ ListChildRequests: failed for %s: %v", kid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch requests"})
+		return
+	}
+
+	out := []gin.H{}
+	for _, pr := range reqs {
+		out = append(out, requestStatusJSON(pr))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// requestStatusJSON is what a kid may see of a request. Generation errors stay
+// with the parents.
+func requestStatusJSON(pr store.PromptRequest) gin.H {
+	h := gin.H{
+		"request_id": pr.ID,
+		"status":     pr.Status,
+		"prompt":     pr.Prompt,
+		"created_at": pr.CreatedAt.Format(time.RFC3339),
+	}
+	switch pr.Status {
+	case store.RequestAnswered:
+		h["answer"] = pr.Answer
+		h["answered_at"] = pr.AnsweredAt.Format(time.RFC3339)
+	case store.RequestDenied:
+		h["reason"] = pr.Reason
+		h["denied_at"] = pr.DeniedAt.Format(time.RFC3339)
+	}
+	return h
 }
 
 // ShowChildChatPage renders the persistent chat UI.
@@ -85,99 +273,144 @@ func ShowChildChatPage(c *gin.Context) {
 
 // StartChatSession creates a new chat session for the logged-in kid.
 func StartChatSession(c *gin.Context) {
-	kid := sessions.Default(c).Get("kid").(string)
+	kid := auth.MustPrincipal(c).ID
 
-	res, err := database.DB.Exec("INSERT INTO chat_sessions(kid_username) VALUES(?)", kid)
+	sid, err := store.Default.ChatSessions.Create(c.Request.Context(), kid)
 	if err != nil {
 		log.Printf("StartChatSession: failed for %s: %v", kid, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
 		return
 	}
-	sid, _ := res.LastInsertId()
 	c.JSON(http.StatusCreated, gin.H{"session_id": sid})
 }
 
 // PostMessage handles a kid’s message, enforces policy, calls AI, and records both sides.
 func PostMessage(c *gin.Context) {
-	sidParam := c.Param("id")
-	sid, err := strconv.Atoi(sidParam)
+	t, ok := beginChatTurn(c)
+	if !ok {
+		return
+	}
+
+	// call AI
+	ctx := c.Request.Context()
+	resp, err := ai.Complete(ctx, ai.Default, ai.ChatRequest{Messages: t.msgs})
+	quota.Record(ctx, quota.Call{Kid: t.kid, User: t.kid, Source: quota.SourceChat}, resp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		log.Printf("⚠️ PostMessage: AI call failed for %s: %v", t.kid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI error"})
 		return
 	}
+	answer := moderation.Moderate(ctx,
+		moderation.Incident{Kid: t.kid, Source: "chat", SessionID: t.sid}, resp.Content)
+
+	// save AI response
+	if _, err := saveChatMessage(ctx, t.sid, "ai", answer, messageComplete); err != nil {
+		log.Printf("⚠️ PostMessage: failed to save AI msg: %v", err)
+	}
+
+	out := gin.H{"answer": answer}
+	if t.warning != "" {
+		out["warning"] = t.warning
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// Values of chat_messages.status.
+const (
+	messageComplete  = "complete"
+	messagePartial   = "partial"   // the provider failed mid-answer
+	messageCancelled = "cancelled" // the kid disconnected mid-answer
+)
+
+// chatTurn is a kid's chat message that may be answered.
+type chatTurn struct {
+	kid     string
+	sid     int64
+	msgs    []ai.Message // the AI context, ending with the message
+	warning string       // about the kid's AI budgets, if any
+}
+
+// beginChatTurn validates a kid's chat message to the session loaded by
+// ChatSessionOwner, checks the AI budgets, stores the message and builds the AI
+// context. On failure it writes the error response and returns ok=false.
+func beginChatTurn(c *gin.Context) (chatTurn, bool) {
+	s := currentChatSession(c)
+	if s.ClosedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "session is closed; resume it first"})
+		return chatTurn{}, false
+	}
+	t := chatTurn{sid: s.ID}
 
 	var body struct {
 		Content string `json:"content"`
 	}
 	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return chatTurn{}, false
 	}
 
 	if len(body.Content) > MaxPromptLength {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Message too long (max %d chars)", MaxPromptLength),
 		})
-		return
+		return chatTurn{}, false
 	}
 
-	kid := sessions.Default(c).Get("kid").(string)
-	wrapped := WrapPromptWithPolicy(kid, body.Content)
-
-	// save kid’s message
-	if _, err := database.DB.Exec(
-		"INSERT INTO chat_messages(session_id,sender,content) VALUES(?,?,?)",
-		sid, "kid", body.Content,
-	); err != nil {
-		log.Printf("⚠️ PostMessage: failed to save kid msg: %v", err)
+	t.kid = auth.MustPrincipal(c).ID
+	if !enforcePromptPolicy(c, t.kid, body.Content, false) {
+		return chatTurn{}, false
+	}
+	warning, ok := enforceBudget(c, t.kid)
+	if !ok {
+		return chatTurn{}, false
 	}
+	t.warning = warning
 
-	// call AI
-	answer, err := ai.GenerateReport(wrapped)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI error"})
-		return
+	// save kid’s message; it becomes the last turn of the conversation context
+	if _, err := saveChatMessage(c.Request.Context(), t.sid, "kid", body.Content, messageComplete); err != nil {
+		log.Printf("⚠️ beginChatTurn: failed to save kid msg: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save message"})
+		return chatTurn{}, false
+	}
+	if s.Title == "" {
+		if err := store.Default.ChatSessions.Rename(c.Request.Context(), t.sid, chatTitle(body.Content)); err != nil {
+			log.Printf("⚠️ beginChatTurn: failed to name session %d: %v", t.sid, err)
+		}
 	}
 
-	// save AI response
-	if _, err := database.DB.Exec(
-		"INSERT INTO chat_messages(session_id,sender,content) VALUES(?,?,?)",
-		sid, "ai", answer,
-	); err != nil {
-		log.Printf("⚠️ PostMessage: failed to save AI msg: %v", err)
+	var err error
+	t.msgs, err = BuildChatMessages(c.Request.Context(), t.kid, t.sid)
+	if err != nil {
+		log.Printf("⚠️ beginChatTurn: failed to build context for session %d: %v", t.sid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load conversation"})
+		return chatTurn{}, false
 	}
+	return t, true
+}
 
-	c.JSON(http.StatusOK, gin.H{"answer": answer})
+// saveChatMessage records one side of a chat exchange and returns its ID.
+func saveChatMessage(ctx context.Context, sid int64, sender, content, status string) (int64, error) {
+	return store.Default.ChatMessages.Add(ctx, store.ChatMessage{
+		SessionID: sid, Sender: sender, Content: content, Status: status,
+	})
 }
 
-// GetChatHistory returns the full chat history for a session.
+// GetChatHistory returns the full chat history of the session loaded by
+// ChatSessionOwner.
 func GetChatHistory(c *gin.Context) {
-	sidParam := c.Param("id")
-	sid, err := strconv.Atoi(sidParam)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
-		return
-	}
-
-	rows, err := database.DB.Query(
-		"SELECT sender,content,timestamp FROM chat_messages WHERE session_id = ? ORDER BY id",
-		sid,
-	)
+	history, err := store.Default.ChatMessages.List(c.Request.Context(), currentChatSession(c).ID, 0)
 	if err != nil {
 		log.Printf("⚠️ GetChatHistory: query failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch history"})
 		return
 	}
-	defer rows.Close()
 
 	var msgs []gin.H
-	for rows.Next() {
-		var sender, content, ts string
-		if err := rows.Scan(&sender, &content, &ts); err != nil {
-			log.Printf("⚠️ GetChatHistory: scan failed: %v", err)
-			continue
-		}
-		msgs = append(msgs, gin.H{"sender": sender, "content": content, "timestamp": ts})
+	for _, m := range history {
+		msgs = append(msgs, gin.H{
+			"sender": m.Sender, "content": m.Content, "status": m.Status,
+			"timestamp": m.Timestamp.Format(time.RFC3339),
+		})
 	}
 
 	c.JSON(http.StatusOK, msgs)
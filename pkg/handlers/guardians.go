@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// kidScope returns the kids p may see: a parent only their own, admins and
+// automation every kid (Permit still checks their actions per kid).
+func kidScope(p auth.Principal) store.Scope {
+	if p.Role == auth.RoleParent {
+		return store.Scope{Guardian: p.ID}
+	}
+	return store.Scope{}
+}
+
+// looksAfter reports whether kid is in p's scope.
+func looksAfter(ctx context.Context, p auth.Principal, kid string) (bool, error) {
+	scope := kidScope(p)
+	if scope.All() {
+		return true, nil
+	}
+	return store.Default.Guardians.IsGuardian(ctx, kid, scope.Guardian)
+}
+
+// renderKids renders kids.html with the kids in scope, their guardians and login
+// state, the user's notifications and an optional error.
+func renderKids(c *gin.Context, status int, errMsg string) {
+	renderKidsPage(c, status, errMsg, nil)
+}
+
+// renderKidsPage is renderKids that can also show a login code just issued.
+func renderKidsPage(c *gin.Context, status int, errMsg string, code *issuedLoginCode) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	scope := kidScope(p)
+	kids, err := store.Default.Kids.List(ctx, scope)
+	var guardians map[string][]string
+	if err == nil {
+		guardians, err = store.Default.Guardians.ByKid(ctx, scope)
+	}
+	var creds map[string]store.KidCredential
+	if err == nil {
+		creds, err = store.Default.KidCredentials.List(ctx, scope)
+	}
+	var notes []store.Notification
+	if err == nil {
+		notes, err = store.Default.Notifications.Unread(ctx, p.ID)
+	}
+	if err != nil {
+		log.Printf("⚠️ renderKids: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load kids"
+	}
+	c.HTML(status, "kids.html", gin.H{
+		"Kids":          kids,
+		"Guardians":     guardians,
+		"Credentials":   creds,
+		"Notifications": notes,
+		"Pictures":      auth.Pictures,
+		"LoginCode":     code,
+		"Now":           time.Now(),
+		"error":         errMsg,
+		"csrfToken":     csrf.GetToken(c),
+	})
+}
+
+// mayManageKid checks that the kid named by the username parameter exists and is in
+// the logged-in user's scope. Otherwise it renders the error and returns false.
+func mayManageKid(c *gin.Context) (string, bool) {
+	ctx := c.Request.Context()
+	kid := c.Param("username")
+	ok, err := looksAfter(ctx, auth.MustPrincipal(c), kid)
+	if err == nil && ok {
+		_, err = store.Default.Kids.Get(ctx, kid)
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderKids(c, http.StatusNotFound, "kid not found")
+	case err != nil:
+		log.Printf("⚠️ mayManageKid: %s: %v", kid, err)
+		renderKids(c, http.StatusInternalServerError, "failed to check guardianship")
+	case !ok:
+		renderKids(c, http.StatusForbidden, "you can only manage your own kids")
+	default:
+		return kid, true
+	}
+	return "", false
+}
+
+// AddGuardian makes another parent account a guardian of a kid, e.g. a co-parent.
+// Only the kid's guardians and admins may do so.
+func AddGuardian(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	kid, ok := mayManageKid(c)
+	if !ok {
+		return
+	}
+	guardian := c.PostForm("guardian")
+	u, err := store.Default.Users.Get(ctx, guardian)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && u.Role == auth.RoleAgent) {
+		renderKids(c, http.StatusBadRequest, "guardians must be parent or admin accounts")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ AddGuardian: %v", err)
+		renderKids(c, http.StatusInternalServerError, "failed to look up account")
+		return
+	}
+
+	if err := store.Default.Guardians.Add(ctx, kid, guardian, p.ID); errors.Is(err, store.ErrDuplicate) {
+		renderKids(c, http.StatusConflict, guardian+" is already a guardian of "+kid)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ AddGuardian: %s for %s: %v", guardian, kid, err)
+		renderKids(c, http.StatusInternalServerError, "failed to add guardian")
+		return
+	}
+	auth.SyncGuardian(ctx, kid, guardian)
+
+	subject := store.ActorOnKid(p.ID, kid)
+	if err := store.Default.Audit.LogEvent(ctx, "guardian_added", subject); err != nil {
+		log.Printf("⚠️ AddGuardian: failed to log event for %s: %v", subject, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
+
+// RemoveGuardian ends an account's guardianship of a kid. Guardians may remove
+// themselves or a co-parent; admins anyone.
+func RemoveGuardian(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	kid, ok := mayManageKid(c)
+	if !ok {
+		return
+	}
+	guardian := c.Param("guardian")
+
+	if err := store.Default.Guardians.Remove(ctx, kid, guardian); errors.Is(err, store.ErrNotFound) {
+		renderKids(c, http.StatusNotFound, guardian+" is not a guardian of "+kid)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ RemoveGuardian: %s for %s: %v", guardian, kid, err)
+		renderKids(c, http.StatusInternalServerError, "failed to remove guardian")
+		return
+	}
+	auth.UnsyncGuardian(ctx, kid, guardian)
+
+	subject := store.ActorOnKid(p.ID, kid)
+	if err := store.Default.Audit.LogEvent(ctx, "guardian_removed", subject); err != nil {
+		log.Printf("⚠️ RemoveGuardian: failed to log event for %s: %v", subject, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
@@ -1,35 +1,86 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/policy"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
 )
 
-// WrapPromptWithPolicy loads the kid's age and content policy, then constructs the system message
-// and returns the full prompt for the AI.
-func WrapPromptWithPolicy(kid, prompt string) string {
-	var age int
-	var allowList, restrictList string
-	// Retrieve age
-	if err := database.DB.QueryRow(
-		"SELECT age FROM kids WHERE username = ?", kid,
-	).Scan(&age); err != nil {
-		// Default age or log error if needed
-		age = 0
+// enforcePromptPolicy runs the pre-flight restricted-topic check for kid's prompt.
+// forApproval relaxes the check for prompts headed to a parent for approval, so
+// require-approval topics pass. On a violation it responds 403 with a reason code
+// and returns false.
+func enforcePromptPolicy(c *gin.Context, kid, prompt string, forApproval bool) bool {
+	if status, body := promptPolicyViolation(c.Request.Context(), kid, prompt, forApproval); body != nil {
+		c.JSON(status, body)
+		return false
+	}
+	return true
+}
+
+// promptPolicyViolation is enforcePromptPolicy without the response: it returns the
+// status and body to send, or a nil body if the prompt is allowed.
+func promptPolicyViolation(ctx context.Context, kid, prompt string, forApproval bool) (int, gin.H) {
+	check := policy.Check
+	if forApproval {
+		check = policy.CheckForApproval
+	}
+	v, err := check(ctx, kid, prompt)
+	if err != nil {
+		log.Printf("⚠️ enforcePromptPolicy: policy check failed for %s: %v", kid, err)
+		return http.StatusInternalServerError, gin.H{"error": "policy check failed"}
 	}
+	if v != nil {
+		return http.StatusForbidden, gin.H{
+			"error":  "prompt violates content policy",
+			"reason": v.Reason,
+			"topic":  v.Topic,
+		}
+	}
+	return http.StatusOK, nil
+}
 
-	// Retrieve allow and restrict lists
-	if err := database.DB.QueryRow(
-		"SELECT allowed, restricted FROM content_policies WHERE kid_username = ?", kid,
-	).Scan(&allowList, &restrictList); err != nil {
-		// Default to empty lists or log error
-		allowList, restrictList = "", ""
+// enforceBudget checks, before the AI provider is called for kid, that neither kid's
+// AI budgets nor the household's are used up. If one is it responds 429 with
+// Retry-After set to when the budget resets, and returns false. Otherwise it returns
+// the warning to show the kid, if any.
+func enforceBudget(c *gin.Context, kid string) (string, bool) {
+	warning, status, body := budgetExhausted(c.Request.Context(), kid)
+	if body != nil {
+		if retry, ok := body["retry_after"].(int); ok {
+			c.Header("Retry-After", strconv.Itoa(retry))
+		}
+		c.JSON(status, body)
+		return "", false
 	}
+	return warning, true
+}
 
-	sysMsg := fmt.Sprintf(
-		"You are an AI assistant for a %d-year-old. Allowed topics: %s. Restricted topics: %s.",
-		age, allowList, restrictList,
-	)
-	return sysMsg + "\nUser asks: " + prompt
+// budgetExhausted is enforceBudget without the response: it returns the warning, or
+// the status and body to send if a budget is used up.
+func budgetExhausted(ctx context.Context, kid string) (string, int, gin.H) {
+	warning, err := quota.Check(ctx, kid)
+	var exhausted *quota.ExhaustedError
+	if errors.As(err, &exhausted) {
+		return "", http.StatusTooManyRequests, gin.H{
+			"error":       exhausted.Error(),
+			"period":      exhausted.Budget.Period,
+			"resets_at":   exhausted.Budget.Resets.Format(time.RFC3339),
+			"retry_after": int(math.Ceil(time.Until(exhausted.Budget.Resets).Seconds())),
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️ enforceBudget: budget check failed for %s: %v", kid, err)
+		return "", http.StatusInternalServerError, gin.H{"error": "budget check failed"}
+	}
+	return warning, http.StatusOK, nil
 }
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// issuedLoginCode is a one-time login code shown once on the kids page.
+type issuedLoginCode struct {
+	Kid     string
+	Code    string
+	Expires time.Time
+}
+
+// SetKidSecret sets a kid's PIN or picture password.
+func SetKidSecret(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	kid, ok := mayManageKid(c)
+	if !ok {
+		return
+	}
+	kind, secret := c.PostForm("kind"), c.PostForm("pin")
+	if kind == auth.SecretPicture {
+		secret = c.PostForm("pictures")
+	}
+	if err := auth.SetKidSecret(ctx, kid, kind, secret, p.ID); err != nil {
+		log.Printf("⚠️ SetKidSecret: %s: %v", kid, err)
+		renderKids(c, http.StatusBadRequest, "failed to set login for "+kid+": "+err.Error())
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "kid_secret_set", p.ID); err != nil {
+		log.Printf("⚠️ SetKidSecret: failed to log event for %s: %v", p.ID, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
+
+// UnlockKid lifts a kid's lockout after too many wrong logins.
+func UnlockKid(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	kid, ok := mayManageKid(c)
+	if !ok {
+		return
+	}
+	if err := store.Default.KidCredentials.Unlock(ctx, kid); errors.Is(err, store.ErrNotFound) {
+		renderKids(c, http.StatusNotFound, kid+" has no PIN or picture password")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ UnlockKid: %s: %v", kid, err)
+		renderKids(c, http.StatusInternalServerError, "failed to unlock "+kid)
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "kid_unlocked", p.ID); err != nil {
+		log.Printf("⚠️ UnlockKid: failed to log event for %s: %v", p.ID, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
+
+// IssueKidLoginCode creates a one-time login code for a kid and shows it, with a QR
+// code, once.
+func IssueKidLoginCode(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	kid, ok := mayManageKid(c)
+	if !ok {
+		return
+	}
+	code, expires, err := auth.IssueLoginCode(ctx, kid, p.ID)
+	if err != nil {
+		log.Printf("⚠️ IssueKidLoginCode: %s: %v", kid, err)
+		renderKids(c, http.StatusInternalServerError, "failed to issue login code")
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "kid_login_code_issued", p.ID); err != nil {
+		log.Printf("⚠️ IssueKidLoginCode: failed to log event for %s: %v", p.ID, err)
+	}
+	renderKidsPage(c, http.StatusCreated, "", &issuedLoginCode{Kid: kid, Code: code, Expires: expires})
+}
+
+// DismissNotification marks one of the logged-in user's notifications as read.
+func DismissNotification(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		renderKids(c, http.StatusBadRequest, "invalid notification ID")
+		return
+	}
+	err = store.Default.Notifications.MarkRead(c.Request.Context(), id, auth.MustPrincipal(c).ID)
+	if errors.Is(err, store.ErrNotFound) {
+		renderKids(c, http.StatusNotFound, "notification not found")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ DismissNotification: id=%d: %v", id, err)
+		renderKids(c, http.StatusInternalServerError, "failed to dismiss notification")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/kids")
+}
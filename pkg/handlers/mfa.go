@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Session keys of a login waiting for its second factor. The user key is only set
+// once the code is checked.
+const (
+	mfaUserKey  = "mfa_user"
+	mfaSinceKey = "mfa_since"
+)
+
+// mfaPendingTTL is how long after the password the second factor may be entered.
+const mfaPendingTTL = 5 * time.Minute
+
+// ShowMFALogin renders the second login step for accounts with TOTP on.
+func ShowMFALogin(c *gin.Context) {
+	if _, ok := pendingMFAUser(c); !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	c.HTML(http.StatusOK, "login_mfa.html", gin.H{"csrfToken": csrf.GetToken(c)})
+}
+
+// PerformMFALogin checks the TOTP or recovery code of a login whose password was
+// accepted and starts its session.
+func PerformMFALogin(c *gin.Context) {
+	ctx := c.Request.Context()
+	username, ok := pendingMFAUser(c)
+	if !ok {
+		renderLogin(c, http.StatusUnauthorized, "login expired, try again")
+		return
+	}
+
+	recovery, err := auth.VerifySecondFactor(ctx, username, c.PostForm("code"))
+	switch {
+	case errors.Is(err, auth.ErrLocked):
+		if clearPendingMFA(c) {
+			renderLogin(c, http.StatusTooManyRequests, err.Error())
+		}
+		return
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		if err := store.Default.Audit.LogEvent(ctx, "mfa_failed", username); err != nil {
+			log.Printf("⚠️ PerformMFALogin: failed to log event for %s: %v", username, err)
+		}
+		c.HTML(http.StatusUnauthorized, "login_mfa.html", gin.H{"error": "invalid code", "csrfToken": csrf.GetToken(c)})
+		return
+	case err != nil:
+		log.Printf("⚠️ PerformMFALogin: %s: %v", username, err)
+		c.HTML(http.StatusInternalServerError, "login_mfa.html", gin.H{"error": "login failed, try again", "csrfToken": csrf.GetToken(c)})
+		return
+	}
+
+	u, err := store.Default.Users.Get(ctx, username)
+	if err != nil {
+		log.Printf("⚠️ PerformMFALogin: %s: %v", username, err)
+		c.HTML(http.StatusInternalServerError, "login_mfa.html", gin.H{"error": "login failed, try again", "csrfToken": csrf.GetToken(c)})
+		return
+	}
+	if recovery {
+		if err := store.Default.Audit.LogEvent(ctx, "mfa_recovery_code_used", username); err != nil {
+			log.Printf("⚠️ PerformMFALogin: failed to log event for %s: %v", username, err)
+		}
+	}
+	startUserSession(c, u)
+}
+
+// startPendingMFA remembers that username passed the first login step and asks for
+// the second factor. The session only gets the user key after the code.
+func startPendingMFA(c *gin.Context, username string) {
+	sess := sessions.Default(c)
+	sess.Delete("kid")
+	sess.Delete("user")
+	sess.Delete("role")
+	sess.Set(mfaUserKey, username)
+	sess.Set(mfaSinceKey, time.Now().Unix())
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ startPendingMFA: failed to save session for %s: %v", username, err)
+		renderLogin(c, http.StatusInternalServerError, "login failed, try again")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/login/mfa")
+}
+
+// pendingMFAUser returns the account waiting for its second factor on this session.
+func pendingMFAUser(c *gin.Context) (string, bool) {
+	sess := sessions.Default(c)
+	username, _ := sess.Get(mfaUserKey).(string)
+	since, _ := sess.Get(mfaSinceKey).(int64)
+	if username == "" || time.Since(time.Unix(since, 0)) > mfaPendingTTL {
+		return "", false
+	}
+	return username, true
+}
+
+// clearPendingMFA forgets a login waiting for its second factor. When the session
+// cannot be saved it renders the login page with 500 and returns false.
+func clearPendingMFA(c *gin.Context) bool {
+	sess := sessions.Default(c)
+	sess.Delete(mfaUserKey)
+	sess.Delete(mfaSinceKey)
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ clearPendingMFA: failed to save session: %v", err)
+		renderLogin(c, http.StatusInternalServerError, "login failed, try again")
+		return false
+	}
+	return true
+}
+
+// MFAEnrolled keeps parents and admins logged in without TOTP out while an admin
+// has made it mandatory: pages send them to the enrollment page and other requests
+// get 403. Kids and API tokens pass. Use it on every route session users can reach.
+func MFAEnrolled(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	if p.Kind != auth.KindUser || !p.IsSession() {
+		c.Next()
+		return
+	}
+	required, err := auth.MFARequired(ctx)
+	if err == nil && required {
+		var on bool
+		if on, err = auth.MFAEnabled(ctx, p.ID); err == nil && !on {
+			c.Abort()
+			if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+				c.Redirect(http.StatusSeeOther, "/admin/mfa")
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication required"})
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️ MFAEnrolled: %s: %v", p.ID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Next()
+}
+
+// ShowMFAPage shows the logged-in user's two-factor settings.
+func ShowMFAPage(c *gin.Context) {
+	renderMFA(c, http.StatusOK, "", nil)
+}
+
+// renderMFA renders mfa.html with an optional error, or recovery codes just made.
+func renderMFA(c *gin.Context, status int, errMsg string, codes []string) {
+	ctx := c.Request.Context()
+	username := auth.MustPrincipal(c).ID
+	data := gin.H{"error": errMsg, "RecoveryCodes": codes, "csrfToken": csrf.GetToken(c)}
+
+	t, err := store.Default.TOTP.Get(ctx, username)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		err = nil
+	case err == nil && t.EnabledAt != nil:
+		data["Enabled"] = t.EnabledAt
+		data["Remaining"], err = store.Default.RecoveryCodes.Remaining(ctx, username)
+	case err == nil:
+		data["Secret"] = t.Secret
+		var qr template.URL
+		qr, err = auth.TOTPQRCode(username, t.Secret)
+		data["QRCode"] = qr
+	}
+	if err == nil {
+		data["Required"], err = auth.MFARequired(ctx)
+	}
+	if err != nil {
+		log.Printf("⚠️ renderMFA: %s: %v", username, err)
+		data["error"], status = "failed to load two-factor settings", http.StatusInternalServerError
+	}
+	c.HTML(status, "mfa.html", data)
+}
+
+// BeginMFA creates a TOTP key for the logged-in user and shows its QR code.
+func BeginMFA(c *gin.Context) {
+	username := auth.MustPrincipal(c).ID
+	if err := auth.BeginTOTP(c.Request.Context(), username); errors.Is(err, auth.ErrMFAEnabled) {
+		renderMFA(c, http.StatusConflict, err.Error(), nil)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ BeginMFA: %s: %v", username, err)
+		renderMFA(c, http.StatusInternalServerError, "failed to start two-factor setup", nil)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/mfa")
+}
+
+// EnableMFA completes enrollment with a code from the authenticator app and shows
+// the recovery codes once.
+func EnableMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := auth.MustPrincipal(c).ID
+	codes, err := auth.EnableTOTP(ctx, username, c.PostForm("code"))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		renderMFA(c, http.StatusBadRequest, "invalid code, check your device's clock and try again", nil)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ EnableMFA: %s: %v", username, err)
+		renderMFA(c, http.StatusBadRequest, "failed to turn on two-factor authentication: "+err.Error(), nil)
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "mfa_enabled", username); err != nil {
+		log.Printf("⚠️ EnableMFA: failed to log event for %s: %v", username, err)
+	}
+	renderMFA(c, http.StatusOK, "", codes)
+}
+
+// RegenerateRecoveryCodes replaces the logged-in user's recovery codes.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := auth.MustPrincipal(c).ID
+	codes, err := auth.RegenerateRecoveryCodes(ctx, username, c.PostForm("code"))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		renderMFA(c, http.StatusBadRequest, "invalid code", nil)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ RegenerateRecoveryCodes: %s: %v", username, err)
+		renderMFA(c, http.StatusBadRequest, "failed to make new recovery codes: "+err.Error(), nil)
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "mfa_recovery_codes_regenerated", username); err != nil {
+		log.Printf("⚠️ RegenerateRecoveryCodes: failed to log event for %s: %v", username, err)
+	}
+	renderMFA(c, http.StatusOK, "", codes)
+}
+
+// DisableMFA turns TOTP off for the logged-in user after checking their password.
+// It is refused while an admin has made TOTP mandatory.
+func DisableMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := auth.MustPrincipal(c).ID
+	if required, err := auth.MFARequired(ctx); err != nil {
+		log.Printf("⚠️ DisableMFA: %v", err)
+		renderMFA(c, http.StatusInternalServerError, "failed to load two-factor settings", nil)
+		return
+	} else if required {
+		renderMFA(c, http.StatusForbidden, "an admin has made two-factor authentication mandatory", nil)
+		return
+	}
+	switch _, err := auth.Authenticate(ctx, username, c.PostForm("password")); {
+	case err == nil, errors.Is(err, auth.ErrSecondFactorRequired):
+	case errors.Is(err, auth.ErrLocked):
+		renderMFA(c, http.StatusTooManyRequests, err.Error(), nil)
+		return
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		renderMFA(c, http.StatusUnauthorized, "password is wrong", nil)
+		return
+	default:
+		log.Printf("⚠️ DisableMFA: %s: %v", username, err)
+		renderMFA(c, http.StatusInternalServerError, "failed to check password", nil)
+		return
+	}
+	if err := auth.DisableTOTP(ctx, username); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("⚠️ DisableMFA: %s: %v", username, err)
+		renderMFA(c, http.StatusInternalServerError, "failed to turn off two-factor authentication", nil)
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "mfa_disabled", username); err != nil {
+		log.Printf("⚠️ DisableMFA: failed to log event for %s: %v", username, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/mfa")
+}
+
+// SetMFARequirement makes TOTP mandatory or optional for parent and admin accounts.
+func SetMFARequirement(c *gin.Context) {
+	ctx := c.Request.Context()
+	admin := auth.MustPrincipal(c).ID
+	required := c.PostForm("required") == "true"
+	if err := auth.SetMFARequired(ctx, required, admin); err != nil {
+		log.Printf("⚠️ SetMFARequirement: %v", err)
+		renderUsers(c, http.StatusInternalServerError, "failed to save setting")
+		return
+	}
+
+	event := "mfa_requirement_off"
+	if required {
+		event = "mfa_requirement_on"
+	}
+	if err := store.Default.Audit.LogEvent(ctx, event, admin); err != nil {
+		log.Printf("⚠️ SetMFARequirement: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// ResetUserMFA turns TOTP off for an account that lost its authenticator and
+// recovery codes, and ends its sessions. The user enrolls again at the next login
+// if TOTP is mandatory.
+func ResetUserMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := c.Param("username")
+	if err := auth.DisableTOTP(ctx, username); errors.Is(err, store.ErrNotFound) {
+		renderUsers(c, http.StatusNotFound, username+" has no two-factor authentication")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ ResetUserMFA: %s: %v", username, err)
+		renderUsers(c, http.StatusInternalServerError, "failed to reset two-factor authentication")
+		return
+	}
+	if err := auth.EndUserSessions(ctx, username, ""); err != nil {
+		log.Printf("⚠️ ResetUserMFA: failed to end sessions of %s: %v", username, err)
+	}
+
+	admin := auth.MustPrincipal(c).ID
+	if err := store.Default.Audit.LogEvent(ctx, "mfa_reset", admin); err != nil {
+		log.Printf("⚠️ ResetUserMFA: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users")
+}
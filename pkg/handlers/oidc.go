@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+)
+
+// Session keys of an OpenID Connect login waiting for the provider's callback.
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+)
+
+// StartOIDCLogin sends the browser to the identity provider's login page.
+func StartOIDCLogin(c *gin.Context) {
+	if auth.OIDC == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	state, nonce, verifier := auth.NewOIDCLogin()
+	sess := sessions.Default(c)
+	sess.Set(oidcStateKey, state)
+	sess.Set(oidcNonceKey, nonce)
+	sess.Set(oidcVerifierKey, verifier)
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ StartOIDCLogin: failed to save session: %v", err)
+		renderLogin(c, http.StatusInternalServerError, "login failed, try again")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, auth.OIDC.AuthCodeURL(state, nonce, verifier))
+}
+
+// OIDCCallback completes an OpenID Connect login and starts the session, or asks for
+// the second factor of accounts with TOTP on.
+func OIDCCallback(c *gin.Context) {
+	if auth.OIDC == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	sess := sessions.Default(c)
+	state, _ := sess.Get(oidcStateKey).(string)
+	nonce, _ := sess.Get(oidcNonceKey).(string)
+	verifier, _ := sess.Get(oidcVerifierKey).(string)
+	sess.Delete(oidcStateKey) // each attempt is good for one callback
+	sess.Delete(oidcNonceKey)
+	sess.Delete(oidcVerifierKey)
+	if err := sess.Save(); err != nil {
+		// The state is still in the cookie, so the callback could be replayed.
+		log.Printf("⚠️ OIDCCallback: failed to save session: %v", err)
+		renderLogin(c, http.StatusInternalServerError, "login failed, try again")
+		return
+	}
+
+	if e := c.Query("error"); e != "" {
+		renderLogin(c, http.StatusUnauthorized, "sign-in was cancelled or refused ("+e+")")
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		renderLogin(c, http.StatusBadRequest, "login expired, try again")
+		return
+	}
+
+	u, err := auth.OIDC.Login(c.Request.Context(), c.Query("code"), nonce, verifier)
+	switch {
+	case err == nil:
+		startUserSession(c, u)
+	case errors.Is(err, auth.ErrSecondFactorRequired):
+		startPendingMFA(c, u.Username)
+	case errors.Is(err, auth.ErrLocked):
+		renderLogin(c, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, auth.ErrNoAccount), errors.Is(err, auth.ErrUnverifiedEmail):
+		renderLogin(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		renderLogin(c, http.StatusForbidden, "this account cannot log in here")
+	default:
+		log.Printf("⚠️ OIDCCallback: %v", err)
+		renderLogin(c, http.StatusBadGateway, "sign-in failed, try again")
+	}
+}
@@ -1,30 +1,37 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/permitio/permit-golang/pkg/enforcement"
 
 	"github.com/schoolboylurk/data-sentinel/pkg/ai"
 	"github.com/schoolboylurk/data-sentinel/pkg/auth"
-	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/jobs"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/prompts"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
 )
 
 const MaxPromptLength = 1000
 
 // PromptRequest represents a child's prompt submission JSON payload.
 type PromptRequest struct {
-	Username string `json:"username"`
+	Username string `json:"username"` // the kid asking; kids can only ask for themselves
 	Prompt   string `json:"prompt"`
 }
 
 // RequestPromptHandler logs a new prompt request (pending approval).
-// Enforces that the child has permission to create prompt_requests.
+// Enforces that the caller has permission to create prompt_requests.
 func RequestPromptHandler(c *gin.Context) {
 	var req PromptRequest
 	if err := c.BindJSON(&req); err != nil {
@@ -32,106 +39,283 @@ func RequestPromptHandler(c *gin.Context) {
 		return
 	}
 
-	if len(req.Prompt) > MaxPromptLength {
-		c.JSON(http.StatusBadRequest, gin.H{"	error": fmt.Sprintf("Prompt too long (max %d characters)", MaxPromptLength)})
+	p := auth.MustPrincipal(c)
+	kid, ok := kidFor(c, p, req.Username)
+	if !ok {
 		return
 	}
 
-	// Build enforcement objects
-	user := enforcement.UserBuilder(req.Username).Build()
-	resource := enforcement.ResourceBuilder("prompt_requests").Build()
+	id, status, body := submitPromptRequest(c.Request.Context(), p, kid, req.Prompt)
+	if body != nil {
+		c.JSON(status, body)
+		return
+	}
 
-	// Authorization: only children can create prompt requests
-	allowed, err := auth.PermitClient.Check(user, "prompt_requests.create", resource)
+	// Return success to client
+	c.JSON(http.StatusCreated, gin.H{"request_id": id, "status": store.RequestPending})
+}
+
+// AccountRequired refuses kids with 403. The API is for parents, admins and API
+// tokens; kids ask at /child/ask, where their schedule and rate limits apply.
+func AccountRequired(c *gin.Context) {
+	if p, ok := auth.CurrentPrincipal(c); ok && p.IsKid() {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "kids ask at /child/ask"})
+		return
+	}
+	c.Next()
+}
+
+// kidFor resolves which kid a principal acts for: callers must name the kid, and
+// parents one of their own. On failure it responds and returns ok=false.
+func kidFor(c *gin.Context, p auth.Principal, named string) (string, bool) {
+	if named == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username of the kid is required"})
+		return "", false
+	}
+	ok, err := looksAfter(c.Request.Context(), p, named)
 	if err != nil {
+		log.Printf("⚠️ kidFor: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "authorization error"})
-		return
+		return "", false
+	}
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "parents can only act for their own kids"})
+		return "", false
+	}
+	return named, true
+}
+
+// submitPromptRequest checks that p may ask prompt for kid and stores it as a pending
+// request. On failure it returns the status and body to respond with instead.
+func submitPromptRequest(ctx context.Context, p auth.Principal, kid, prompt string) (int64, int, gin.H) {
+	if strings.TrimSpace(prompt) == "" {
+		return 0, http.StatusBadRequest, gin.H{"error": "prompt is required"}
+	}
+	if len(prompt) > MaxPromptLength {
+		return 0, http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Prompt too long (max %d characters)", MaxPromptLength)}
+	}
+
+	// Authorization: only children can create prompt requests
+	allowed, err := p.Can("prompt_requests.create", "prompt_requests")
+	if err != nil {
+		return 0, http.StatusInternalServerError, gin.H{"error": "authorization error"}
 	}
 	if !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
-		return
+		return 0, http.StatusForbidden, gin.H{"error": "permission denied"}
+	}
+	if _, err := store.Default.Kids.Get(ctx, kid); errors.Is(err, store.ErrNotFound) {
+		return 0, http.StatusNotFound, gin.H{"error": "unknown kid"}
+	} else if err != nil {
+		log.Printf("⚠️ RequestPromptHandler: failed to look up kid %s: %v", kid, err)
+		return 0, http.StatusInternalServerError, gin.H{"error": "db lookup failed"}
+	}
+
+	// Reject restricted topics before a parent ever sees the request
+	if status, body := promptPolicyViolation(ctx, kid, prompt, true); body != nil {
+		return 0, status, body
+	}
+	// and requests that could not be answered within the AI budgets
+	if _, status, body := budgetExhausted(ctx, kid); body != nil {
+		return 0, status, body
 	}
 
 	// Insert new request into DB, with error logging
-	res, err := database.DB.Exec(
-		"INSERT INTO prompt_requests(kid_username, prompt, created_at) VALUES(?,?,?)",
-		req.Username, req.Prompt, time.Now(),
-	)
+	id, err := store.Default.PromptRequests.Create(ctx, kid, prompt)
 	if err != nil {
-		log.Printf("⚠️ RequestPromptHandler: failed to insert prompt request for user %s: %v", req.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not save prompt request"})
-		return
+		log.Printf("⚠️ RequestPromptHandler: failed to insert prompt request for user %s: %v", kid, err)
+		return 0, http.StatusInternalServerError, gin.H{"error": "could not save prompt request"}
 	}
-	id, _ := res.LastInsertId()
 
 	// Audit event (also log errors internally if needed)
-	if err := database.LogEvent("prompt_submitted", req.Username); err != nil {
-		log.Printf("⚠️ RequestPromptHandler: failed to log event for user %s: %v", req.Username, err)
+	if err := store.Default.Audit.LogEvent(ctx, "prompt_submitted", kid); err != nil {
+		log.Printf("⚠️ RequestPromptHandler: failed to log event for user %s: %v", kid, err)
 	}
+	return id, http.StatusCreated, nil
+}
 
-	// Return success to client
-	c.JSON(http.StatusCreated, gin.H{"request_id": id, "status": "pending"})
+// Default time a request may wait for a parent, overridable with PROMPT_REQUEST_TTL.
+const defaultRequestTTL = 72 * time.Hour
+
+// RequestTTL returns how long a prompt request may stay pending before it expires.
+func RequestTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("PROMPT_REQUEST_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultRequestTTL
 }
 
-// ApprovePromptHandler allows parents (admins) to approve a child's prompt and generate an AI response.
-func ApprovePromptHandler(c *gin.Context) {
-	admin := c.Query("username")
-	idParam := c.Param("id")
-	id, err := strconv.Atoi(idParam)
+// ExpireStaleRequests expires pending requests older than ttl, checking every
+// interval until ctx is cancelled.
+func ExpireStaleRequests(ctx context.Context, ttl, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := store.Default.PromptRequests.ExpirePending(ctx, time.Now().Add(-ttl))
+		if err != nil {
+			log.Printf("⚠️ ExpireStaleRequests: %v", err)
+		} else if n > 0 {
+			log.Printf("expired %d stale prompt requests", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// beginReview parses the request ID and checks that the caller may decide prompt
+// requests for the kid who asked. On failure it writes the response and returns ok=false.
+func beginReview(c *gin.Context) (reviewer auth.Principal, id int64, ok bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request ID"})
-		return
+		respondReview(c, http.StatusBadRequest, gin.H{"error": "invalid request ID"})
+		return reviewer, 0, false
 	}
 
-	// Build enforcement objects
-	user := enforcement.UserBuilder(admin).Build()
-	resource := enforcement.ResourceBuilder("prompt_requests").Build()
+	ctx := c.Request.Context()
+	pr, err := store.Default.PromptRequests.Get(ctx, id)
+	if err != nil {
+		reviewFailed(c, id, err)
+		return reviewer, 0, false
+	}
 
-	// Authorization: only admins can approve
-	allowed, err := auth.PermitClient.Check(user, "prompt_requests.approve", resource)
+	// Authorization: only the kid's guardians and admins can approve or deny. Parents
+	// get the same 404 for other families' requests as for missing ones.
+	reviewer = auth.MustPrincipal(c)
+	mine, err := looksAfter(ctx, reviewer, pr.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "authorization error"})
-		return
+		log.Printf("⚠️ beginReview: %v", err)
+		respondReview(c, http.StatusInternalServerError, gin.H{"error": "authorization error"})
+		return reviewer, 0, false
+	}
+	if !mine {
+		respondReview(c, http.StatusNotFound, gin.H{"error": "request not found"})
+		return reviewer, 0, false
+	}
+	allowed, err := reviewer.CanOn("prompt_requests.approve", auth.KidResource, pr.Username)
+	if err != nil {
+		respondReview(c, http.StatusInternalServerError, gin.H{"error": "authorization error"})
+		return reviewer, 0, false
 	}
 	if !allowed {
-		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
-		return
+		respondReview(c, http.StatusForbidden, gin.H{"error": "permission denied"})
+		return reviewer, 0, false
+	}
+	return reviewer, id, true
+}
+
+// reviewFailed responds to a rejected state transition.
+func reviewFailed(c *gin.Context, id int64, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		respondReview(c, http.StatusNotFound, gin.H{"error": "request not found"})
+	case errors.Is(err, store.ErrInvalidTransition):
+		respondReview(c, http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("⚠️ review: failed to update prompt_requests id=%d: %v", id, err)
+		respondReview(c, http.StatusInternalServerError, gin.H{"error": "db update failed"})
 	}
+}
 
-	// Mark approved in DB
-	_, err = database.DB.Exec("UPDATE prompt_requests SET approved = TRUE WHERE id = ?", id)
-	if err != nil {
-		log.Printf("⚠️ ApprovePromptHandler: failed to update prompt_requests id=%d: %v", id, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db update failed"})
+// respondReview answers API callers with JSON. Parents using the admin requests
+// page are sent back to it, with the error shown if there was one.
+func respondReview(c *gin.Context, status int, body gin.H) {
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) != gin.MIMEHTML {
+		c.JSON(status, body)
 		return
 	}
+	if msg, ok := body["error"].(string); ok {
+		renderRequests(c, status, msg)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/requests")
+}
 
-	// Fetch the original request
-	var kid, userPrompt string
-	if err := database.DB.QueryRow(
-		"SELECT kid_username, prompt FROM prompt_requests WHERE id = ?", id,
-	).Scan(&kid, &userPrompt); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db lookup failed"})
+// ApprovePromptHandler allows parents (admins) to approve a child's prompt. The answer
+// is generated in the background by the job queue and stored with the request for the
+// kid to read; the response carries the job ID. Only pending requests, or approved
+// ones whose generation failed, can be approved.
+func ApprovePromptHandler(c *gin.Context) {
+	reviewer, id, ok := beginReview(c)
+	if !ok {
 		return
 	}
+	admin := reviewer.ID
 
-	// Build prompt with policy and generate response
-	wrapped := WrapPromptWithPolicy(kid, userPrompt)
-	answer, err := ai.GenerateReport(wrapped)
+	// Mark approved and queue the answer in one go, so no approval is left without a job
+	ctx := c.Request.Context()
+	var jobID int64
+	err := store.Default.InTx(ctx, func(tx store.Repos) error {
+		if err := tx.PromptRequests.Approve(ctx, id, admin, c.DefaultPostForm("reason", c.Query("reason"))); err != nil {
+			return err
+		}
+		var err error
+		jobID, err = tx.Jobs.Enqueue(ctx, id)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI generation failed"})
+		reviewFailed(c, id, err)
 		return
 	}
+	jobs.Default.Notify()
 
 	// Audit event for approval
-	if err := database.LogEvent("prompt_approved", admin); err != nil {
+	if err := store.Default.Audit.LogEvent(ctx, "prompt_approved", admin); err != nil {
 		log.Printf("⚠️ ApprovePromptHandler: failed to log event for admin %s: %v", admin, err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	respondReview(c, http.StatusAccepted, gin.H{
 		"request_id": id,
+		"job_id":     jobID,
+		"status":     store.RequestApproved,
 		"approved":   true,
-		"answer":     answer,
 		"timestamp":  time.Now().Format(time.RFC3339),
 	})
 }
+
+// GenerateRequestAnswer asks the AI provider an approved request's prompt under the
+// kid's system prompt and moderates the answer. It is the generator run by the job queue.
+func GenerateRequestAnswer(ctx context.Context, pr store.PromptRequest) (string, error) {
+	system, err := prompts.SystemMessage(ctx, pr.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", jobs.Permanent(err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load kid policy: %w", err)
+	}
+	// The budget may have run out since the request was made; it stays failed then.
+	var exhausted *quota.ExhaustedError
+	if _, err := quota.Check(ctx, pr.Username); errors.As(err, &exhausted) {
+		return "", jobs.Permanent(err)
+	} else if err != nil {
+		return "", fmt.Errorf("budget check failed: %w", err)
+	}
+	resp, err := ai.GenerateReport(ctx, system, pr.Prompt)
+	quota.Record(ctx, quota.Call{Kid: pr.Username, User: pr.Username, Source: quota.SourceRequest}, resp)
+	if err != nil {
+		return "", fmt.Errorf("AI generation failed: %w", err)
+	}
+	return moderation.Moderate(ctx, moderation.Incident{Kid: pr.Username, Source: "request"}, resp.Content), nil
+}
+
+// DenyPromptHandler lets a parent turn down a pending request, optionally with a reason.
+func DenyPromptHandler(c *gin.Context) {
+	reviewer, id, ok := beginReview(c)
+	if !ok {
+		return
+	}
+	admin := reviewer.ID
+
+	ctx := c.Request.Context()
+	if err := store.Default.PromptRequests.Deny(ctx, id, admin, c.DefaultPostForm("reason", c.Query("reason"))); err != nil {
+		reviewFailed(c, id, err)
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "prompt_denied", admin); err != nil {
+		log.Printf("⚠️ DenyPromptHandler: failed to log event for admin %s: %v", admin, err)
+	}
+
+	respondReview(c, http.StatusOK, gin.H{"request_id": id, "status": store.RequestDenied})
+}
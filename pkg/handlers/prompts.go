@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/prompts"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// maxTemplateLength caps the size of a system prompt template.
+const maxTemplateLength = 8000
+
+// promptBand is an entry of the band list on the prompts page.
+type promptBand struct {
+	ID      int64
+	Name    string
+	Current store.PromptTemplate // version 0 if the band uses a fallback
+}
+
+// promptPage is what the prompts page shows besides the bands and history.
+type promptPage struct {
+	Band     int64
+	Draft    string // the template in the editor
+	Kid      string // previewed for, empty for the sample kid
+	Question string
+	Preview  string
+	Answer   string
+}
+
+// ShowPromptsPage shows the system prompt templates of the age bands and the
+// versions of the one selected by the band query parameter.
+func ShowPromptsPage(c *gin.Context) {
+	band, _ := strconv.ParseInt(c.DefaultQuery("band", "0"), 10, 64)
+	renderPrompts(c, http.StatusOK, "", promptPage{Band: band})
+}
+
+// renderPrompts renders prompts.html with an optional error. An empty draft is
+// replaced by the template in use.
+func renderPrompts(c *gin.Context, status int, errMsg string, page promptPage) {
+	ctx := c.Request.Context()
+	bands := []promptBand{{ID: store.DefaultBand, Name: "Default"}}
+	ageBands, err := store.Default.Policies.ListAgeBands(ctx)
+	for _, b := range ageBands {
+		bands = append(bands, promptBand{ID: b.ID, Name: b.Name + " (" + strconv.Itoa(b.MinAge) + "–" + strconv.Itoa(b.MaxAge) + ")"})
+	}
+	var current []store.PromptTemplate
+	if err == nil {
+		current, err = store.Default.PromptTemplates.Current(ctx)
+	}
+	for _, t := range current {
+		for i := range bands {
+			if bands[i].ID == t.AgeBandID {
+				bands[i].Current = t
+			}
+		}
+	}
+	var history []store.PromptTemplate
+	if err == nil {
+		history, err = store.Default.PromptTemplates.History(ctx, page.Band)
+	}
+	var kids []store.Kid
+	if err == nil {
+		kids, err = store.Default.Kids.List(ctx, store.Scope{})
+	}
+	if err != nil {
+		log.Printf("⚠️ renderPrompts: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load prompt templates"
+	}
+
+	if page.Draft == "" {
+		page.Draft = prompts.Default
+		if len(history) > 0 {
+			page.Draft = history[0].Body
+		}
+	}
+	c.HTML(status, "prompts.html", gin.H{
+		"Bands":     bands,
+		"History":   history,
+		"Kids":      kids,
+		"Page":      page,
+		"error":     errMsg,
+		"csrfToken": csrf.GetToken(c),
+	})
+}
+
+// promptBandParam reads the band parameter: store.DefaultBand or an age band ID.
+// Otherwise it renders the error and returns false.
+func promptBandParam(c *gin.Context) (int64, bool) {
+	band, err := strconv.ParseInt(c.Param("band"), 10, 64)
+	if err == nil && band != store.DefaultBand {
+		_, err = store.Default.Policies.GetAgeBand(c.Request.Context(), band)
+	}
+	switch {
+	case err == nil:
+		return band, true
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, strconv.ErrSyntax), errors.Is(err, strconv.ErrRange):
+		renderPrompts(c, http.StatusNotFound, "age band not found", promptPage{})
+	default:
+		log.Printf("⚠️ promptBandParam: %s: %v", c.Param("band"), err)
+		renderPrompts(c, http.StatusInternalServerError, "failed to load age band", promptPage{})
+	}
+	return 0, false
+}
+
+// templateFromForm reads and checks the template in the body form field.
+func templateFromForm(c *gin.Context) (string, error) {
+	body := strings.TrimSpace(strings.ReplaceAll(c.PostForm("body"), "\r\n", "\n"))
+	if len(body) > maxTemplateLength {
+		return body, errors.New("template is longer than " + strconv.Itoa(maxTemplateLength) + " characters")
+	}
+	return body, prompts.Validate(body)
+}
+
+// saveTemplate adds body as the next version of a band's template and returns to
+// the band on the prompts page.
+func saveTemplate(c *gin.Context, band int64, body, event string) {
+	ctx := c.Request.Context()
+	admin := auth.MustPrincipal(c).ID
+	if _, err := store.Default.PromptTemplates.Add(ctx, band, body, admin); err != nil {
+		log.Printf("⚠️ saveTemplate: band %d: %v", band, err)
+		renderPrompts(c, http.StatusInternalServerError, "failed to save template", promptPage{Band: band, Draft: body})
+		return
+	}
+	if err := store.Default.Audit.LogEvent(ctx, event, admin); err != nil {
+		log.Printf("⚠️ saveTemplate: failed to log event for %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/prompts?band="+strconv.FormatInt(band, 10))
+}
+
+// SavePromptTemplate saves the template in the body form field as a new version of
+// an age band's system prompt. It must render for a sample kid.
+func SavePromptTemplate(c *gin.Context) {
+	band, ok := promptBandParam(c)
+	if !ok {
+		return
+	}
+	body, err := templateFromForm(c)
+	if err != nil {
+		renderPrompts(c, http.StatusBadRequest, err.Error(), promptPage{Band: band, Draft: body})
+		return
+	}
+	saveTemplate(c, band, body, "prompt_template_saved")
+}
+
+// RestorePromptTemplate saves an earlier version of a band's template as its newest.
+func RestorePromptTemplate(c *gin.Context) {
+	band, ok := promptBandParam(c)
+	if !ok {
+		return
+	}
+	version, _ := strconv.Atoi(c.Param("version"))
+	history, err := store.Default.PromptTemplates.History(c.Request.Context(), band)
+	if err != nil {
+		log.Printf("⚠️ RestorePromptTemplate: band %d: %v", band, err)
+		renderPrompts(c, http.StatusInternalServerError, "failed to load template", promptPage{Band: band})
+		return
+	}
+	for _, t := range history {
+		if t.Version == version {
+			saveTemplate(c, band, t.Body, "prompt_template_restored")
+			return
+		}
+	}
+	renderPrompts(c, http.StatusNotFound, "version not found", promptPage{Band: band})
+}
+
+// PreviewPromptTemplate renders the template in the body form field for the kid in
+// the kid field, or for a sample kid, without saving it. With action=test it also
+// asks the AI the question in the question field under that prompt; the call
+// counts towards the household's budget.
+func PreviewPromptTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	band, ok := promptBandParam(c)
+	if !ok {
+		return
+	}
+	page := promptPage{Band: band, Kid: c.PostForm("kid"), Question: strings.TrimSpace(c.PostForm("question"))}
+	var err error
+	if page.Draft, err = templateFromForm(c); err != nil {
+		renderPrompts(c, http.StatusBadRequest, err.Error(), page)
+		return
+	}
+
+	data := prompts.Sample
+	if page.Kid != "" {
+		data, err = prompts.DataFor(ctx, page.Kid)
+		if errors.Is(err, store.ErrNotFound) {
+			renderPrompts(c, http.StatusBadRequest, "kid not found", page)
+			return
+		} else if err != nil {
+			log.Printf("⚠️ PreviewPromptTemplate: %s: %v", page.Kid, err)
+			renderPrompts(c, http.StatusInternalServerError, "failed to load kid", page)
+			return
+		}
+	}
+	if page.Preview, err = prompts.Execute(page.Draft, data); err != nil {
+		renderPrompts(c, http.StatusBadRequest, err.Error(), page)
+		return
+	}
+	if c.PostForm("action") != "test" {
+		renderPrompts(c, http.StatusOK, "", page)
+		return
+	}
+
+	if page.Question == "" {
+		renderPrompts(c, http.StatusBadRequest, "enter a question to test the template with", page)
+		return
+	}
+	var exhausted *quota.ExhaustedError
+	if _, err := quota.Check(ctx, store.Household); errors.As(err, &exhausted) {
+		renderPrompts(c, http.StatusTooManyRequests, exhausted.Error(), page)
+		return
+	} else if err != nil {
+		log.Printf("⚠️ PreviewPromptTemplate: budget check failed: %v", err)
+		renderPrompts(c, http.StatusInternalServerError, "budget check failed", page)
+		return
+	}
+	resp, err := ai.GenerateReport(ctx, page.Preview, page.Question)
+	quota.Record(ctx, quota.Call{User: auth.MustPrincipal(c).ID, Source: quota.SourceTemplateTest}, resp)
+	if err != nil {
+		log.Printf("⚠️ PreviewPromptTemplate: AI generation failed: %v", err)
+		renderPrompts(c, http.StatusBadGateway, "AI generation failed", page)
+		return
+	}
+	page.Answer = resp.Content
+	renderPrompts(c, http.StatusOK, "", page)
+}
@@ -1,20 +1,24 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/permitio/permit-golang/pkg/enforcement"
 
 	"github.com/schoolboylurk/data-sentinel/pkg/ai"
 	"github.com/schoolboylurk/data-sentinel/pkg/auth"
-	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/moderation"
+	"github.com/schoolboylurk/data-sentinel/pkg/prompts"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
 )
 
 // GenerateReportRequest is the JSON payload for direct AI processing by admins or AI-agents.
 type GenerateReportRequest struct {
-	Username string `json:"username"` // the calling user (should have process permission)
+	Username string `json:"username"` // the kid whose policy applies; kids can only ask for themselves
 	Prompt   string `json:"prompt"`   // the raw prompt to wrap and send to AI
 }
 
@@ -26,12 +30,9 @@ func GenerateReportHandler(c *gin.Context) {
 		return
 	}
 
-	// Build enforcement objects
-	user := enforcement.UserBuilder(req.Username).Build()
-	resource := enforcement.ResourceBuilder("prompt_requests").Build()
-
-	// Authorization: must have process permission
-	allowed, err := auth.PermitClient.Check(user, "prompt_requests.process", resource)
+	// Authorization: the caller must have process permission
+	p := auth.MustPrincipal(c)
+	allowed, err := p.Can("prompt_requests.process", "prompt_requests")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "authorization error"})
 		return
@@ -41,18 +42,45 @@ func GenerateReportHandler(c *gin.Context) {
 		return
 	}
 
-	// Wrap prompt with child's policy
-	wrapped := WrapPromptWithPolicy(req.Username, req.Prompt)
+	kid, ok := kidFor(c, p, req.Username)
+	if !ok {
+		return
+	}
+
+	// Pre-flight restricted-topic check
+	if !enforcePromptPolicy(c, kid, req.Prompt, false) {
+		return
+	}
 
-	// Call AI
-	answer, err := ai.GenerateReport(wrapped)
+	// System prompt from the kid's age band and policy
+	ctx := c.Request.Context()
+	system, err := prompts.SystemMessage(ctx, kid)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown kid"})
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ GenerateReportHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load kid policy"})
+		return
+	}
+
+	// Call AI, within the kid's budgets
+	if _, ok := enforceBudget(c, kid); !ok {
+		return
+	}
+	resp, err := ai.GenerateReport(ctx, system, req.Prompt)
+	quota.Record(ctx, quota.Call{Kid: kid, User: p.ID, Source: quota.SourceReport}, resp)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI generation failed"})
 		return
 	}
+	answer := moderation.Moderate(ctx, moderation.Incident{Kid: kid, Source: "report"}, resp.Content)
 
 	// Audit event for processing
-	database.LogEvent("prompt_processed", req.Username)
+	if err := store.Default.Audit.LogEvent(ctx, "prompt_processed", p.ID); err != nil {
+		log.Printf("⚠️ GenerateReportHandler: failed to log event for user %s: %v", p.ID, err)
+	}
 
 	// Return AI's answer
 	c.JSON(http.StatusOK, gin.H{"answer": answer, "processed_at": time.Now().Format(time.RFC3339)})
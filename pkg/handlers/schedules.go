@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/schedule"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// maxExtraMinutes caps the extra time a parent grants at once.
+const maxExtraMinutes = 12 * 60
+
+// WithinSchedule lets kids through only when their schedule allows them to use the
+// AI, counting the request towards their screen time. Otherwise pages show when to
+// come back and other requests get 403 with the reason. Use it after ChildRequired.
+func WithinSchedule(c *gin.Context) {
+	ctx := c.Request.Context()
+	kid := auth.MustPrincipal(c).ID
+	d, err := schedule.Check(ctx, kid)
+	if err != nil {
+		log.Printf("⚠️ WithinSchedule: schedule check failed for %s: %v", kid, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "schedule check failed"})
+		return
+	}
+	if d.Allowed {
+		c.Next()
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "schedule_denied_"+d.Reason, kid); err != nil {
+		log.Printf("⚠️ WithinSchedule: failed to log event for %s: %v", kid, err)
+	}
+	c.Abort()
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		page := gin.H{"ctx": c, "Reason": d.Reason, "csrfToken": csrf.GetToken(c)}
+		if !d.Until.IsZero() {
+			page["UntilTime"] = d.Until.Format("15:04")
+			if schedule.Day(d.Until) != schedule.Day(time.Now().In(d.Until.Location())) {
+				page["UntilDate"] = schedule.Day(d.Until)
+			}
+		}
+		c.HTML(http.StatusForbidden, "child_closed.html", page)
+		return
+	}
+	body := gin.H{"error": "come back later", "reason": d.Reason}
+	if !d.Until.IsZero() {
+		body["until"] = d.Until.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusForbidden, body)
+}
+
+// Heartbeat lets an open chat page count towards the kid's screen time and learn
+// when it is over; WithinSchedule does the work.
+func Heartbeat(c *gin.Context) {
+	c.Status(http.StatusNoContent)
+}
+
+// kidSchedule is a row of the schedules page.
+type kidSchedule struct {
+	Kid       string
+	Schedule  *store.KidSchedule // nil if the kid may use the AI at any time
+	Overrides []store.ScheduleOverride
+	Status    schedule.Decision
+	Minutes   int    // screen time today
+	Today     string // in the kid's time zone
+	Extra     time.Time
+}
+
+// ShowSchedulesPage shows the schedules of the kids in scope and whether each may
+// use the AI right now.
+func ShowSchedulesPage(c *gin.Context) {
+	renderSchedules(c, http.StatusOK, "")
+}
+
+// renderSchedules renders schedules.html with an optional error.
+func renderSchedules(c *gin.Context, status int, errMsg string) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	now := time.Now()
+
+	kids, err := store.Default.Kids.List(ctx, kidScope(p))
+	rows := make([]kidSchedule, len(kids))
+	for i, k := range kids {
+		if err == nil {
+			rows[i], err = loadKidSchedule(ctx, k.Username, now)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️ renderSchedules: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load schedules"
+	}
+
+	c.HTML(status, "schedules.html", gin.H{
+		"Kids":      rows,
+		"error":     errMsg,
+		"csrfToken": csrf.GetToken(c),
+	})
+}
+
+// loadKidSchedule returns kid's row of the schedules page as of now.
+func loadKidSchedule(ctx context.Context, kid string, now time.Time) (kidSchedule, error) {
+	row := kidSchedule{Kid: kid, Status: schedule.Decision{Allowed: true}}
+	r, ok, err := schedule.Load(ctx, kid, now)
+	if err != nil || !ok {
+		return row, err
+	}
+	row.Schedule = &r.Schedule
+	row.Today = schedule.Day(now.In(r.Location()))
+	if row.Overrides, err = store.Default.ScheduleOverrides.For(ctx, kid, row.Today); err != nil {
+		return row, err
+	}
+	used, err := store.Default.ScreenTime.Get(ctx, kid, row.Today)
+	if err != nil {
+		return row, err
+	}
+	row.Status = r.Decide(now, used)
+	if row.Status.ExtraTime {
+		row.Extra = r.Schedule.ExtraUntil.In(r.Location())
+	}
+	row.Minutes = int(used / time.Minute)
+	return row, nil
+}
+
+// scheduleKid returns the kid in the username parameter if the user looks after
+// them. Otherwise it renders the error and returns false.
+func scheduleKid(c *gin.Context) (string, bool) {
+	ctx := c.Request.Context()
+	kid := c.Param("username")
+	ok, err := looksAfter(ctx, auth.MustPrincipal(c), kid)
+	if err == nil && ok {
+		_, err = store.Default.Kids.Get(ctx, kid)
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderSchedules(c, http.StatusNotFound, "kid not found")
+	case err != nil:
+		log.Printf("⚠️ scheduleKid: %s: %v", kid, err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to check guardianship")
+	case !ok:
+		renderSchedules(c, http.StatusForbidden, "you can only manage your own kids")
+	default:
+		return kid, true
+	}
+	return "", false
+}
+
+// KidsWithinSchedule applies WithinSchedule to kids and lets parents through, for
+// pages both may see.
+func KidsWithinSchedule(c *gin.Context) {
+	if p, ok := auth.CurrentPrincipal(c); ok && p.IsKid() {
+		WithinSchedule(c)
+		return
+	}
+	c.Next()
+}
+
+// scheduleChanged audits a change to kid's schedule and returns to the schedules
+// page.
+func scheduleChanged(c *gin.Context, kid, event string) {
+	subject := store.ActorOnKid(auth.MustPrincipal(c).ID, kid)
+	if err := store.Default.Audit.LogEvent(c.Request.Context(), event, subject); err != nil {
+		log.Printf("⚠️ scheduleChanged: failed to log event for %s: %v", subject, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/schedules")
+}
+
+// SaveSchedule sets when a kid may use the AI from the timezone, weekdays,
+// weekends and daily_minutes form fields. Empty windows are the whole day and an
+// empty daily_minutes is no limit.
+func SaveSchedule(c *gin.Context) {
+	kid, ok := scheduleKid(c)
+	if !ok {
+		return
+	}
+	s := store.KidSchedule{KidUsername: kid, Timezone: strings.TrimSpace(c.PostForm("timezone"))}
+	if _, err := schedule.Location(s.Timezone); err != nil {
+		renderSchedules(c, http.StatusBadRequest, "unknown time zone "+strconv.Quote(s.Timezone))
+		return
+	}
+	var err error
+	if s.Weekdays, err = schedule.NormalizeWindows(c.PostForm("weekdays")); err != nil {
+		renderSchedules(c, http.StatusBadRequest, "weekdays: "+err.Error())
+		return
+	}
+	if s.Weekends, err = schedule.NormalizeWindows(c.PostForm("weekends")); err != nil {
+		renderSchedules(c, http.StatusBadRequest, "weekends: "+err.Error())
+		return
+	}
+	if v := strings.TrimSpace(c.PostForm("daily_minutes")); v != "" {
+		if s.DailyMinutes, err = strconv.Atoi(v); err != nil || s.DailyMinutes < 0 || s.DailyMinutes > 24*60 {
+			renderSchedules(c, http.StatusBadRequest, "daily minutes must be a number from 0 to 1440")
+			return
+		}
+	}
+
+	if err := store.Default.Schedules.Save(c.Request.Context(), s, auth.MustPrincipal(c).ID); err != nil {
+		log.Printf("⚠️ SaveSchedule: %s: %v", kid, err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to save schedule")
+		return
+	}
+	scheduleChanged(c, kid, "schedule_updated")
+}
+
+// DeleteSchedule lets a kid use the AI at any time again.
+func DeleteSchedule(c *gin.Context) {
+	kid, ok := scheduleKid(c)
+	if !ok {
+		return
+	}
+	err := store.Default.Schedules.Delete(c.Request.Context(), kid)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderSchedules(c, http.StatusNotFound, "kid has no schedule")
+	case err != nil:
+		log.Printf("⚠️ DeleteSchedule: %s: %v", kid, err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to remove schedule")
+	default:
+		scheduleChanged(c, kid, "schedule_removed")
+	}
+}
+
+// SetScheduleOverride replaces a kid's weekly schedule on the date in the day form
+// field, e.g. for a holiday, with the windows in the windows field.
+func SetScheduleOverride(c *gin.Context) {
+	ctx := c.Request.Context()
+	kid, ok := scheduleKid(c)
+	if !ok {
+		return
+	}
+	o := store.ScheduleOverride{KidUsername: kid, Day: c.PostForm("day"), Note: strings.TrimSpace(c.PostForm("note"))}
+	if _, err := time.Parse(time.DateOnly, o.Day); err != nil {
+		renderSchedules(c, http.StatusBadRequest, "day must be a date like 2025-12-24")
+		return
+	}
+	if len(o.Note) > 100 {
+		renderSchedules(c, http.StatusBadRequest, "note is too long")
+		return
+	}
+	var err error
+	if o.Windows, err = schedule.NormalizeWindows(c.PostForm("windows")); err != nil {
+		renderSchedules(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := store.Default.Schedules.Get(ctx, kid); errors.Is(err, store.ErrNotFound) {
+		renderSchedules(c, http.StatusBadRequest, "set a schedule for "+kid+" first")
+		return
+	} else if err == nil {
+		err = store.Default.ScheduleOverrides.Set(ctx, o, auth.MustPrincipal(c).ID)
+	}
+	if err != nil {
+		log.Printf("⚠️ SetScheduleOverride: %s %s: %v", kid, o.Day, err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to save override")
+		return
+	}
+	scheduleChanged(c, kid, "schedule_override_set")
+}
+
+// DeleteScheduleOverride returns a kid's day to the weekly schedule.
+func DeleteScheduleOverride(c *gin.Context) {
+	kid, ok := scheduleKid(c)
+	if !ok {
+		return
+	}
+	err := store.Default.ScheduleOverrides.Delete(c.Request.Context(), kid, c.Param("day"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderSchedules(c, http.StatusNotFound, "override not found")
+	case err != nil:
+		log.Printf("⚠️ DeleteScheduleOverride: %s %s: %v", kid, c.Param("day"), err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to remove override")
+	default:
+		scheduleChanged(c, kid, "schedule_override_removed")
+	}
+}
+
+// GrantExtraTime lets a kid use the AI for the number of minutes in the minutes
+// form field from now, whatever their schedule says. Zero ends extra time early.
+func GrantExtraTime(c *gin.Context) {
+	kid, ok := scheduleKid(c)
+	if !ok {
+		return
+	}
+	minutes, err := strconv.Atoi(strings.TrimSpace(c.PostForm("minutes")))
+	if err != nil || minutes < 0 || minutes > maxExtraMinutes {
+		renderSchedules(c, http.StatusBadRequest, "minutes must be a number from 0 to "+strconv.Itoa(maxExtraMinutes))
+		return
+	}
+	err = store.Default.Schedules.GrantExtraTime(c.Request.Context(), kid, time.Now().Add(time.Duration(minutes)*time.Minute))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderSchedules(c, http.StatusBadRequest, kid+" has no schedule")
+	case err != nil:
+		log.Printf("⚠️ GrantExtraTime: %s: %v", kid, err)
+		renderSchedules(c, http.StatusInternalServerError, "failed to grant extra time")
+	default:
+		scheduleChanged(c, kid, "schedule_extra_time_granted")
+	}
+}
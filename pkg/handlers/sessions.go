@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Logout ends the current session and returns to the matching login page.
+func Logout(c *gin.Context) {
+	target := "/login"
+	if p, ok := auth.CurrentPrincipal(c); ok {
+		if p.IsKid() {
+			target = "/child/login"
+		}
+		if err := store.Default.Audit.LogEvent(c.Request.Context(), "logout", p.ID); err != nil {
+			log.Printf("⚠️ Logout: failed to log event for %s: %v", p.ID, err)
+		}
+	}
+	// Without an identity the store drops the old session and starts a fresh one.
+	sess := sessions.Default(c)
+	sess.Clear()
+	if err := sess.Save(); err != nil {
+		log.Printf("⚠️ Logout: %v", err)
+	}
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+// ListSessionsPage shows the active sessions the user may end: a parent's own and
+// their kids', or everyone's for admins.
+func ListSessionsPage(c *gin.Context) {
+	renderSessions(c, http.StatusOK, "")
+}
+
+// renderSessions renders sessions.html with an optional error.
+func renderSessions(c *gin.Context, status int, errMsg string) {
+	ctx := c.Request.Context()
+	list, err := store.Default.LoginSessions.List(ctx, kidScope(auth.MustPrincipal(c)))
+	if err != nil {
+		log.Printf("⚠️ renderSessions: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load sessions"
+	}
+	current, _ := store.Default.LoginSessions.GetByHash(ctx, auth.HashToken(sessions.Default(c).ID()))
+	c.HTML(status, "sessions.html", gin.H{
+		"Sessions":  list,
+		"CurrentID": current.ID,
+		"error":     errMsg,
+		"csrfToken": csrf.GetToken(c),
+	})
+}
+
+// RevokeSession forces a logout of one session.
+func RevokeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		renderSessions(c, http.StatusBadRequest, "invalid session ID")
+		return
+	}
+	s, err := store.Default.LoginSessions.Get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		renderSessions(c, http.StatusNotFound, "session not found")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ RevokeSession: id=%d: %v", id, err)
+		renderSessions(c, http.StatusInternalServerError, "failed to load session")
+		return
+	}
+	if ok, err := maySeeSession(ctx, p, s); err != nil {
+		log.Printf("⚠️ RevokeSession: %v", err)
+		renderSessions(c, http.StatusInternalServerError, "failed to check guardianship")
+		return
+	} else if !ok {
+		renderSessions(c, http.StatusNotFound, "session not found")
+		return
+	}
+
+	if err := store.Default.LoginSessions.Delete(ctx, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("⚠️ RevokeSession: id=%d: %v", id, err)
+		renderSessions(c, http.StatusInternalServerError, "failed to end session")
+		return
+	}
+	if err := store.Default.Audit.LogEvent(ctx, "session_revoked", p.ID); err != nil {
+		log.Printf("⚠️ RevokeSession: failed to log event for %s: %v", p.ID, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/sessions")
+}
+
+// maySeeSession reports whether s is p's own session or one of a kid in p's scope.
+// Admins may see every session.
+func maySeeSession(ctx context.Context, p auth.Principal, s store.LoginSession) (bool, error) {
+	if kidScope(p).All() || s.Username == p.ID {
+		return true, nil
+	}
+	if s.KidUsername == "" {
+		return false, nil
+	}
+	return looksAfter(ctx, p, s.KidUsername)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Token lifetimes offered when issuing, in days.
+const (
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+// ListTokensPage shows API tokens and the form to issue one.
+func ListTokensPage(c *gin.Context) {
+	renderTokens(c, http.StatusOK, "", "")
+}
+
+// renderTokens renders tokens.html, with an optional error or a just-issued secret.
+func renderTokens(c *gin.Context, status int, errMsg, secret string) {
+	tokens, err := store.Default.APITokens.List(c.Request.Context())
+	if err != nil {
+		log.Printf("⚠️ renderTokens: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load tokens"
+	}
+	c.HTML(status, "tokens.html", gin.H{
+		"Tokens":      tokens,
+		"Scopes":      auth.TokenScopes,
+		"DefaultDays": defaultTokenDays,
+		"MaxDays":     maxTokenDays,
+		"Now":         time.Now(),
+		"NewToken":    secret,
+		"error":       errMsg,
+		"csrfToken":   csrf.GetToken(c),
+	})
+}
+
+// CreateToken issues an API token and shows its secret once.
+func CreateToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	name, username := c.PostForm("name"), c.PostForm("username")
+	if name == "" || username == "" {
+		renderTokens(c, http.StatusBadRequest, "name and username are required", "")
+		return
+	}
+	if _, err := store.Default.Users.Get(ctx, username); errors.Is(err, store.ErrNotFound) {
+		renderTokens(c, http.StatusBadRequest, "tokens must act as an existing account", "")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ CreateToken: %v", err)
+		renderTokens(c, http.StatusInternalServerError, "failed to look up account", "")
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultPostForm("expires_days", strconv.Itoa(defaultTokenDays)))
+	if err != nil || days < 1 || days > maxTokenDays {
+		renderTokens(c, http.StatusBadRequest, fmt.Sprintf("expiry must be between 1 and %d days", maxTokenDays), "")
+		return
+	}
+
+	admin := auth.MustPrincipal(c).ID
+	secret, err := auth.IssueToken(ctx, store.APIToken{
+		Name:      name,
+		Username:  username,
+		Scopes:    c.PostFormArray("scopes"),
+		CreatedBy: admin,
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+	})
+	if err != nil {
+		log.Printf("⚠️ CreateToken: %v", err)
+		renderTokens(c, http.StatusBadRequest, "failed to issue token: "+err.Error(), "")
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "api_token_created", admin); err != nil {
+		log.Printf("⚠️ CreateToken: failed to log event for admin %s: %v", admin, err)
+	}
+	renderTokens(c, http.StatusCreated, "", secret)
+}
+
+// RevokeToken revokes an API token immediately.
+func RevokeToken(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		renderTokens(c, http.StatusBadRequest, "invalid token ID", "")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := store.Default.APITokens.Revoke(ctx, id); errors.Is(err, store.ErrNotFound) {
+		renderTokens(c, http.StatusNotFound, "token not found or already revoked", "")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ RevokeToken: id=%d: %v", id, err)
+		renderTokens(c, http.StatusInternalServerError, "failed to revoke token", "")
+		return
+	}
+
+	admin := auth.MustPrincipal(c).ID
+	if err := store.Default.Audit.LogEvent(ctx, "api_token_revoked", admin); err != nil {
+		log.Printf("⚠️ RevokeToken: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/tokens")
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// recentUsageCalls is how many provider calls the usage page lists.
+const recentUsageCalls = 50
+
+// kidUsage is a row of the usage page.
+type kidUsage struct {
+	Kid              string
+	Day, Week, Month store.UsageTotals
+	Budgets          []quota.BudgetStatus
+}
+
+// ShowUsagePage shows the AI usage and budgets of the kids in scope and of the
+// household.
+func ShowUsagePage(c *gin.Context) {
+	renderUsage(c, http.StatusOK, "")
+}
+
+// renderUsage renders usage.html with an optional error.
+func renderUsage(c *gin.Context, status int, errMsg string) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	scope := kidScope(p)
+	now := time.Now()
+
+	kids, err := store.Default.Kids.List(ctx, scope)
+	var totals [3]map[string]store.UsageTotals
+	var household [3]store.UsageTotals
+	for i, period := range []string{store.PeriodDay, store.PeriodWeek, store.PeriodMonth} {
+		since := quota.PeriodStart(period, now)
+		if err == nil {
+			totals[i], err = store.Default.Usage.TotalsByKid(ctx, scope, since)
+		}
+		if err == nil {
+			household[i], err = store.Default.Usage.Totals(ctx, store.Household, since)
+		}
+	}
+	rows := make([]kidUsage, len(kids))
+	for i, k := range kids {
+		rows[i] = kidUsage{Kid: k.Username, Day: totals[0][k.Username], Week: totals[1][k.Username], Month: totals[2][k.Username]}
+		if err == nil {
+			rows[i].Budgets, err = quota.Status(ctx, k.Username)
+		}
+	}
+	var householdBudgets []quota.BudgetStatus
+	if err == nil {
+		householdBudgets, err = quota.Status(ctx, store.Household)
+	}
+	var recent []store.UsageRecord
+	if err == nil {
+		recent, err = store.Default.Usage.Recent(ctx, scope, recentUsageCalls)
+	}
+	if err != nil {
+		log.Printf("⚠️ renderUsage: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load usage"
+	}
+
+	c.HTML(status, "usage.html", gin.H{
+		"Kids":             rows,
+		"Household":        household,
+		"HouseholdBudgets": householdBudgets,
+		"Recent":           recent,
+		"IsAdmin":          p.Role == auth.RoleAdmin,
+		"WarnPercent":      quota.DefaultWarnPercent,
+		"error":            errMsg,
+		"csrfToken":        csrf.GetToken(c),
+	})
+}
+
+// budgetFromForm reads a budget from the period, max_tokens, max_messages and
+// warn_percent form fields. Empty maximums are no limit; an empty warn_percent is
+// quota.DefaultWarnPercent.
+func budgetFromForm(c *gin.Context, kid string) (store.UsageBudget, error) {
+	b := store.UsageBudget{KidUsername: kid, Period: c.PostForm("period"), WarnPercent: quota.DefaultWarnPercent}
+	if !quota.ValidPeriod(b.Period) {
+		return b, errors.New("period must be day, week or month")
+	}
+	number := func(field string, max int64) (int64, error) {
+		v := strings.TrimSpace(c.PostForm(field))
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 || n > max {
+			return 0, errors.New(strings.ReplaceAll(field, "_", " ") + " must be a number from 0 to " + strconv.FormatInt(max, 10))
+		}
+		return n, nil
+	}
+	var err error
+	if b.MaxTokens, err = number("max_tokens", 1<<53); err != nil {
+		return b, err
+	}
+	if b.MaxMessages, err = number("max_messages", 1<<31); err != nil {
+		return b, err
+	}
+	if c.PostForm("warn_percent") != "" {
+		warn, err := number("warn_percent", 100)
+		if err != nil {
+			return b, err
+		}
+		b.WarnPercent = int(warn)
+	}
+	return b, nil
+}
+
+// saveBudget stores the budget in the form for kid, or removes it if it has no
+// limits, and returns to the usage page.
+func saveBudget(c *gin.Context, kid string) {
+	ctx := c.Request.Context()
+	p := auth.MustPrincipal(c)
+	b, err := budgetFromForm(c, kid)
+	if err != nil {
+		renderUsage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	event := "usage_budget_set"
+	if b.MaxTokens == 0 && b.MaxMessages == 0 {
+		event = "usage_budget_removed"
+		err = store.Default.UsageBudgets.Delete(ctx, kid, b.Period)
+		if errors.Is(err, store.ErrNotFound) {
+			err = nil
+		}
+	} else {
+		err = store.Default.UsageBudgets.Set(ctx, b, p.ID)
+	}
+	if err != nil {
+		log.Printf("⚠️ saveBudget: %q %s: %v", kid, b.Period, err)
+		renderUsage(c, http.StatusInternalServerError, "failed to save budget")
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, event, p.ID); err != nil {
+		log.Printf("⚠️ saveBudget: failed to log event for %s: %v", p.ID, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/usage")
+}
+
+// SetKidBudget sets one of a kid's AI budgets. Parents may set their own kids'.
+func SetKidBudget(c *gin.Context) {
+	ctx := c.Request.Context()
+	kid := c.Param("username")
+	ok, err := looksAfter(ctx, auth.MustPrincipal(c), kid)
+	if err == nil && ok {
+		_, err = store.Default.Kids.Get(ctx, kid)
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderUsage(c, http.StatusNotFound, "kid not found")
+	case err != nil:
+		log.Printf("⚠️ SetKidBudget: %s: %v", kid, err)
+		renderUsage(c, http.StatusInternalServerError, "failed to check guardianship")
+	case !ok:
+		renderUsage(c, http.StatusForbidden, "you can only manage your own kids")
+	default:
+		saveBudget(c, kid)
+	}
+}
+
+// SetHouseholdBudget sets one of the household's AI budgets, which cap everyone's
+// usage together.
+func SetHouseholdBudget(c *gin.Context) {
+	saveBudget(c, store.Household)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	csrf "github.com/utrack/gin-csrf"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// ListUsersPage shows parent, admin and ai-agent accounts and the form to add one.
+func ListUsersPage(c *gin.Context) {
+	renderUsers(c, http.StatusOK, "")
+}
+
+// renderUsers renders users.html with an optional error.
+func renderUsers(c *gin.Context, status int, errMsg string) {
+	ctx := c.Request.Context()
+	users, err := store.Default.Users.List(ctx)
+	var mfa map[string]bool
+	if err == nil {
+		mfa, err = store.Default.TOTP.Enabled(ctx)
+	}
+	var mfaRequired bool
+	if err == nil {
+		mfaRequired, err = auth.MFARequired(ctx)
+	}
+	if err != nil {
+		log.Printf("⚠️ renderUsers: %v", err)
+		status, errMsg = http.StatusInternalServerError, "failed to load accounts"
+	}
+	c.HTML(status, "users.html", gin.H{
+		"Users":       users,
+		"MFA":         mfa,
+		"MFARequired": mfaRequired,
+		"Roles":       auth.Roles,
+		"MinPassword": auth.MinPasswordLength,
+		"Now":         time.Now(),
+		"error":       errMsg,
+		"csrfToken":   csrf.GetToken(c),
+	})
+}
+
+// AddUser creates an account. ai-agent accounts may be created without a password.
+// The optional email links the account to an OpenID Connect login.
+func AddUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := c.PostForm("username")
+	admin := auth.MustPrincipal(c).ID
+
+	err := auth.CreateUser(ctx, username, c.PostForm("email"), c.PostForm("password"), c.PostForm("role"), admin)
+	if errors.Is(err, store.ErrDuplicate) {
+		renderUsers(c, http.StatusConflict, "username already exists")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ AddUser: %s: %v", username, err)
+		renderUsers(c, http.StatusBadRequest, "failed to create account: "+err.Error())
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "account_created", admin); err != nil {
+		log.Printf("⚠️ AddUser: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// UnlockUser lifts a login lockout.
+func UnlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := c.Param("username")
+	if err := store.Default.Users.Unlock(ctx, username); errors.Is(err, store.ErrNotFound) {
+		renderUsers(c, http.StatusNotFound, "account not found")
+		return
+	} else if err != nil {
+		log.Printf("⚠️ UnlockUser: %s: %v", username, err)
+		renderUsers(c, http.StatusInternalServerError, "failed to unlock account")
+		return
+	}
+
+	admin := auth.MustPrincipal(c).ID
+	if err := store.Default.Audit.LogEvent(ctx, "account_unlocked", admin); err != nil {
+		log.Printf("⚠️ UnlockUser: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// ResetUserPassword sets another account's password, e.g. when a parent forgot theirs.
+func ResetUserPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := c.Param("username")
+	err := auth.SetPassword(ctx, username, c.PostForm("password"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		renderUsers(c, http.StatusNotFound, "account not found")
+		return
+	case errors.Is(err, auth.ErrWeakPassword):
+		renderUsers(c, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("⚠️ ResetUserPassword: %s: %v", username, err)
+		renderUsers(c, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	admin := auth.MustPrincipal(c).ID
+	if err := store.Default.Audit.LogEvent(ctx, "password_reset", admin); err != nil {
+		log.Printf("⚠️ ResetUserPassword: failed to log event for admin %s: %v", admin, err)
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// ShowPasswordPage renders the form to change one's own password.
+func ShowPasswordPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "password.html", gin.H{
+		"MinPassword": auth.MinPasswordLength,
+		"csrfToken":   csrf.GetToken(c),
+	})
+}
+
+// ChangePassword changes the logged-in user's password after checking the current one.
+func ChangePassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	username := auth.MustPrincipal(c).ID
+	render := func(status int, key, msg string) {
+		c.HTML(status, "password.html", gin.H{
+			key:           msg,
+			"MinPassword": auth.MinPasswordLength,
+			"csrfToken":   csrf.GetToken(c),
+		})
+	}
+
+	next := c.PostForm("new_password")
+	if next != c.PostForm("confirm_password") {
+		render(http.StatusBadRequest, "error", "new passwords do not match")
+		return
+	}
+	err := auth.ChangePassword(ctx, username, c.PostForm("current_password"), next, sessions.Default(c).ID())
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		render(http.StatusUnauthorized, "error", "current password is wrong")
+		return
+	case errors.Is(err, auth.ErrLocked):
+		render(http.StatusTooManyRequests, "error", err.Error())
+		return
+	case errors.Is(err, auth.ErrWeakPassword):
+		render(http.StatusBadRequest, "error", err.Error())
+		return
+	case err != nil:
+		log.Printf("⚠️ ChangePassword: %s: %v", username, err)
+		render(http.StatusInternalServerError, "error", "failed to change password")
+		return
+	}
+
+	if err := store.Default.Audit.LogEvent(ctx, "password_changed", username); err != nil {
+		log.Printf("⚠️ ChangePassword: failed to log event for %s: %v", username, err)
+	}
+	render(http.StatusOK, "message", "Password changed.")
+}
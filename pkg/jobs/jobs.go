@@ -0,0 +1,276 @@
+// Package jobs generates answers for approved prompt requests in the background,
+// so a slow or failing AI provider never holds up a parent's approval. Jobs live
+// in the ai_jobs table and survive restarts. A worker holds a lease on the job it
+// runs and keeps renewing it; jobs whose lease runs out, because their process died,
+// are picked up again by any replica.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Generator produces the answer for an approved prompt request.
+type Generator func(ctx context.Context, pr store.PromptRequest) (string, error)
+
+// Permanent marks err as a failure that retrying cannot fix, such as an unknown kid.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Queue works off ai_jobs with a fixed pool of workers.
+type Queue struct {
+	Repos       store.Repos
+	Generate    Generator
+	Workers     int
+	MaxAttempts int           // attempts before a request is marked failed
+	Backoff     time.Duration // delay before the first retry, doubled after each further attempt
+	MaxBackoff  time.Duration
+	Poll        time.Duration // how often idle workers look for jobs that became due
+	Lease       time.Duration // how long a job stays claimed without renewal
+
+	wake  chan struct{}
+	stop  context.CancelFunc // stops workers from claiming new jobs
+	abort context.CancelFunc // cancels jobs still running when shutdown runs out of time
+	wg    sync.WaitGroup
+}
+
+// Default is the queue started by main.
+var Default *Queue
+
+// Init builds Default. AI_WORKERS, AI_JOB_MAX_ATTEMPTS, AI_JOB_BACKOFF and
+// AI_JOB_LEASE override the number of workers, attempts per job, the first retry
+// delay and the job lease.
+func Init(repos store.Repos, gen Generator) error {
+	q := &Queue{
+		Repos:       repos,
+		Generate:    gen,
+		Workers:     2,
+		MaxAttempts: 5,
+		Backoff:     10 * time.Second,
+		MaxBackoff:  10 * time.Minute,
+		Poll:        time.Second,
+		Lease:       2 * time.Minute,
+	}
+	if v := os.Getenv("AI_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("AI_WORKERS: want a positive number, got %q", v)
+		}
+		q.Workers = n
+	}
+	if v := os.Getenv("AI_JOB_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("AI_JOB_MAX_ATTEMPTS: want a positive number, got %q", v)
+		}
+		q.MaxAttempts = n
+	}
+	if v := os.Getenv("AI_JOB_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("AI_JOB_BACKOFF: want a positive duration, got %q", v)
+		}
+		q.Backoff = d
+	}
+	if v := os.Getenv("AI_JOB_LEASE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 10*time.Second {
+			return fmt.Errorf("AI_JOB_LEASE: want a duration of at least 10s, got %q", v)
+		}
+		q.Lease = d
+	}
+	Default = q
+	return nil
+}
+
+// Start starts the workers. Jobs a dead process left running are picked up once
+// their lease has run out.
+func (q *Queue) Start(ctx context.Context) error {
+	q.wake = make(chan struct{}, 1)
+	stopCtx, stop := context.WithCancel(context.Background())
+	runCtx, abort := context.WithCancel(context.Background())
+	q.stop, q.abort = stop, abort
+	for i := 0; i < q.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(stopCtx, runCtx)
+	}
+	return nil
+}
+
+// Notify wakes an idle worker after a job was enqueued. It is safe to call on a
+// nil or stopped queue.
+func (q *Queue) Notify() {
+	if q == nil || q.wake == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown stops claiming jobs and waits for running ones to finish. If ctx ends
+// first, running jobs are cancelled and put back in the queue for the next start.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	if q.stop == nil {
+		return nil
+	}
+	q.stop()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.abort()
+		return nil
+	case <-ctx.Done():
+		q.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work(stopCtx, runCtx context.Context) {
+	defer q.wg.Done()
+	for stopCtx.Err() == nil {
+		job, err := q.Repos.Jobs.Claim(runCtx, q.Lease)
+		if err == nil {
+			q.run(runCtx, job)
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("⚠️ jobs: claim failed: %v", err)
+		}
+		select {
+		case <-stopCtx.Done():
+		case <-q.wake:
+		case <-time.After(q.Poll):
+		}
+	}
+}
+
+// run generates the answer for one claimed job and records the outcome.
+func (q *Queue) run(ctx context.Context, job store.Job) {
+	// Bookkeeping must land even when shutdown cancels the generation.
+	dbCtx := context.WithoutCancel(ctx)
+
+	ctx, lost := context.WithCancel(ctx)
+	defer lost()
+	go q.renew(ctx, job, lost)
+
+	pr, err := q.Repos.PromptRequests.Get(dbCtx, job.RequestID)
+	if err != nil {
+		q.failed(dbCtx, job, Permanent(err))
+		return
+	}
+	if pr.Status != store.RequestApproved {
+		// e.g. a duplicate job for a request that has been answered since
+		if err := q.Repos.Jobs.Fail(dbCtx, job, "request is "+pr.Status); err != nil {
+			log.Printf("⚠️ jobs: failed to close job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	answer, err := q.Generate(ctx, pr)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutdown, or the lease was lost and the job is someone else's now
+			if err := q.Repos.Jobs.Release(dbCtx, job); err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("⚠️ jobs: failed to release job %d: %v", job.ID, err)
+			}
+			return
+		}
+		q.failed(dbCtx, job, err)
+		return
+	}
+
+	err = q.Repos.InTx(dbCtx, func(tx store.Repos) error {
+		if err := tx.PromptRequests.Answer(dbCtx, pr.ID, answer); err != nil {
+			return err
+		}
+		return tx.Jobs.Complete(dbCtx, job)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		// the lease ran out and the job was claimed again
+		log.Printf("⚠️ jobs: job %d for request %d is no longer ours, dropping its answer", job.ID, pr.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ jobs: failed to store answer for request %d: %v", pr.ID, err)
+		q.failed(dbCtx, job, Permanent(err))
+	}
+}
+
+// renew keeps extending the lease on job until ctx ends. If the lease is lost it
+// calls lost, which cancels the generation.
+func (q *Queue) renew(ctx context.Context, job store.Job, lost context.CancelFunc) {
+	t := time.NewTicker(q.Lease / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		err := q.Repos.Jobs.Renew(context.WithoutCancel(ctx), job, q.Lease)
+		if errors.Is(err, store.ErrNotFound) {
+			log.Printf("⚠️ jobs: lost the lease on job %d for request %d", job.ID, job.RequestID)
+			lost()
+			return
+		} else if err != nil {
+			log.Printf("⚠️ jobs: failed to renew the lease on job %d: %v", job.ID, err)
+		}
+	}
+}
+
+// failed schedules another attempt, or gives up and marks the request failed once
+// the job is out of attempts or err is permanent.
+func (q *Queue) failed(ctx context.Context, job store.Job, err error) {
+	var perm permanentError
+	if !errors.As(err, &perm) && job.Attempts < q.MaxAttempts {
+		delay := q.backoff(job.Attempts)
+		log.Printf("⚠️ jobs: job %d for request %d failed (attempt %d/%d), retrying in %s: %v",
+			job.ID, job.RequestID, job.Attempts, q.MaxAttempts, delay, err)
+		if err := q.Repos.Jobs.Retry(ctx, job, err.Error(), time.Now().Add(delay)); err != nil {
+			log.Printf("⚠️ jobs: failed to requeue job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("⚠️ jobs: job %d for request %d failed after %d attempts: %v", job.ID, job.RequestID, job.Attempts, err)
+	reason := err.Error()
+	if err := q.Repos.InTx(ctx, func(tx store.Repos) error {
+		if err := tx.PromptRequests.Fail(ctx, job.RequestID, reason); err != nil &&
+			!errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrInvalidTransition) {
+			return err
+		}
+		return tx.Jobs.Fail(ctx, job, reason)
+	}); err != nil {
+		log.Printf("⚠️ jobs: failed to mark job %d failed: %v", job.ID, err)
+	}
+}
+
+// backoff is the delay before the attempt following the given one.
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.Backoff
+	for i := 1; i < attempt && d < q.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.MaxBackoff)
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit allows Requests per Window. Burst, used by the token bucket, is how many
+// requests may come at once after a quiet period; it defaults to Requests. A limit
+// with Requests <= 0 lets everything through.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+	Burst    int
+}
+
+// Unlimited reports whether the limit lets everything through.
+func (l Limit) Unlimited() bool { return l.Requests <= 0 || l.Window <= 0 }
+
+// Result is the outcome of one request against a limit.
+type Result struct {
+	Allowed    bool
+	Limit      int           // requests allowed per window, or the burst
+	Remaining  int           // requests left right now
+	Reset      time.Duration // until the full quota is back
+	RetryAfter time.Duration // until the next request is allowed, when refused
+}
+
+// Limiter decides whether a request in the named bucket may go ahead.
+type Limiter interface {
+	Allow(ctx context.Context, bucket string, l Limit) (Result, error)
+}
+
+// State is what an Algorithm keeps per bucket. Its fields mean what the algorithm
+// makes them mean; the zero State is a bucket that has seen no requests.
+type State struct {
+	Value float64
+	Prev  float64
+	Stamp time.Time
+}
+
+// Algorithm counts a request against the bucket state s at now, updating s.
+type Algorithm interface {
+	Take(s *State, l Limit, now time.Time) Result
+	// TTL is how long an untouched state takes to return to the zero State.
+	TTL(l Limit) time.Duration
+}
+
+// Store keeps bucket states. Update must run fn atomically for the bucket, also
+// across replicas for shared stores, and may forget the state after ttl.
+type Store interface {
+	Update(ctx context.Context, bucket string, ttl time.Duration, fn func(s *State)) error
+}
+
+// NewLimiter returns a Limiter that applies alg to state kept in st.
+func NewLimiter(alg Algorithm, st Store) Limiter {
+	return &limiter{alg: alg, store: st}
+}
+
+type limiter struct {
+	alg   Algorithm
+	store Store
+}
+
+func (l *limiter) Allow(ctx context.Context, bucket string, lim Limit) (Result, error) {
+	if lim.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	var res Result
+	err := l.store.Update(ctx, bucket, l.alg.TTL(lim), func(s *State) {
+		res = l.alg.Take(s, lim, time.Now())
+	})
+	return res, err
+}
+
+// TokenBucket refills Requests tokens per Window up to Burst; each request takes
+// one. State: Value is the tokens left, Stamp the last refill.
+type TokenBucket struct{}
+
+func (TokenBucket) capacity(l Limit) float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// Take implements Algorithm.
+func (b TokenBucket) Take(s *State, l Limit, now time.Time) Result {
+	capacity := b.capacity(l)
+	perSecond := float64(l.Requests) / l.Window.Seconds()
+	tokens := capacity
+	if !s.Stamp.IsZero() {
+		tokens = math.Min(capacity, s.Value+now.Sub(s.Stamp).Seconds()*perSecond)
+	}
+	res := Result{Limit: int(capacity)}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+	s.Value, s.Stamp = tokens, now
+	res.Remaining = int(tokens)
+	res.Reset = seconds((capacity - tokens) / perSecond)
+	return res
+}
+
+// TTL implements Algorithm: the time to refill an empty bucket.
+func (b TokenBucket) TTL(l Limit) time.Duration {
+	return seconds(b.capacity(l) / (float64(l.Requests) / l.Window.Seconds()))
+}
+
+// SlidingWindow allows Requests in any Window, estimating the requests in the
+// sliding window from the counts of the current and previous fixed windows.
+// State: Value counts the current window, Prev the previous one, and Stamp is
+// the start of the current window.
+type SlidingWindow struct{}
+
+// Take implements Algorithm.
+func (SlidingWindow) Take(s *State, l Limit, now time.Time) Result {
+	start := now.Truncate(l.Window)
+	switch {
+	case s.Stamp.Equal(start):
+	case s.Stamp.Equal(start.Add(-l.Window)):
+		s.Prev, s.Value, s.Stamp = s.Value, 0, start
+	default:
+		s.Prev, s.Value, s.Stamp = 0, 0, start
+	}
+
+	limit := float64(l.Requests)
+	elapsed := now.Sub(start).Seconds() / l.Window.Seconds()
+	estimate := s.Prev*(1-elapsed) + s.Value
+	res := Result{Limit: l.Requests, Reset: start.Add(2 * l.Window).Sub(now)}
+	if estimate+1 <= limit {
+		s.Value++
+		res.Allowed = true
+		res.Remaining = int(limit - estimate - 1)
+		return res
+	}
+
+	// Wait for the previous window's share to drop far enough, or else for the
+	// current window to become the previous one and do the same.
+	if s.Prev > 0 && s.Value <= limit-1 {
+		at := start.Add(seconds(l.Window.Seconds() * (1 - (limit-1-s.Value)/s.Prev)))
+		res.RetryAfter = at.Sub(now)
+	} else {
+		at := start.Add(l.Window + seconds(l.Window.Seconds()*math.Max(0, 1-(limit-1)/s.Value)))
+		res.RetryAfter = at.Sub(now)
+	}
+	return res
+}
+
+// TTL implements Algorithm: counts older than the previous window no longer matter.
+func (SlidingWindow) TTL(l Limit) time.Duration { return 2 * l.Window }
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
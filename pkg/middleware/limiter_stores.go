@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"hash/maphash"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// memoryShards spreads buckets over this many locks, so that requests in
+// different buckets rarely wait for each other.
+const memoryShards = 32
+
+// memorySweepInterval is how often a shard drops expired buckets.
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps bucket states in process memory. Each replica counts on its
+// own; use SQLStore to share limits between replicas.
+type MemoryStore struct {
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+}
+
+type memoryShard struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	state   State
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].buckets = map[string]memoryBucket{}
+	}
+	return m
+}
+
+// Update implements Store.
+func (m *MemoryStore) Update(_ context.Context, bucket string, ttl time.Duration, fn func(s *State)) error {
+	sh := &m.shards[maphash.String(m.seed, bucket)%memoryShards]
+	now := time.Now()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if now.Sub(sh.lastSweep) > memorySweepInterval {
+		for k, b := range sh.buckets {
+			if !now.Before(b.expires) {
+				delete(sh.buckets, k)
+			}
+		}
+		sh.lastSweep = now
+	}
+	b := sh.buckets[bucket]
+	if !now.Before(b.expires) {
+		b.state = State{}
+	}
+	fn(&b.state)
+	b.expires = now.Add(ttl)
+	sh.buckets[bucket] = b
+	return nil
+}
+
+// SQLStore keeps bucket states in the rate_limits table, so that every replica
+// sharing the database enforces the same limits.
+type SQLStore struct{}
+
+// Update implements Store. The bucket's row stays locked while fn runs.
+func (SQLStore) Update(ctx context.Context, bucket string, ttl time.Duration, fn func(s *State)) error {
+	return store.Default.InTx(ctx, func(tx store.Repos) error {
+		row, err := tx.RateLimits.Lock(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		s := State{Value: row.Value, Prev: row.Prev}
+		if row.Stamp != 0 {
+			s.Stamp = time.Unix(0, row.Stamp)
+		}
+		fn(&s)
+		row = store.RateLimitState{Value: s.Value, Prev: s.Prev}
+		if !s.Stamp.IsZero() {
+			row.Stamp = s.Stamp.UnixNano()
+		}
+		return tx.RateLimits.Save(ctx, bucket, row, time.Now().Add(ttl))
+	})
+}
+
+// PurgeExpired deletes expired rows from rate_limits every interval until ctx ends.
+func (SQLStore) PurgeExpired(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.Default.RateLimits.DeleteExpired(ctx); err != nil {
+				log.Printf("⚠️ SQLStore.PurgeExpired: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// step is one request against a limit, at an offset from the test's start.
+type step struct {
+	at         time.Duration
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func runSteps(t *testing.T, alg Algorithm, l Limit, steps []step) {
+	t.Helper()
+	start := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC) // on a window boundary
+	var s State
+	for i, st := range steps {
+		res := alg.Take(&s, l, start.Add(st.at))
+		got := step{st.at, res.Allowed, res.Remaining, res.Reset.Round(time.Millisecond), res.RetryAfter.Round(time.Millisecond)}
+		if got != st {
+			t.Errorf("request %d at %v = %+v, want %+v", i, st.at, got, st)
+		}
+	}
+}
+
+func TestTokenBucketTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "refills over the window",
+			limit: Limit{Requests: 2, Window: time.Minute},
+			steps: []step{
+				{at: 0, allowed: true, remaining: 1, reset: 30 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: time.Minute},
+				{at: 0, allowed: false, remaining: 0, reset: time.Minute, retryAfter: 30 * time.Second},
+				{at: 15 * time.Second, allowed: false, remaining: 0, reset: 45 * time.Second, retryAfter: 15 * time.Second},
+				{at: 30 * time.Second, allowed: true, remaining: 0, reset: time.Minute},
+				{at: 5 * time.Minute, allowed: true, remaining: 1, reset: 30 * time.Second},
+			},
+		},
+		{
+			name:  "burst",
+			limit: Limit{Requests: 1, Window: time.Second, Burst: 3},
+			steps: []step{
+				{at: 0, allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 0, allowed: false, remaining: 0, reset: 3 * time.Second, retryAfter: time.Second},
+				{at: 1500 * time.Millisecond, allowed: true, remaining: 0, reset: 2500 * time.Millisecond},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { runSteps(t, TokenBucket{}, tt.limit, tt.steps) })
+	}
+}
+
+func TestSlidingWindowTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "within one window",
+			limit: Limit{Requests: 2, Window: time.Minute},
+			steps: []step{
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Minute},
+				{at: 10 * time.Second, allowed: true, remaining: 0, reset: 110 * time.Second},
+				// the previous window is empty: wait until this one has slid halfway out
+				{at: 20 * time.Second, allowed: false, remaining: 0, reset: 100 * time.Second, retryAfter: 70 * time.Second},
+			},
+		},
+		{
+			name:  "rolls over into the next window",
+			limit: Limit{Requests: 2, Window: time.Minute},
+			steps: []step{
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Minute},
+				{at: 10 * time.Second, allowed: true, remaining: 0, reset: 110 * time.Second},
+				{at: 90 * time.Second, allowed: true, remaining: 0, reset: 90 * time.Second},
+				// two in the previous window weigh 2*(1-35/60) on top of this one's
+				{at: 95 * time.Second, allowed: false, remaining: 0, reset: 85 * time.Second, retryAfter: 25 * time.Second},
+				{at: 120 * time.Second, allowed: true, remaining: 0, reset: 2 * time.Minute},
+			},
+		},
+		{
+			name:  "forgets windows long past",
+			limit: Limit{Requests: 1, Window: time.Minute},
+			steps: []step{
+				{at: 0, allowed: true, remaining: 0, reset: 2 * time.Minute},
+				// with a limit of one, the request must slide out of the window entirely
+				{at: 30 * time.Second, allowed: false, remaining: 0, reset: 90 * time.Second, retryAfter: 90 * time.Second},
+				{at: 5 * time.Minute, allowed: true, remaining: 0, reset: 2 * time.Minute},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { runSteps(t, SlidingWindow{}, tt.limit, tt.steps) })
+	}
+}
+
+// TestMemoryStoreConcurrentUpdates is meant for go test -race.
+func TestMemoryStoreConcurrentUpdates(t *testing.T) {
+	const workers, perWorker, limit = 50, 20, 100
+	ctx := context.Background()
+	m := NewMemoryStore()
+	l := NewLimiter(TokenBucket{}, m)
+	// a refill of one request a day cannot add to the count while the test runs
+	lim := Limit{Requests: limit, Window: limit * 24 * time.Hour}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		admitted int
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				res, err := l.Allow(ctx, "kid:bob", lim)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := m.Update(ctx, "counter", time.Hour, func(s *State) { s.Value++ }); err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Allowed {
+					mu.Lock()
+					admitted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != limit {
+		t.Errorf("admitted %d requests, want %d", admitted, limit)
+	}
+	var count float64
+	m.Update(ctx, "counter", time.Hour, func(s *State) { count = s.Value })
+	if count != workers*perWorker {
+		t.Errorf("counter = %v after %d updates", count, workers*perWorker)
+	}
+}
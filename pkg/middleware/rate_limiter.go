@@ -1,41 +1,199 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+)
+
+// Roles used in RouteLimits.Roles besides the account roles.
+const (
+	RoleKid       = "kid"
+	RoleAnonymous = "anonymous" // no session or token; counted per client IP
 )
 
-var userTimestamps = make(map[string][]time.Time)
+// RouteLimits are the limits of a route name passed to RateLimit. The most specific
+// limit that applies wins: the kid's or the API token's, then the caller's role,
+// then Default.
+type RouteLimits struct {
+	Default *Limit           `json:"default"`
+	Roles   map[string]Limit `json:"roles"`
+	Kids    map[string]Limit `json:"kids"`   // by kid username
+	Tokens  map[string]Limit `json:"tokens"` // by API token ID
+}
+
+// RateLimitConfig is the content of the RATE_LIMIT_CONFIG file. Default applies
+// to routes that have no entry in Routes.
+type RateLimitConfig struct {
+	Default *Limit                 `json:"default"`
+	Routes  map[string]RouteLimits `json:"routes"`
+}
+
+// defaultRateLimits apply without RATE_LIMIT_CONFIG: five chat messages a minute,
+// twenty questions for approval an hour and 120 API calls a minute.
+var defaultRateLimits = RateLimitConfig{
+	Routes: map[string]RouteLimits{
+		"chat": {Default: &Limit{Requests: 5, Window: time.Minute}},
+		"ask":  {Default: &Limit{Requests: 20, Window: time.Hour}},
+		"api":  {Default: &Limit{Requests: 120, Window: time.Minute}},
+	},
+}
+
+// UnmarshalJSON reads {"requests": 5, "window": "1m", "burst": 10}.
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Requests int    `json:"requests"`
+		Window   string `json:"window"`
+		Burst    int    `json:"burst"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	w, err := time.ParseDuration(raw.Window)
+	if err != nil && raw.Requests > 0 {
+		return fmt.Errorf("window: %w", err)
+	}
+	*l = Limit{Requests: raw.Requests, Window: w, Burst: raw.Burst}
+	return nil
+}
+
+var (
+	// Default is the limiter RateLimit uses, set by InitRateLimits.
+	Default    Limiter
+	rateStore  Store
+	rateLimits = defaultRateLimits
+)
 
-const maxRequests = 5
+// InitRateLimits sets Default from RATE_LIMIT_ALGORITHM ("sliding_window", the
+// default, or "token_bucket") and RATE_LIMIT_STORE ("memory", the default, or
+// "sql"), and loads the limits from the JSON file at RATE_LIMIT_CONFIG, if set.
+func InitRateLimits() error {
+	var alg Algorithm
+	switch a := os.Getenv("RATE_LIMIT_ALGORITHM"); a {
+	case "", "sliding_window":
+		alg = SlidingWindow{}
+	case "token_bucket":
+		alg = TokenBucket{}
+	default:
+		return fmt.Errorf("RATE_LIMIT_ALGORITHM: unknown algorithm %q", a)
+	}
+	switch s := os.Getenv("RATE_LIMIT_STORE"); s {
+	case "", "memory":
+		rateStore = NewMemoryStore()
+	case "sql":
+		rateStore = SQLStore{}
+	default:
+		return fmt.Errorf("RATE_LIMIT_STORE: unknown store %q", s)
+	}
 
-func RateLimit() gin.HandlerFunc {
+	rateLimits = defaultRateLimits
+	if path := os.Getenv("RATE_LIMIT_CONFIG"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var cfg RateLimitConfig
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return fmt.Errorf("RATE_LIMIT_CONFIG: %w", err)
+		}
+		rateLimits = cfg
+	}
+	Default = NewLimiter(alg, rateStore)
+	return nil
+}
+
+// PurgeRateLimits deletes expired rate limiter state from the database every
+// interval until ctx ends. It returns at once unless RATE_LIMIT_STORE is "sql".
+func PurgeRateLimits(ctx context.Context, interval time.Duration) {
+	if st, ok := rateStore.(SQLStore); ok {
+		st.PurgeExpired(ctx, interval)
+	}
+}
+
+// limitFor returns the limit for the caller of route and the bucket it counts in.
+func limitFor(c *gin.Context, route string) (Limit, string) {
+	rl, ok := rateLimits.Routes[route]
+	if !ok {
+		rl = RouteLimits{Default: rateLimits.Default}
+	}
+
+	var lim *Limit
+	pick := func(m map[string]Limit, key string) {
+		if l, ok := m[key]; ok && lim == nil {
+			lim = &l
+		}
+	}
+	var bucket string
+	p, ok := auth.CurrentPrincipal(c)
+	switch {
+	case !ok:
+		bucket = "ip:" + c.ClientIP()
+		pick(rl.Roles, RoleAnonymous)
+	case p.IsKid():
+		bucket = "kid:" + p.ID
+		pick(rl.Kids, p.ID)
+		pick(rl.Roles, RoleKid)
+	case p.Method == auth.MethodToken:
+		id := strconv.FormatInt(p.TokenID, 10)
+		bucket = "token:" + id
+		pick(rl.Tokens, id)
+		pick(rl.Roles, p.Role)
+	default:
+		bucket = "user:" + p.ID
+		pick(rl.Roles, p.Role)
+	}
+	if lim == nil {
+		lim = rl.Default
+	}
+	if lim == nil {
+		return Limit{}, ""
+	}
+	return *lim, route + ":" + bucket
+}
+
+// RateLimit limits requests to the named route per caller: kids and accounts by
+// username, API tokens by token, and everyone else by IP address. Responses carry
+// RateLimit-* headers, and refused requests get 429 with Retry-After. If the
+// limiter's store fails the request is let through. Use it after auth.Identify.
+func RateLimit(route string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sess := sessions.Default(c)
-		kid := sess.Get("kid")
-		if kid == nil {
-			// not our route or not logged in
+		lim, bucket := limitFor(c, route)
+		if lim.Unlimited() {
 			c.Next()
 			return
 		}
-		now := time.Now()
-		times := userTimestamps[kid.(string)]
-		// keep only the last 60s
-		var recent []time.Time
-		for _, t := range times {
-			if now.Sub(t) < time.Minute {
-				recent = append(recent, t)
-			}
+		res, err := Default.Allow(c.Request.Context(), bucket, lim)
+		if err != nil {
+			log.Printf("⚠️ RateLimit: %s: %v", bucket, err)
+			c.Next()
+			return
 		}
-		if len(recent) >= maxRequests {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
-			c.Abort()
+
+		h := c.Writer.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", lim.Requests, ceilSeconds(lim.Window)))
+		if !res.Allowed {
+			retry := ceilSeconds(res.RetryAfter)
+			h.Set("Retry-After", strconv.Itoa(retry))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded", "retry_after": retry})
 			return
 		}
-		userTimestamps[kid.(string)] = append(recent, now)
 		c.Next()
 	}
 }
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
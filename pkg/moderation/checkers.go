@@ -0,0 +1,112 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/policy"
+)
+
+// WordList flags answers containing any of its words as whole words.
+// MaxAge limits the list to kids up to that age; zero applies it to everyone.
+type WordList struct {
+	Label  string
+	Action Action
+	MaxAge int
+	words  []listWord
+}
+
+// listWord is a word of a WordList with its compiled pattern.
+type listWord struct {
+	word string
+	re   *regexp.Regexp
+}
+
+// NewWordList compiles words into a WordList. Blank words are skipped.
+func NewWordList(label string, words []string, action Action) (WordList, error) {
+	w := WordList{Label: label, Action: action}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		re, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(word) + `\b`)
+		if err != nil {
+			return w, fmt.Errorf("word list %s: %q: %w", label, word, err)
+		}
+		w.words = append(w.words, listWord{word, re})
+	}
+	return w, nil
+}
+
+func (w WordList) Name() string { return "wordlist:" + w.Label }
+
+func (w WordList) Check(ctx context.Context, s Subject, text string) ([]Finding, error) {
+	if w.MaxAge > 0 && s.Age > w.MaxAge {
+		return nil, nil
+	}
+	var out []Finding
+	for _, lw := range w.words {
+		for _, loc := range lw.re.FindAllStringIndex(text, -1) {
+			out = append(out, Finding{Checker: w.Name(), Action: w.Action, Reason: "word: " + lw.word, Start: loc[0], End: loc[1]})
+		}
+	}
+	return out, nil
+}
+
+// Regex flags answers matching any of Patterns, e.g. phone numbers or URLs.
+type Regex struct {
+	Label    string
+	Patterns []*regexp.Regexp
+	Action   Action
+	MaxAge   int
+}
+
+func (r Regex) Name() string { return "regex:" + r.Label }
+
+func (r Regex) Check(ctx context.Context, s Subject, text string) ([]Finding, error) {
+	if r.MaxAge > 0 && s.Age > r.MaxAge {
+		return nil, nil
+	}
+	var out []Finding
+	for _, re := range r.Patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			out = append(out, Finding{Checker: r.Name(), Action: r.Action, Reason: r.Label, Start: loc[0], End: loc[1]})
+		}
+	}
+	return out, nil
+}
+
+// RestrictedTopics blocks answers that drift into one of the kid's restricted topics,
+// using the same keyword matching as the pre-flight prompt check.
+type RestrictedTopics struct {
+	Classifier policy.KeywordClassifier
+}
+
+func (RestrictedTopics) Name() string { return "restricted-topics" }
+
+func (t RestrictedTopics) Check(ctx context.Context, s Subject, text string) ([]Finding, error) {
+	v, err := t.Classifier.Classify(ctx, s.Kid, text, s.Restricted)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return []Finding{{Checker: t.Name(), Action: Block, Reason: v.String()}}, nil
+}
+
+// Model asks the provider's moderation endpoint about the whole answer.
+type Model struct {
+	Moderator ai.Moderator
+}
+
+func (Model) Name() string { return "model" }
+
+func (m Model) Check(ctx context.Context, s Subject, text string) ([]Finding, error) {
+	res, err := m.Moderator.Moderate(ctx, text)
+	if err != nil || !res.Flagged {
+		return nil, err
+	}
+	return []Finding{{Checker: m.Name(), Action: Block, Reason: "flagged: " + strings.Join(res.Categories, ", ")}}, nil
+}
@@ -0,0 +1,275 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/policy"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Action is what the pipeline does with an answer. Higher values win.
+type Action int
+
+const (
+	Allow Action = iota
+	Redact
+	Block
+)
+
+func (a Action) String() string {
+	switch a {
+	case Redact:
+		return "redact"
+	case Block:
+		return "block"
+	default:
+		return "allow"
+	}
+}
+
+// DefaultFallback replaces blocked answers unless MODERATION_FALLBACK is set.
+const DefaultFallback = "Sorry, I can't help with that one. Maybe ask a parent, or try a different question!"
+
+// Subject is the kid an answer is meant for.
+type Subject struct {
+	Kid        string
+	Age        int
+	Restricted []string
+}
+
+// Finding is a single checker hit. Start and End locate the offending text for
+// redaction; both are zero when the finding applies to the whole answer.
+type Finding struct {
+	Checker string
+	Action  Action
+	Reason  string
+	Start   int
+	End     int
+}
+
+// Checker inspects an answer and reports anything objectionable.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context, s Subject, text string) ([]Finding, error)
+}
+
+// Result is the pipeline's decision for one answer.
+type Result struct {
+	Action   Action
+	Content  string // what may be shown to the kid
+	Findings []Finding
+}
+
+// Reasons joins the distinct finding reasons for logging.
+func (r Result) Reasons() string {
+	seen := map[string]bool{}
+	var out []string
+	for _, f := range r.Findings {
+		if !seen[f.Reason] {
+			seen[f.Reason] = true
+			out = append(out, f.Reason)
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+// Pipeline runs checkers over an answer. Checkers are cheap and also run on
+// partial answers while streaming; FinalCheckers (e.g. a moderation model call)
+// run once the answer is complete, and while streaming on each sentence before
+// it is released.
+type Pipeline struct {
+	Checkers      []Checker
+	FinalCheckers []Checker
+	Fallback      string
+}
+
+// Run evaluates text and returns the content that may be shown. A checker that
+// fails blocks the answer, since nothing cleared it.
+func (p *Pipeline) Run(ctx context.Context, s Subject, text string, final bool) Result {
+	checkers := p.Checkers
+	if final {
+		checkers = append(append([]Checker{}, p.Checkers...), p.FinalCheckers...)
+	}
+	return p.run(ctx, s, text, checkers)
+}
+
+// run evaluates text with the given checkers.
+func (p *Pipeline) run(ctx context.Context, s Subject, text string, checkers []Checker) Result {
+	res := Result{Action: Allow, Content: text}
+	for _, c := range checkers {
+		found, err := c.Check(ctx, s, text)
+		if err != nil {
+			log.Printf("⚠️ moderation: checker %s failed for %s: %v", c.Name(), s.Kid, err)
+			found = []Finding{{Checker: c.Name(), Action: Block, Reason: c.Name() + " check failed"}}
+		}
+		for _, f := range found {
+			if f.Action > res.Action {
+				res.Action = f.Action
+			}
+		}
+		res.Findings = append(res.Findings, found...)
+	}
+
+	switch res.Action {
+	case Block:
+		res.Content = p.Fallback
+	case Redact:
+		res.Content = redact(text, res.Findings)
+	}
+	return res
+}
+
+// redact masks every located finding in text.
+func redact(text string, findings []Finding) string {
+	spans := make([]Finding, 0, len(findings))
+	for _, f := range findings {
+		if f.End > f.Start {
+			spans = append(spans, f)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	var b strings.Builder
+	pos := 0
+	for _, f := range spans {
+		if f.Start < pos {
+			if f.End > pos {
+				pos = f.End
+			}
+			continue
+		}
+		b.WriteString(text[pos:f.Start])
+		b.WriteString("[removed]")
+		pos = f.End
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// Default is the pipeline applied to every answer, configured by Init.
+var Default = &Pipeline{Fallback: DefaultFallback}
+
+// Init builds the default pipeline. MODERATION_WORDLIST may point at a JSON
+// object mapping "block" or "redact" to word lists; MODERATION_MODEL=true adds
+// the provider's moderation endpoint when it has one.
+func Init() error {
+	p := &Pipeline{Fallback: DefaultFallback}
+	if fb := os.Getenv("MODERATION_FALLBACK"); fb != "" {
+		p.Fallback = fb
+	}
+
+	profanity, err := NewWordList("profanity", defaultBlockWords, Block)
+	if err != nil {
+		return err
+	}
+	p.Checkers = []Checker{
+		RestrictedTopics{Classifier: policy.Keywords},
+		profanity,
+		// Younger kids should not be handed contact details or links to follow.
+		Regex{Label: "contact details", Action: Redact, MaxAge: 12, Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`),
+			regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`),
+			regexp.MustCompile(`https?://\S+`),
+		}},
+	}
+
+	if path := os.Getenv("MODERATION_WORDLIST"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var lists map[string][]string
+		if err := json.Unmarshal(data, &lists); err != nil {
+			return err
+		}
+		for _, l := range []struct {
+			name   string
+			action Action
+		}{{"block", Block}, {"redact", Redact}} {
+			if len(lists[l.name]) == 0 {
+				continue
+			}
+			w, err := NewWordList("custom-"+l.name, lists[l.name], l.action)
+			if err != nil {
+				return err
+			}
+			p.Checkers = append(p.Checkers, w)
+		}
+	}
+
+	if os.Getenv("MODERATION_MODEL") == "true" {
+		if m, ok := ai.Default.(ai.Moderator); ok && ai.Default.Capabilities().Moderation {
+			p.FinalCheckers = append(p.FinalCheckers, Model{Moderator: m})
+		} else {
+			log.Printf("⚠️ moderation: provider %s has no moderation endpoint; MODERATION_MODEL ignored", ai.Default.Name())
+		}
+	}
+
+	Default = p
+	return nil
+}
+
+var defaultBlockWords = []string{"fuck", "shit", "bitch", "bastard", "cunt", "dick", "porn"}
+
+// LoadSubject looks up the kid's age and restricted topics. Unknown usernames
+// are moderated as age 0, which applies every age-limited checker.
+func LoadSubject(ctx context.Context, kid string) (Subject, error) {
+	s := Subject{Kid: kid}
+	k, err := store.Default.Kids.Get(ctx, kid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return s, err
+	}
+	s.Age = k.Age
+	s.Restricted, err = policy.RestrictedTopics(ctx, kid)
+	return s, err
+}
+
+// Incident identifies where a moderated answer came from.
+type Incident struct {
+	Kid       string
+	Source    string // "chat", "request" or "report"
+	SessionID int64  // chat session, when Source is "chat"
+}
+
+// Record stores a blocked or redacted answer for parent review and emits an audit event.
+func Record(ctx context.Context, inc Incident, original string, res Result) error {
+	return store.Default.InTx(ctx, func(tx store.Repos) error {
+		if err := tx.Audit.RecordIncident(ctx, store.Incident{
+			Username:  inc.Kid,
+			Source:    inc.Source,
+			SessionID: inc.SessionID,
+			Action:    res.Action.String(),
+			Reason:    res.Reasons(),
+			Original:  original,
+			Delivered: res.Content,
+		}); err != nil {
+			return err
+		}
+		return tx.Audit.LogEvent(ctx, "output_"+res.Action.String()+"ed", inc.Kid)
+	})
+}
+
+// Moderate runs a complete answer through the default pipeline for the given kid
+// and records an incident when anything was changed. It returns the content that
+// may be shown to the kid.
+func Moderate(ctx context.Context, inc Incident, answer string) string {
+	s, err := LoadSubject(ctx, inc.Kid)
+	if err != nil {
+		log.Printf("⚠️ moderation: failed to load subject %s: %v", inc.Kid, err)
+	}
+	res := Default.Run(ctx, s, answer, true)
+	if res.Action != Allow {
+		if err := Record(ctx, inc, answer, res); err != nil {
+			log.Printf("⚠️ moderation: failed to record incident for %s: %v", inc.Kid, err)
+		}
+	}
+	return res.Content
+}
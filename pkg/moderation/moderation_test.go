@@ -0,0 +1,75 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+// failingChecker is a checker whose backend is down.
+type failingChecker struct{}
+
+func (failingChecker) Name() string { return "failing" }
+
+func (failingChecker) Check(ctx context.Context, s Subject, text string) ([]Finding, error) {
+	return nil, errors.New("unavailable")
+}
+
+func testPipeline(t *testing.T) *Pipeline {
+	t.Helper()
+	words, err := NewWordList("test", []string{"darn"}, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Pipeline{
+		Checkers: []Checker{
+			words,
+			Regex{Label: "contact details", Action: Redact, MaxAge: 12, Patterns: []*regexp.Regexp{
+				regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`),
+				regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`),
+			}},
+		},
+		Fallback: "fallback",
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	kid := Subject{Kid: "bob", Age: 9}
+	tests := []struct {
+		name    string
+		final   []Checker
+		subject Subject
+		text    string
+		isFinal bool
+		action  Action
+		content string
+	}{
+		{name: "allow", subject: kid, text: "Cats sleep a lot.", action: Allow, content: "Cats sleep a lot."},
+		{name: "block", subject: kid, text: "Oh darn it.", action: Block, content: "fallback"},
+		{name: "block beats redact", subject: kid, text: "Darn, mail bob@example.com.", action: Block, content: "fallback"},
+		{
+			name: "redact", subject: kid, text: "Mail bob@example.com or call 555 123 4567.",
+			action: Redact, content: "Mail [removed] or call [removed].",
+		},
+		{
+			name: "redaction is age limited", subject: Subject{Kid: "ann", Age: 15}, text: "Mail bob@example.com.",
+			action: Allow, content: "Mail bob@example.com.",
+		},
+		{name: "final checkers wait", final: []Checker{failingChecker{}}, subject: kid, text: "Hi.", action: Allow, content: "Hi."},
+		{
+			name: "checker error blocks", final: []Checker{failingChecker{}}, subject: kid, text: "Hi.", isFinal: true,
+			action: Block, content: "fallback",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPipeline(t)
+			p.FinalCheckers = tt.final
+			res := p.Run(context.Background(), tt.subject, tt.text, tt.isFinal)
+			if res.Action != tt.action || res.Content != tt.content {
+				t.Errorf("Run = %v %q, want %v %q", res.Action, res.Content, tt.action, tt.content)
+			}
+		})
+	}
+}
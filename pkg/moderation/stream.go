@@ -0,0 +1,124 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrBlocked is returned by Stream.Write once the answer has been blocked.
+var ErrBlocked = errors.New("answer blocked by moderation")
+
+const (
+	// streamHoldBack is how much received text is kept back behind a release, so
+	// that a pattern crossing the cut (an e-mail address, a phone number) is seen
+	// whole before any of it goes out.
+	streamHoldBack = 64
+	// streamMaxPending is how much text may wait for a sentence end before it is
+	// released at a word break instead.
+	streamMaxPending = 512
+)
+
+// Stream moderates an answer while it is generated. Text is released a sentence
+// at a time, once the cheap checkers have seen it together with the text after
+// it and the final checkers have cleared it; only the text not yet released is
+// checked on each write.
+type Stream struct {
+	p        *Pipeline
+	s        Subject
+	raw      strings.Builder
+	released int             // bytes of the raw answer released so far
+	sent     strings.Builder // what was released, after redaction
+	blocked  *Result
+}
+
+// NewStream starts moderating an answer meant for s.
+func (p *Pipeline) NewStream(s Subject) *Stream {
+	return &Stream{p: p, s: s}
+}
+
+// Write adds a delta of the answer and returns the text that may be shown now,
+// often none. Once the answer is blocked it returns ErrBlocked.
+func (st *Stream) Write(ctx context.Context, delta string) (string, error) {
+	if st.blocked != nil {
+		return "", ErrBlocked
+	}
+	st.raw.WriteString(delta)
+	pending := st.raw.String()[st.released:]
+	limit := len(pending) - streamHoldBack
+	if limit <= 0 {
+		return "", nil
+	}
+	cut := sentenceEnd(pending[:limit])
+	if cut == 0 && limit > streamMaxPending {
+		cut = strings.LastIndexAny(pending[:limit], " \n\t") + 1
+	}
+	if cut == 0 {
+		return "", nil
+	}
+
+	res := st.p.Run(ctx, st.s, pending, false)
+	if res.Action == Block {
+		st.blocked = &res
+		return "", ErrBlocked
+	}
+	var inside []Finding
+	for _, f := range res.Findings {
+		if f.Start < cut && f.End > cut {
+			return "", nil // wait until the match is behind a later cut
+		}
+		if f.End <= cut {
+			inside = append(inside, f)
+		}
+	}
+	if len(st.p.FinalCheckers) > 0 {
+		// A moderation model that only saw the whole answer would block what the
+		// kid has already read.
+		fin := st.p.run(ctx, st.s, pending[:cut], st.p.FinalCheckers)
+		if fin.Action == Block {
+			fin.Findings = append(res.Findings, fin.Findings...)
+			st.blocked = &fin
+			return "", ErrBlocked
+		}
+		inside = append(inside, fin.Findings...)
+	}
+	out := redact(pending[:cut], inside)
+	st.released += cut
+	st.sent.WriteString(out)
+	return out, nil
+}
+
+// Raw returns the answer received so far.
+func (st *Stream) Raw() string { return st.raw.String() }
+
+// Sent returns the text released so far, as it was shown.
+func (st *Stream) Sent() string { return st.sent.String() }
+
+// Finish moderates the whole answer once it is over, with the final checkers if
+// complete. A blocked answer stays blocked. Everything Sent has been through the
+// final checkers already, so an answer cut short may keep it.
+func (st *Stream) Finish(ctx context.Context, complete bool) Result {
+	res := st.p.Run(ctx, st.s, st.Raw(), complete)
+	if st.blocked != nil && res.Action != Block {
+		res = *st.blocked
+		res.Content = st.p.Fallback
+	}
+	return res
+}
+
+// sentenceEnd returns the length of text up to and including its last sentence
+// end: a newline, or whitespace after ".", "!" or "?". It returns 0 if there is
+// none.
+func sentenceEnd(text string) int {
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case '\n':
+			return i + 1
+		case ' ', '\t':
+			if i > 0 && strings.IndexByte(".!?", text[i-1]) >= 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+)
+
+func TestStreamHoldsBackSplitMatches(t *testing.T) {
+	// The phone number has a sentence end in it, so the first cut falls inside it.
+	answer := "Sure. Call 555. 123. 4567 if you need it. " +
+		strings.Repeat("Dogs like to run and play outside every day. ", 4)
+	const want = "Sure. Call [removed] if you need it. "
+
+	feeds := map[string]func(ctx context.Context, write func(string) error) error{
+		"words from the provider": func(ctx context.Context, write func(string) error) error {
+			fake := ai.NewFake(ai.FakeReply{Reply: answer})
+			_, err := fake.ChatStream(ctx, ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: "phone?"}}}, write)
+			return err
+		},
+		"one byte at a time": func(ctx context.Context, write func(string) error) error {
+			for i := range len(answer) {
+				if err := write(answer[i : i+1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	for name, feed := range feeds {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			st := testPipeline(t).NewStream(Subject{Kid: "bob", Age: 9})
+			var shown strings.Builder
+			err := feed(ctx, func(delta string) error {
+				out, err := st.Write(ctx, delta)
+				if strings.ContainsAny(out, "0123456789") {
+					t.Errorf("released %q", out)
+				}
+				shown.WriteString(out)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(shown.String(), want) {
+				t.Errorf("shown %q, want it to start with %q", shown.String(), want)
+			}
+			if res := st.Finish(ctx, true); res.Action != Redact || !strings.HasPrefix(res.Content, want) {
+				t.Errorf("Finish = %v %q", res.Action, res.Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/quota"
+)
+
+// Reason codes returned to clients when a prompt is rejected.
+const (
+	ReasonRestrictedTopic  = "restricted_topic"
+	ReasonRequiresApproval = "requires_approval"
+)
+
+// Violation describes why a prompt was rejected.
+type Violation struct {
+	Reason   string // machine-readable reason code
+	Topic    string // the restricted topic that matched
+	Matched  string // the word or phrase in the prompt that triggered the match
+	Source   string // which classifier decided: "keyword" or "llm"
+	Severity int    // severity of the matching rule
+}
+
+// String is the form stored in violation_attempts.violation.
+func (v *Violation) String() string {
+	if v.Reason == ReasonRequiresApproval {
+		return fmt.Sprintf("requires approval: %s", v.Topic)
+	}
+	return fmt.Sprintf("restricted topic: %s", v.Topic)
+}
+
+// Classifier decides whether a prompt of kid touches one of the restricted topics.
+// It returns nil when the prompt is acceptable.
+type Classifier interface {
+	Classify(ctx context.Context, kid, prompt string, restricted []string) (*Violation, error)
+}
+
+// KeywordClassifier matches whole words and phrases of each topic and its synonyms.
+type KeywordClassifier struct {
+	Synonyms map[string][]string
+}
+
+// Classify reports the first restricted topic whose name or synonym appears in the prompt.
+func (k KeywordClassifier) Classify(ctx context.Context, kid, prompt string, restricted []string) (*Violation, error) {
+	text := " " + normalize(prompt) + " "
+	for _, topic := range restricted {
+		terms := append([]string{topic}, k.Synonyms[normalize(topic)]...)
+		for _, term := range terms {
+			t := normalize(term)
+			if t == "" {
+				continue
+			}
+			if strings.Contains(text, " "+t+" ") {
+				return &Violation{Reason: ReasonRestrictedTopic, Topic: topic, Matched: term, Source: "keyword"}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// normalize lowercases s and replaces punctuation with single spaces.
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// LLMClassifier asks the AI provider whether the prompt is about a restricted topic.
+// It catches paraphrases that keyword matching misses, at the cost of an extra call
+// that counts towards the kid's token budgets.
+type LLMClassifier struct {
+	Provider ai.Provider
+}
+
+// Classify asks the model to name the matching restricted topic, or NONE. It fails
+// with quota.ExhaustedError once the kid's budget is used up.
+func (l LLMClassifier) Classify(ctx context.Context, kid, prompt string, restricted []string) (*Violation, error) {
+	if len(restricted) == 0 {
+		return nil, nil
+	}
+	if _, err := quota.Check(ctx, kid); err != nil {
+		return nil, err
+	}
+	resp, err := ai.Complete(ctx, l.Provider, ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleSystem, Content: "You are a content filter for a children's app. Restricted topics: " +
+				strings.Join(restricted, ", ") + ". If the user's message is about any restricted topic, " +
+				"reply with exactly that topic name. Otherwise reply with NONE."},
+			{Role: ai.RoleUser, Content: prompt},
+		},
+		MaxTokens: 10,
+	})
+	quota.Record(ctx, quota.Call{Kid: kid, User: kid, Source: quota.SourceClassifier}, resp)
+	if err != nil {
+		return nil, err
+	}
+	answer := normalize(resp.Content)
+	for _, topic := range restricted {
+		if answer == normalize(topic) {
+			return &Violation{Reason: ReasonRestrictedTopic, Topic: topic, Matched: prompt, Source: "llm"}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// DefaultSynonyms widens common restricted topics to words kids actually type. Words
+// with everyday meanings ("high", "bet", "crush", "shooting star") are left out:
+// matches are whole words, so plurals are listed where they matter.
+var DefaultSynonyms = map[string][]string{
+	"violence":  {"murder", "murders", "murdered", "stabbing", "stabbed", "massacre"},
+	"weapons":   {"gun", "guns", "rifle", "rifles", "pistol", "pistols", "firearm", "firearms", "explosives", "ammo", "ammunition"},
+	"drugs":     {"marijuana", "cocaine", "heroin", "meth", "vape", "vapes", "vaping"},
+	"alcohol":   {"beer", "beers", "wine", "vodka", "whiskey", "drunk", "booze"},
+	"gambling":  {"casino", "casinos", "betting", "poker", "roulette", "lottery"},
+	"dating":    {"boyfriend", "boyfriends", "girlfriend", "girlfriends"},
+	"horror":    {"horror movie", "horror movies", "slasher", "gore", "gory"},
+	"self harm": {"suicide", "suicidal", "kill myself", "cut myself"},
+}
+
+// Classifiers run in order by Check; the first violation wins.
+var Classifiers []Classifier
+
+// Keywords is the configured keyword matcher, shared with output moderation.
+var Keywords = KeywordClassifier{Synonyms: DefaultSynonyms}
+
+// Init configures the classifier chain. The LLM classifier is added when
+// POLICY_LLM_CLASSIFIER=true; POLICY_SYNONYMS may point at a JSON object of
+// extra synonyms merged over DefaultSynonyms.
+func Init() error {
+	synonyms := map[string][]string{}
+	for k, v := range DefaultSynonyms {
+		synonyms[k] = v
+	}
+	if path := os.Getenv("POLICY_SYNONYMS"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var extra map[string][]string
+		if err := json.Unmarshal(data, &extra); err != nil {
+			return err
+		}
+		for k, v := range extra {
+			k = normalize(k)
+			synonyms[k] = append(synonyms[k], v...)
+		}
+	}
+
+	Keywords = KeywordClassifier{Synonyms: synonyms}
+	Classifiers = []Classifier{Keywords}
+	if os.Getenv("POLICY_LLM_CLASSIFIER") == "true" {
+		Classifiers = append(Classifiers, LLMClassifier{Provider: ai.Default})
+	}
+	return nil
+}
+
+// RestrictedTopics returns the topics the kid's effective policy denies.
+// Usernames that are not registered kids still get any rules naming them.
+func RestrictedTopics(ctx context.Context, kid string) ([]string, error) {
+	eff, err := Resolve(ctx, kid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	return eff.Topics(ActionDeny), nil
+}
+
+// Check runs the prompt through the classifier chain against the kid's denied
+// and approval-only topics. A violation is recorded in violation_attempts before
+// it is returned. Classifier errors are logged and skipped so an unavailable LLM
+// does not block the keyword check.
+func Check(ctx context.Context, kid, prompt string) (*Violation, error) {
+	return check(ctx, kid, prompt, ActionDeny, ActionRequireApproval)
+}
+
+// CheckForApproval is Check for prompts that are about to go to a parent for
+// approval anyway, so only denied topics are rejected.
+func CheckForApproval(ctx context.Context, kid, prompt string) (*Violation, error) {
+	return check(ctx, kid, prompt, ActionDeny)
+}
+
+func check(ctx context.Context, kid, prompt string, actions ...Action) (*Violation, error) {
+	eff, err := Resolve(ctx, kid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	for _, action := range actions {
+		topics := eff.Topics(action)
+		if len(topics) == 0 {
+			continue
+		}
+		for _, cl := range Classifiers {
+			v, err := cl.Classify(ctx, kid, prompt, topics)
+			if err != nil {
+				log.Printf("⚠️ policy.Check: classifier failed for %s: %v", kid, err)
+				continue
+			}
+			if v != nil {
+				if action == ActionRequireApproval {
+					v.Reason = ReasonRequiresApproval
+				}
+				if r, ok := eff.Rule(v.Topic); ok {
+					v.Severity = r.Severity
+				}
+				if err := store.Default.Audit.LogViolation(ctx, kid, prompt, v.String()); err != nil {
+					log.Printf("⚠️ policy.Check: failed to log violation for %s: %v", kid, err)
+				}
+				return v, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,294 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Action is what a topic rule does with prompts about its topic.
+type Action string
+
+const (
+	ActionAllow           Action = "allow"
+	ActionRequireApproval Action = "require_approval"
+	ActionDeny            Action = "deny"
+)
+
+// strictness orders actions so conflicting rules at the same scope resolve to the safest one.
+var strictness = map[Action]int{ActionAllow: 0, ActionRequireApproval: 1, ActionDeny: 2}
+
+// Rule scopes, from most to least specific.
+const (
+	ScopeKid     = "kid"
+	ScopeGroup   = "group"
+	ScopeAgeBand = "age_band"
+)
+
+// Severity bounds for rules.
+const (
+	MinSeverity = 1
+	MaxSeverity = 3
+)
+
+// ErrInvalidRule is returned when a rule fails validation.
+var ErrInvalidRule = errors.New("invalid policy rule")
+
+// Topic and AgeBand are stored as-is; see package store.
+type (
+	Topic   = store.Topic
+	AgeBand = store.AgeBand
+)
+
+// Rule applies an action to a topic at kid, group or age-band scope.
+type Rule struct {
+	ID       int64
+	Scope    string
+	ScopeKey string // kid username, group ID or age band ID
+	TopicID  int64
+	Topic    string
+	Action   Action
+	Severity int
+}
+
+// Effective is a kid's resolved policy: at most one rule per topic, taken from
+// the most specific scope that mentions it.
+type Effective struct {
+	Kid   string
+	Age   int
+	Rules []Rule
+}
+
+// Topics returns the names of topics resolved to the given action.
+func (e Effective) Topics(a Action) []string {
+	var out []string
+	for _, r := range e.Rules {
+		if r.Action == a {
+			out = append(out, r.Topic)
+		}
+	}
+	return out
+}
+
+// Rule returns the resolved rule for a topic, if any.
+func (e Effective) Rule(topic string) (Rule, bool) {
+	for _, r := range e.Rules {
+		if strings.EqualFold(r.Topic, topic) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Resolve computes a kid's effective policy. Kid rules override group rules,
+// which override age-band defaults. When several groups or bands disagree,
+// the strictest action wins, then the highest severity.
+//
+// For a username that is not a registered kid, Resolve returns store.ErrNotFound
+// together with the policy from any kid or group rules naming them; callers that
+// only need restrictions may use it, callers that need the age must not.
+func Resolve(ctx context.Context, kid string) (Effective, error) {
+	eff := Effective{Kid: kid}
+	k, kidErr := store.Default.Kids.Get(ctx, kid)
+	if kidErr != nil && !errors.Is(kidErr, store.ErrNotFound) {
+		return eff, kidErr
+	}
+	eff.Age = k.Age
+
+	rows, err := store.Default.Policies.RulesFor(ctx, kid, eff.Age, kidErr == nil)
+	if err != nil {
+		return eff, err
+	}
+
+	rank := map[string]int{ScopeKid: 0, ScopeGroup: 1, ScopeAgeBand: 2}
+	best := map[int64]Rule{}
+	for _, row := range rows {
+		r := ruleFromStore(row)
+		cur, ok := best[r.TopicID]
+		switch {
+		case !ok, rank[r.Scope] < rank[cur.Scope]:
+			best[r.TopicID] = r
+		case rank[r.Scope] == rank[cur.Scope]:
+			if strictness[r.Action] > strictness[cur.Action] ||
+				(r.Action == cur.Action && r.Severity > cur.Severity) {
+				best[r.TopicID] = r
+			}
+		}
+	}
+
+	for _, r := range best {
+		eff.Rules = append(eff.Rules, r)
+	}
+	sort.Slice(eff.Rules, func(i, j int) bool { return eff.Rules[i].Topic < eff.Rules[j].Topic })
+	return eff, kidErr
+}
+
+func ruleFromStore(r store.Rule) Rule {
+	return Rule{
+		ID:       r.ID,
+		Scope:    r.Scope,
+		ScopeKey: r.ScopeKey,
+		TopicID:  r.TopicID,
+		Topic:    r.Topic,
+		Action:   Action(r.Action),
+		Severity: r.Severity,
+	}
+}
+
+func (r Rule) toStore() store.Rule {
+	return store.Rule{
+		ID:       r.ID,
+		Scope:    r.Scope,
+		ScopeKey: r.ScopeKey,
+		TopicID:  r.TopicID,
+		Topic:    r.Topic,
+		Action:   string(r.Action),
+		Severity: r.Severity,
+	}
+}
+
+// ListTopics returns the topic catalog ordered by name.
+func ListTopics(ctx context.Context) ([]Topic, error) {
+	return store.Default.Policies.ListTopics(ctx)
+}
+
+// AddTopic adds a topic to the catalog, returning the existing ID if the
+// normalized name is already present.
+func AddTopic(ctx context.Context, name, description string) (int64, error) {
+	return addTopic(ctx, store.Default, name, description)
+}
+
+func addTopic(ctx context.Context, repos store.Repos, name, description string) (int64, error) {
+	name = normalize(name)
+	if name == "" {
+		return 0, fmt.Errorf("%w: topic name is required", ErrInvalidRule)
+	}
+	return repos.Policies.AddTopic(ctx, name, description)
+}
+
+// ListAgeBands returns age bands ordered by starting age.
+func ListAgeBands(ctx context.Context) ([]AgeBand, error) {
+	return store.Default.Policies.ListAgeBands(ctx)
+}
+
+// AddAgeBand creates an age band.
+func AddAgeBand(ctx context.Context, name string, minAge, maxAge int) error {
+	name = strings.TrimSpace(name)
+	if name == "" || minAge < 0 || maxAge < minAge {
+		return fmt.Errorf("%w: age band needs a name and 0 <= min age <= max age", ErrInvalidRule)
+	}
+	_, err := store.Default.Policies.AddAgeBand(ctx, AgeBand{Name: name, MinAge: minAge, MaxAge: maxAge})
+	return err
+}
+
+// ListRules returns every configured rule with its topic name.
+func ListRules(ctx context.Context) ([]Rule, error) {
+	rows, err := store.Default.Policies.ListRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Rule, len(rows))
+	for i, r := range rows {
+		out[i] = ruleFromStore(r)
+	}
+	return out, nil
+}
+
+// GetRule returns a rule by ID, or store.ErrNotFound.
+func GetRule(ctx context.Context, id int64) (Rule, error) {
+	r, err := store.Default.Policies.GetRule(ctx, id)
+	return ruleFromStore(r), err
+}
+
+// SaveRule validates r and creates or replaces the rule for its scope and topic.
+func SaveRule(ctx context.Context, r Rule) error {
+	if _, ok := strictness[r.Action]; !ok {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidRule, r.Action)
+	}
+	if r.Severity < MinSeverity || r.Severity > MaxSeverity {
+		return fmt.Errorf("%w: severity must be between %d and %d", ErrInvalidRule, MinSeverity, MaxSeverity)
+	}
+
+	repos := store.Default
+	if _, err := repos.Policies.GetTopic(ctx, r.TopicID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%w: unknown topic", ErrInvalidRule)
+		}
+		return err
+	}
+
+	var err error
+	switch r.Scope {
+	case ScopeKid:
+		_, err = repos.Kids.Get(ctx, r.ScopeKey)
+	case ScopeGroup, ScopeAgeBand:
+		id, perr := strconv.ParseInt(r.ScopeKey, 10, 64)
+		if perr != nil {
+			return fmt.Errorf("%w: invalid %s ID", ErrInvalidRule, r.Scope)
+		}
+		if r.Scope == ScopeGroup {
+			_, err = repos.Groups.Get(ctx, id)
+		} else {
+			_, err = repos.Policies.GetAgeBand(ctx, id)
+		}
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidRule, r.Scope)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: unknown %s %q", ErrInvalidRule, r.Scope, r.ScopeKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	return repos.Policies.SaveRule(ctx, r.toStore())
+}
+
+// DeleteRule removes a rule by ID. It returns store.ErrNotFound for an unknown rule.
+func DeleteRule(ctx context.Context, id int64) error {
+	return store.Default.Policies.DeleteRule(ctx, id)
+}
+
+// ImportLegacyPolicies converts rows of the old comma-separated content_policies
+// table into catalog topics and kid-scope rules, then deletes the imported rows.
+// Existing rules are left alone. Each kid is imported in its own transaction.
+func ImportLegacyPolicies(ctx context.Context) error {
+	legacy, err := store.Default.Policies.LegacyPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range legacy {
+		err := store.Default.InTx(ctx, func(tx store.Repos) error {
+			for _, set := range []struct {
+				list   string
+				action Action
+			}{{l.Allowed, ActionAllow}, {l.Restricted, ActionDeny}} {
+				for _, name := range strings.Split(set.list, ",") {
+					if normalize(name) == "" {
+						continue
+					}
+					id, err := addTopic(ctx, tx, name, "")
+					if err != nil {
+						return err
+					}
+					if err := tx.Policies.AddRuleIfMissing(ctx, store.Rule{
+						Scope: ScopeKid, ScopeKey: l.Kid, TopicID: id, Action: string(set.action), Severity: 2,
+					}); err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Policies.DeleteLegacyPolicy(ctx, l.Kid)
+		})
+		if err != nil {
+			return fmt.Errorf("importing policy for %s: %w", l.Kid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+func TestResolve(t *testing.T) {
+	prev := store.Default
+	store.Init(dbtest.Migrate(t, dbtest.SQLite(t)))
+	t.Cleanup(func() { store.Default = prev })
+	ctx := context.Background()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(store.Default.Kids.Save(ctx, store.Kid{Username: "bob", Age: 9}))
+	var groupIDs []int64
+	var groups, bands []string
+	for _, name := range []string{"chess club", "choir"} {
+		id, err := store.Default.Groups.Create(ctx, name)
+		must(err)
+		must(store.Default.Groups.AddMember(ctx, id, "bob"))
+		groupIDs = append(groupIDs, id)
+		groups = append(groups, strconv.FormatInt(id, 10))
+	}
+	for _, b := range []AgeBand{{Name: "8-10", MinAge: 8, MaxAge: 10}, {Name: "6-12", MinAge: 6, MaxAge: 12}, {Name: "teens", MinAge: 13, MaxAge: 17}} {
+		id, err := store.Default.Policies.AddAgeBand(ctx, b)
+		must(err)
+		bands = append(bands, strconv.FormatInt(id, 10))
+	}
+	rule := func(topic, scope, key string, a Action, severity int) {
+		t.Helper()
+		id, err := AddTopic(ctx, topic, "")
+		must(err)
+		must(SaveRule(ctx, Rule{Scope: scope, ScopeKey: key, TopicID: id, Action: a, Severity: severity}))
+	}
+
+	rule("animals", ScopeAgeBand, bands[0], ActionDeny, 1)
+	rule("animals", ScopeGroup, groups[0], ActionDeny, 1)
+	rule("animals", ScopeKid, "bob", ActionAllow, 1)
+	rule("games", ScopeAgeBand, bands[0], ActionDeny, 2)
+	rule("games", ScopeGroup, groups[0], ActionAllow, 1)
+	rule("music", ScopeGroup, groups[0], ActionAllow, 1)
+	rule("music", ScopeGroup, groups[1], ActionRequireApproval, 1)
+	rule("dating", ScopeGroup, groups[0], ActionDeny, 1)
+	rule("dating", ScopeGroup, groups[1], ActionDeny, 3)
+	rule("horror", ScopeAgeBand, bands[0], ActionAllow, 1)
+	rule("horror", ScopeAgeBand, bands[1], ActionRequireApproval, 2)
+	rule("gambling", ScopeAgeBand, bands[2], ActionDeny, 3)
+
+	eff, err := Resolve(ctx, "bob")
+	must(err)
+	want := map[string]Rule{
+		"animals": {Scope: ScopeKid, Action: ActionAllow, Severity: 1},
+		"games":   {Scope: ScopeGroup, Action: ActionAllow, Severity: 1},
+		"music":   {Scope: ScopeGroup, Action: ActionRequireApproval, Severity: 1},
+		"dating":  {Scope: ScopeGroup, Action: ActionDeny, Severity: 3},
+		"horror":  {Scope: ScopeAgeBand, Action: ActionRequireApproval, Severity: 2},
+	}
+	if len(eff.Rules) != len(want) {
+		t.Errorf("resolved %d rules, want %d: %+v", len(eff.Rules), len(want), eff.Rules)
+	}
+	for topic, w := range want {
+		r, ok := eff.Rule(topic)
+		if !ok || r.Scope != w.Scope || r.Action != w.Action || r.Severity != w.Severity {
+			t.Errorf("%s resolved to %+v, want %s %s severity %d", topic, r, w.Scope, w.Action, w.Severity)
+		}
+	}
+
+	// Without an age no band applies, but the kid's groups still do.
+	must(store.Default.Groups.AddMember(ctx, groupIDs[1], "zed"))
+	eff, err = Resolve(ctx, "zed")
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Resolve of an unknown kid: error = %v, want ErrNotFound", err)
+	}
+	if got := eff.Topics(ActionDeny); len(got) != 1 || got[0] != "dating" {
+		t.Errorf("unknown kid's denied topics = %v, want [dating]", got)
+	}
+}
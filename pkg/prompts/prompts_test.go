@@ -0,0 +1,59 @@
+package prompts
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+func TestSystemMessage(t *testing.T) {
+	prev := store.Default
+	store.Init(dbtest.Migrate(t, dbtest.SQLite(t)))
+	t.Cleanup(func() { store.Default = prev })
+	ctx := context.Background()
+	if err := store.Default.Kids.Save(ctx, store.Kid{Username: "bob", Age: 9, Language: "French"}); err != nil {
+		t.Fatal(err)
+	}
+	band, err := store.Default.Policies.AddAgeBand(ctx, store.AgeBand{Name: "8-10", MinAge: 8, MaxAge: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromDefault := "You are an AI assistant for a 9-year-old. Answer in French."
+
+	// Each case saves a newer template over the previous ones.
+	tests := []struct {
+		name string
+		band int64
+		body string
+		want string
+	}{
+		{name: "built-in default", want: fromDefault},
+		{name: "default band", band: store.DefaultBand, body: "Hello {{ .Kid }}.", want: "Hello bob."},
+		{name: "own band", band: band, body: "Hi {{ .Kid }}, you are {{ .Age }}.", want: "Hi bob, you are 9."},
+		// Renders for the Sample kid, who has two allowed topics; bob has none.
+		{name: "broken for this kid", band: band, body: "Talk about {{ index .Topics 1 }}.", want: fromDefault},
+		{name: "empty for this kid", band: band, body: "{{ range .Topics }}{{ . }}{{ end }}", want: fromDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.body != "" {
+				if err := Validate(tt.body); err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				if _, err := store.Default.PromptTemplates.Add(ctx, tt.band, tt.body, "mum"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := SystemMessage(ctx, "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("SystemMessage = %q, want it to start with %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/auth"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// Sources of AI provider calls.
+const (
+	SourceChat         = "chat"
+	SourceRequest      = "request"       // answers to approved requests
+	SourceReport       = "report"        // POST /generate-report
+	SourceTemplateTest = "template_test" // testing a system prompt template
+	SourceSummary      = store.SourceSummary
+	SourceClassifier   = store.SourceClassifier // POLICY_LLM_CLASSIFIER checks of kids' prompts
+)
+
+// DefaultWarnPercent is how much of a budget may be used before parents are warned,
+// unless the budget says otherwise.
+const DefaultWarnPercent = 80
+
+// PromptPrice and CompletionPrice are the price of 1,000 prompt and completion
+// tokens, used to estimate costs. Init sets them.
+var PromptPrice, CompletionPrice float64
+
+// Init reads the token prices from AI_PRICE_PROMPT and AI_PRICE_COMPLETION, each the
+// price of 1,000 tokens in the currency of your choice. Unset prices are zero.
+func Init() error {
+	var err error
+	if PromptPrice, err = price("AI_PRICE_PROMPT"); err != nil {
+		return err
+	}
+	CompletionPrice, err = price("AI_PRICE_COMPLETION")
+	return err
+}
+
+func price(env string) (float64, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseFloat(v, 64)
+	if err != nil || p < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, got %q", env, v)
+	}
+	return p, nil
+}
+
+// Cost estimates what a call with usage u cost.
+func Cost(u ai.Usage) float64 {
+	return (float64(u.PromptTokens)*PromptPrice + float64(u.CompletionTokens)*CompletionPrice) / 1000
+}
+
+// Call says who a provider call was made for and why.
+type Call struct {
+	Kid    string // the kid the call was for, if any
+	User   string // who made it
+	Source string
+}
+
+// Record stores the usage of a provider call and warns parents about budgets it
+// brings close to their limit. Failures are logged; accounting never fails a call.
+func Record(ctx context.Context, call Call, resp ai.ChatResponse) {
+	if resp.Usage.TotalTokens == 0 {
+		return
+	}
+	// The answer has been paid for even if the caller has gone.
+	ctx = context.WithoutCancel(ctx)
+	err := store.Default.Usage.Add(ctx, store.UsageRecord{
+		KidUsername:      call.Kid,
+		Username:         call.User,
+		Source:           call.Source,
+		Model:            resp.Model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+		Cost:             Cost(resp.Usage),
+	})
+	if err != nil {
+		log.Printf("⚠️ quota.Record: %s call for %q: %v", call.Source, call.Kid, err)
+		return
+	}
+	warnParents(ctx, call.Kid)
+}
+
+// BudgetStatus is a budget and how much of it the current period has used.
+type BudgetStatus struct {
+	store.UsageBudget
+	Used   store.UsageTotals
+	Start  time.Time // of the current period
+	Resets time.Time // when the next period starts
+}
+
+// Percent is the larger share of the token and message limits used, in percent.
+func (s BudgetStatus) Percent() int {
+	var p int64
+	if s.MaxTokens > 0 {
+		p = s.Used.Tokens * 100 / s.MaxTokens
+	}
+	if s.MaxMessages > 0 {
+		p = max(p, s.Used.Messages*100/s.MaxMessages)
+	}
+	return int(p)
+}
+
+// Exhausted reports whether no further call fits the budget.
+func (s BudgetStatus) Exhausted() bool {
+	return (s.MaxTokens > 0 && s.Used.Tokens >= s.MaxTokens) ||
+		(s.MaxMessages > 0 && s.Used.Messages >= s.MaxMessages)
+}
+
+// Warning reports whether usage has reached the budget's warning level. A level
+// of zero never warns.
+func (s BudgetStatus) Warning() bool {
+	return s.WarnPercent > 0 && s.Percent() >= s.WarnPercent
+}
+
+// Status returns kid's budgets, or the household's for store.Household, with their
+// usage in the current period.
+func Status(ctx context.Context, kid string) ([]BudgetStatus, error) {
+	budgets, err := store.Default.UsageBudgets.For(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	out := make([]BudgetStatus, 0, len(budgets))
+	for _, b := range budgets {
+		s := BudgetStatus{UsageBudget: b, Start: PeriodStart(b.Period, now)}
+		s.Resets = PeriodEnd(b.Period, s.Start)
+		if s.Used, err = store.Default.Usage.Totals(ctx, kid, s.Start); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// PeriodStart returns when the period containing t started, in t's time zone:
+// midnight, midnight on Monday, or midnight on the first of the month.
+func PeriodStart(period string, t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch period {
+	case store.PeriodWeek:
+		d -= (int(t.Weekday()) + 6) % 7
+	case store.PeriodMonth:
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// PeriodEnd returns when the period that started at start ends.
+func PeriodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case store.PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case store.PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// ValidPeriod reports whether period is one a budget can cover.
+func ValidPeriod(period string) bool {
+	switch period {
+	case store.PeriodDay, store.PeriodWeek, store.PeriodMonth:
+		return true
+	}
+	return false
+}
+
+// thisPeriod names the current period for messages, e.g. "today's".
+func thisPeriod(period string) string {
+	switch period {
+	case store.PeriodWeek:
+		return "this week's"
+	case store.PeriodMonth:
+		return "this month's"
+	default:
+		return "today's"
+	}
+}
+
+// ExhaustedError is returned by Check when a budget is used up.
+type ExhaustedError struct {
+	Budget BudgetStatus
+}
+
+func (e *ExhaustedError) Error() string {
+	if e.Budget.KidUsername == store.Household {
+		return thisPeriod(e.Budget.Period) + " household AI allowance is used up"
+	}
+	return thisPeriod(e.Budget.Period) + " AI allowance is used up"
+}
+
+// Check is called before the provider is called for kid. It returns an
+// *ExhaustedError if one of kid's budgets or the household's is used up. Otherwise
+// it returns a warning for the kid when a budget has reached its warning level.
+func Check(ctx context.Context, kid string) (warning string, err error) {
+	var all []BudgetStatus
+	for _, k := range budgetOwners(kid) {
+		list, err := Status(ctx, k)
+		if err != nil {
+			return "", err
+		}
+		all = append(all, list...)
+	}
+	for _, s := range all {
+		if s.Exhausted() {
+			return "", &ExhaustedError{Budget: s}
+		}
+	}
+	for _, s := range all {
+		if s.Warning() {
+			who := "You have"
+			if s.KidUsername == store.Household {
+				who = "Your family has"
+			}
+			return fmt.Sprintf("%s used %d%% of %s AI allowance.", who, s.Percent(), thisPeriod(s.Period)), nil
+		}
+	}
+	return "", nil
+}
+
+// budgetOwners lists whose budgets a call for kid counts against.
+func budgetOwners(kid string) []string {
+	if kid == store.Household {
+		return []string{store.Household}
+	}
+	return []string{kid, store.Household}
+}
+
+// warnParents tells the guardians of kid, and the admins for the household, about
+// budgets that reached their warning level. Each budget warns once per period.
+func warnParents(ctx context.Context, kid string) {
+	for _, k := range budgetOwners(kid) {
+		list, err := Status(ctx, k)
+		if err != nil {
+			log.Printf("⚠️ quota.warnParents: %q: %v", k, err)
+			return
+		}
+		for _, s := range list {
+			if !s.Warning() {
+				continue
+			}
+			due, err := store.Default.UsageBudgets.MarkWarned(ctx, k, s.Period, s.Start)
+			if err != nil {
+				log.Printf("⚠️ quota.warnParents: %q %s: %v", k, s.Period, err)
+				continue
+			}
+			if !due {
+				continue
+			}
+			if k == store.Household {
+				auth.NotifyAdmins(ctx, "usage_budget", fmt.Sprintf(
+					"The household has used %d%% of %s AI budget.", s.Percent(), thisPeriod(s.Period)))
+			} else {
+				auth.NotifyGuardians(ctx, k, "usage_budget", fmt.Sprintf(
+					"%s has used %d%% of %s AI budget.", k, s.Percent(), thisPeriod(s.Period)))
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/ai"
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+func TestPeriods(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(m time.Month, d, h int) time.Time { return time.Date(2026, m, d, h, 0, 0, 0, ny) }
+	tests := []struct {
+		period     string
+		t          time.Time
+		start, end time.Time
+	}{
+		{store.PeriodDay, at(3, 4, 15), at(3, 4, 0), at(3, 5, 0)},
+		{store.PeriodDay, at(3, 4, 0), at(3, 4, 0), at(3, 5, 0)},
+		{store.PeriodDay, at(3, 8, 12), at(3, 8, 0), at(3, 9, 0)}, // 23 hours long
+		{store.PeriodWeek, at(3, 4, 15), at(3, 2, 0), at(3, 9, 0)},
+		{store.PeriodWeek, at(3, 2, 0), at(3, 2, 0), at(3, 9, 0)},
+		{store.PeriodWeek, at(3, 8, 23), at(3, 2, 0), at(3, 9, 0)}, // Sunday ends the week
+		{store.PeriodWeek, at(11, 1, 12), at(10, 26, 0), at(11, 2, 0)},
+		{store.PeriodMonth, at(3, 31, 23), at(3, 1, 0), at(4, 1, 0)},
+		{store.PeriodMonth, at(12, 15, 9), at(12, 1, 0), time.Date(2027, 1, 1, 0, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		start := PeriodStart(tt.period, tt.t)
+		end := PeriodEnd(tt.period, start)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s of %v = %v to %v, want %v to %v", tt.period, tt.t, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	prev := store.Default
+	store.Init(dbtest.Migrate(t, dbtest.SQLite(t)))
+	t.Cleanup(func() { store.Default = prev })
+	ctx := context.Background()
+	for _, kid := range []string{"bob", "ann"} {
+		if err := store.Default.Kids.Save(ctx, store.Kid{Username: kid, Age: 9}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range []store.UsageBudget{
+		{KidUsername: "bob", Period: store.PeriodDay, MaxMessages: 3, WarnPercent: 60},
+		{KidUsername: store.Household, Period: store.PeriodWeek, MaxMessages: 5, WarnPercent: 80},
+	} {
+		if err := store.Default.UsageBudgets.Set(ctx, b, "mum"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := ai.NewFake(ai.FakeReply{Reply: "Cats sleep most of the day."})
+	ask := func(kid string) {
+		t.Helper()
+		resp, err := fake.Chat(ctx, ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: "Do cats sleep?"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		Record(ctx, Call{Kid: kid, User: kid, Source: SourceChat}, resp)
+	}
+	check := func(kid, wantWarning, wantExhausted string) {
+		t.Helper()
+		warning, err := Check(ctx, kid)
+		var ex *ExhaustedError
+		switch {
+		case wantExhausted != "":
+			if !errors.As(err, &ex) || ex.Error() != wantExhausted {
+				t.Errorf("Check(%s) error = %v, want %q", kid, err, wantExhausted)
+			}
+		case err != nil:
+			t.Errorf("Check(%s): %v", kid, err)
+		case warning != wantWarning:
+			t.Errorf("Check(%s) warning = %q, want %q", kid, warning, wantWarning)
+		}
+	}
+
+	check("bob", "", "")
+	ask("bob")
+	check("bob", "", "")
+	ask("bob")
+	check("bob", "You have used 66% of today's AI allowance.", "")
+	ask("bob")
+	check("bob", "", "today's AI allowance is used up")
+	check("ann", "", "")
+	ask("ann")
+	check("ann", "Your family has used 80% of this week's AI allowance.", "")
+	ask("ann")
+	check("ann", "", "this week's household AI allowance is used up")
+	check(store.Household, "", "this week's household AI allowance is used up")
+}
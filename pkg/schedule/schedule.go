@@ -0,0 +1,242 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // kids' time zones also on hosts without a zoneinfo database
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+// IdleGap is how long a kid may go without a request before their screen time
+// stops counting. A longer gap still counts as IdleGap, so a request every
+// IdleGap and a second cannot go uncounted; a kid back from a break pays at most
+// IdleGap for it.
+const IdleGap = 5 * time.Minute
+
+// Reasons a kid is kept out.
+const (
+	ReasonOutsideHours = "outside_hours"
+	ReasonTimeUsedUp   = "time_used_up"
+)
+
+// NoWindows is the windows of a day without any allowed time.
+const NoWindows = "none"
+
+// dayLength is the number of minutes in a day.
+const dayLength = 24 * 60
+
+// Window is an allowed stretch of a day, in minutes after midnight.
+type Window struct {
+	Start, End int
+}
+
+// ParseWindows parses a list of allowed windows like "07:00-08:00, 15:30-20:00".
+// An empty list is the whole day and "none" no time at all. Windows end by
+// midnight, written "24:00"; to cross it use one window on each day.
+func ParseWindows(s string) ([]Window, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		return []Window{{0, dayLength}}, nil
+	case NoWindows:
+		return []Window{}, nil
+	}
+	var out []Window
+	for _, part := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a window like 15:30-20:00", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		if start >= end {
+			return nil, fmt.Errorf("window %q ends before it starts", strings.TrimSpace(part))
+		}
+		out = append(out, Window{start, end})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > dayLength {
+		return 0, fmt.Errorf("%q is not a time like 07:30", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// FormatWindows formats windows the way ParseWindows reads them.
+func FormatWindows(ws []Window) string {
+	if len(ws) == 0 {
+		return NoWindows
+	}
+	parts := make([]string, len(ws))
+	for i, w := range ws {
+		parts[i] = fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NormalizeWindows checks a list of windows and returns it as it is stored.
+func NormalizeWindows(s string) (string, error) {
+	ws, err := ParseWindows(s)
+	if err != nil {
+		return "", err
+	}
+	return FormatWindows(ws), nil
+}
+
+// Location returns the time zone named by tz, or the server's if tz is empty.
+func Location(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// Day returns the date of t as overrides and screen time are kept.
+func Day(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+// Decision is whether a kid may use the AI right now.
+type Decision struct {
+	Allowed   bool
+	Reason    string        // why not
+	Until     time.Time     // when they may come back, if within a week
+	ExtraTime bool          // allowed only thanks to extra time from a parent
+	Used      time.Duration // screen time today
+}
+
+// Rules are a kid's schedule with the overrides of coming days, by day.
+type Rules struct {
+	Schedule  store.KidSchedule
+	Overrides map[string]string
+}
+
+// Location is the kid's time zone, the server's if the stored one is unknown.
+func (r Rules) Location() *time.Location {
+	loc, err := Location(r.Schedule.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// windowsOn returns the allowed windows of day. Windows that cannot be read allow
+// nothing.
+func (r Rules) windowsOn(day time.Time) []Window {
+	list, ok := r.Overrides[Day(day)]
+	if !ok {
+		list = r.Schedule.Weekdays
+		if wd := day.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			list = r.Schedule.Weekends
+		}
+	}
+	ws, err := ParseWindows(list)
+	if err != nil {
+		return nil
+	}
+	return ws
+}
+
+// Decide decides whether the kid may use the AI at now, having used the given
+// screen time today.
+func (r Rules) Decide(now time.Time, used time.Duration) Decision {
+	now = now.In(r.Location())
+	d := Decision{Used: used}
+	if r.Schedule.ExtraUntil != nil && now.Before(*r.Schedule.ExtraUntil) {
+		d.Allowed, d.ExtraTime = true, true
+		return d
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	open := false
+	for _, w := range r.windowsOn(now) {
+		open = open || (w.Start <= minute && minute < w.End)
+	}
+	limit := time.Duration(r.Schedule.DailyMinutes) * time.Minute
+	switch {
+	case !open:
+		d.Reason, d.Until = ReasonOutsideHours, r.nextOpen(now)
+	case limit > 0 && used >= limit:
+		y, m, day := now.Date()
+		d.Reason, d.Until = ReasonTimeUsedUp, r.nextOpen(time.Date(y, m, day+1, 0, 0, 0, 0, now.Location()))
+	default:
+		d.Allowed = true
+	}
+	return d
+}
+
+// nextOpen returns the first allowed time from t on, looking a week ahead, or the
+// zero time if there is none.
+func (r Rules) nextOpen(t time.Time) time.Time {
+	y, m, d := t.Date()
+	for i := 0; i <= 7; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, t.Location())
+		for _, w := range r.windowsOn(day) {
+			// Wall-clock minutes, not elapsed ones: a day the clocks change on is
+			// not 24 hours long.
+			start := time.Date(y, m, d+i, 0, w.Start, 0, 0, t.Location())
+			end := time.Date(y, m, d+i, 0, w.End, 0, 0, t.Location())
+			if end.After(t) {
+				if start.Before(t) {
+					return t
+				}
+				return start
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Load returns kid's rules as of now, or ok=false if kid has no schedule.
+func Load(ctx context.Context, kid string, now time.Time) (r Rules, ok bool, err error) {
+	r.Schedule, err = store.Default.Schedules.Get(ctx, kid)
+	if errors.Is(err, store.ErrNotFound) {
+		return r, false, nil
+	} else if err != nil {
+		return r, false, err
+	}
+	overrides, err := store.Default.ScheduleOverrides.For(ctx, kid, Day(now.In(r.Location())))
+	if err != nil {
+		return r, false, err
+	}
+	r.Overrides = map[string]string{}
+	for _, o := range overrides {
+		r.Overrides[o.Day] = o.Windows
+	}
+	return r, true, nil
+}
+
+// Check records a request of kid as screen time and decides whether kid may use
+// the AI now. Kids without a schedule always may.
+func Check(ctx context.Context, kid string) (Decision, error) {
+	now := time.Now()
+	r, ok, err := Load(ctx, kid, now)
+	if err != nil || !ok {
+		return Decision{Allowed: true}, err
+	}
+	used, err := store.Default.ScreenTime.Touch(ctx, kid, Day(now.In(r.Location())), now, IdleGap)
+	if err != nil {
+		return Decision{}, err
+	}
+	return r.Decide(now, used), nil
+}
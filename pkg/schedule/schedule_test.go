@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/store"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Window
+		wantErr bool
+	}{
+		{in: "", want: []Window{{0, dayLength}}},
+		{in: "none", want: []Window{}},
+		{in: " NONE ", want: []Window{}},
+		{in: "15:30-20:00, 07:00-08:00", want: []Window{{7 * 60, 8 * 60}, {15*60 + 30, 20 * 60}}},
+		{in: "20:00-24:00", want: []Window{{20 * 60, dayLength}}},
+		{in: "7:05-9:00", want: []Window{{7*60 + 5, 9 * 60}}},
+		{in: "08:00-07:00", wantErr: true},
+		{in: "08:00-08:00", wantErr: true},
+		{in: "23:00-24:01", wantErr: true},
+		{in: "07:60-08:00", wantErr: true},
+		{in: "07:00", wantErr: true},
+		{in: "07:00-08:00,", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWindows(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWindows(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWindows(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-03-04 is a Wednesday; clocks in New York go forward on Sunday 2026-03-08.
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, ny) }
+	extra := at(4, 22, 0)
+	base := store.KidSchedule{
+		Timezone:     "America/New_York",
+		Weekdays:     "07:00-08:00, 15:30-20:00",
+		Weekends:     "09:00-24:00",
+		DailyMinutes: 60,
+	}
+
+	tests := []struct {
+		name      string
+		schedule  func(s *store.KidSchedule)
+		overrides map[string]string
+		now       time.Time
+		used      time.Duration
+		want      Decision
+	}{
+		{name: "inside a window", now: at(4, 7, 30), want: Decision{Allowed: true}},
+		{name: "time given in UTC", now: time.Date(2026, 3, 4, 12, 30, 0, 0, time.UTC), want: Decision{Allowed: true}},
+		{name: "window end is exclusive", now: at(4, 8, 0), want: Decision{Reason: ReasonOutsideHours, Until: at(4, 15, 30)}},
+		{name: "after the last window", now: at(4, 21, 0), want: Decision{Reason: ReasonOutsideHours, Until: at(5, 7, 0)}},
+		{name: "friday night waits for the weekend", now: at(6, 21, 0), want: Decision{Reason: ReasonOutsideHours, Until: at(7, 9, 0)}},
+		{name: "open until midnight", now: at(7, 23, 59), want: Decision{Allowed: true}},
+		{
+			name: "time used up rolls to tomorrow", now: at(4, 16, 0), used: time.Hour,
+			want: Decision{Reason: ReasonTimeUsedUp, Until: at(5, 7, 0), Used: time.Hour},
+		},
+		{
+			name: "time used up before the clocks change", now: at(7, 23, 0), used: time.Hour,
+			want: Decision{Reason: ReasonTimeUsedUp, Until: at(8, 9, 0), Used: time.Hour},
+		},
+		{
+			name: "time used up before the clocks go back", now: time.Date(2026, 10, 31, 23, 0, 0, 0, ny), used: time.Hour,
+			want: Decision{Reason: ReasonTimeUsedUp, Until: time.Date(2026, 11, 1, 9, 0, 0, 0, ny), Used: time.Hour},
+		},
+		{name: "time left", now: at(4, 16, 0), used: 59 * time.Minute, want: Decision{Allowed: true, Used: 59 * time.Minute}},
+		{
+			name: "no daily limit", now: at(4, 16, 0), used: 5 * time.Hour,
+			schedule: func(s *store.KidSchedule) { s.DailyMinutes = 0 },
+			want:     Decision{Allowed: true, Used: 5 * time.Hour},
+		},
+		{
+			name: "override closes the day", now: at(4, 7, 30), overrides: map[string]string{"2026-03-04": "none"},
+			want: Decision{Reason: ReasonOutsideHours, Until: at(5, 7, 0)},
+		},
+		{
+			name: "override of the next day", now: at(4, 21, 0), overrides: map[string]string{"2026-03-05": "10:00-11:00"},
+			want: Decision{Reason: ReasonOutsideHours, Until: at(5, 10, 0)},
+		},
+		{
+			name: "extra time", now: at(4, 21, 0), used: 2 * time.Hour,
+			schedule: func(s *store.KidSchedule) { s.ExtraUntil = &extra },
+			want:     Decision{Allowed: true, ExtraTime: true, Used: 2 * time.Hour},
+		},
+		{
+			name: "extra time over", now: at(4, 22, 0),
+			schedule: func(s *store.KidSchedule) { s.ExtraUntil = &extra },
+			want:     Decision{Reason: ReasonOutsideHours, Until: at(5, 7, 0)},
+		},
+		{
+			name: "never open", now: at(4, 12, 0),
+			schedule: func(s *store.KidSchedule) { s.Weekdays, s.Weekends = NoWindows, NoWindows },
+			want:     Decision{Reason: ReasonOutsideHours},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := base
+			if tt.schedule != nil {
+				tt.schedule(&s)
+			}
+			got := Rules{Schedule: s, Overrides: tt.overrides}.Decide(tt.now, tt.used)
+			if !got.Until.Equal(tt.want.Until) {
+				t.Errorf("Until = %v, want %v", got.Until, tt.want.Until)
+			}
+			got.Until, tt.want.Until = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("Decide = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
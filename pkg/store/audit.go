@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"unicode/utf8"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database"
+)
+
+// Count is a labelled total, e.g. events of one type.
+type Count struct {
+	Label string
+	Count int
+}
+
+// Incident is an AI answer that output moderation blocked or redacted.
+type Incident struct {
+	ID        int64
+	Username  string
+	Source    string // "chat", "request" or "report"
+	SessionID int64  // chat session, when Source is "chat"
+	Action    string // "block" or "redact"
+	Reason    string
+	Original  string // the model's answer; never shown to the kid
+	Delivered string // what the kid saw instead
+	Timestamp time.Time
+}
+
+// AuditRepo records audit events, policy violations and moderation incidents.
+type AuditRepo struct {
+	q       Querier
+	dialect database.Dialect
+}
+
+// subjectArrow separates the account acting from the kid it acted on in an audit
+// event's username, see ActorOnKid.
+const subjectArrow = "→"
+
+// ActorOnKid is the subject of an audit event in which an account changed
+// something of a kid's, e.g. "ann→bob".
+func ActorOnKid(actor, kid string) string {
+	return actor + subjectArrow + kid
+}
+
+// LogEvent writes a generic audit event.
+func (r *AuditRepo) LogEvent(ctx context.Context, eventType, username string) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO audit_events(event_type, username) VALUES(?,?)", eventType, username)
+	return err
+}
+
+// LogViolation records a prompt rejected by the content policy.
+func (r *AuditRepo) LogViolation(ctx context.Context, kid, prompt, violation string) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO violation_attempts(kid_username, prompt, violation) VALUES(?,?,?)", kid, prompt, violation)
+	return err
+}
+
+// EventCounts returns the number of audit events per type over the last hours. A
+// guardian's scope counts the events of their kids and their own.
+func (r *AuditRepo) EventCounts(ctx context.Context, hours int, scope Scope) ([]Count, error) {
+	cond, args := scope.kidFilter("username")
+	if !scope.All() {
+		actor := scope.Guardian + subjectArrow
+		cond = "(" + cond + " OR username = ? OR substr(username, 1, ?) = ?)"
+		args = append(args, scope.Guardian, utf8.RuneCountInString(actor), actor)
+	}
+	return r.counts(ctx, `
+		SELECT event_type, COUNT(*) FROM audit_events
+		WHERE timestamp > `+r.dialect.HoursAgo(hours)+` AND `+cond+`
+		GROUP BY event_type`, args...)
+}
+
+// ViolationCounts returns the number of policy violations per kid in scope over the last hours.
+func (r *AuditRepo) ViolationCounts(ctx context.Context, hours int, scope Scope) ([]Count, error) {
+	cond, args := scope.kidFilter("kid_username")
+	return r.counts(ctx, `
+		SELECT kid_username, COUNT(*) FROM violation_attempts
+		WHERE timestamp > `+r.dialect.HoursAgo(hours)+` AND `+cond+`
+		GROUP BY kid_username`, args...)
+}
+
+func (r *AuditRepo) counts(ctx context.Context, query string, args ...any) ([]Count, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Count
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Label, &c.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// RecordIncident stores a moderated answer for parent review.
+func (r *AuditRepo) RecordIncident(ctx context.Context, i Incident) error {
+	var sid sql.NullInt64
+	if i.SessionID > 0 {
+		sid = sql.NullInt64{Int64: i.SessionID, Valid: true}
+	}
+	_, err := r.q.ExecContext(ctx,
+		`INSERT INTO moderation_incidents(kid_username, source, session_id, action, reason, original, delivered)
+		 VALUES(?,?,?,?,?,?,?)`,
+		i.Username, i.Source, sid, i.Action, i.Reason, i.Original, i.Delivered,
+	)
+	return err
+}
+
+// ListIncidents returns the most recent incidents of kids in scope, newest first.
+func (r *AuditRepo) ListIncidents(ctx context.Context, scope Scope, limit int) ([]Incident, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT id, kid_username, source, COALESCE(session_id, 0), action, reason, original, delivered, timestamp
+		FROM moderation_incidents
+		WHERE `+cond+`
+		ORDER BY id DESC
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Incident
+	for rows.Next() {
+		var i Incident
+		if err := rows.Scan(&i.ID, &i.Username, &i.Source, &i.SessionID, &i.Action, &i.Reason,
+			&i.Original, &i.Delivered, &i.Timestamp); err != nil {
+			return nil, err
+		}
+		out = append(out, i)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ChatMessage is one side of a chat exchange.
+type ChatMessage struct {
+	ID        int64
+	SessionID int64
+	Sender    string // "kid" or "ai"
+	Content   string
+	Status    string // "complete", "partial" or "cancelled"
+	Timestamp time.Time
+}
+
+// ChatSession is a conversation of a kid with the AI.
+type ChatSession struct {
+	ID            int64
+	KidUsername   string
+	Title         string
+	CreatedAt     time.Time
+	LastMessageAt *time.Time
+	ClosedAt      *time.Time // closed sessions take no new messages until resumed
+}
+
+// ChatSummary is the rolling summary of turns that fell out of the context window.
+type ChatSummary struct {
+	Summary          string
+	ThroughMessageID int64 // last message folded into the summary
+}
+
+// ChatSessionRepo reads and writes chat_sessions and their summaries.
+type ChatSessionRepo struct{ q Querier }
+
+const chatSessionColumns = `id, kid_username, COALESCE(title, ''), created_at, last_message_at, closed_at`
+
+func scanChatSession(row scanner) (ChatSession, error) {
+	var s ChatSession
+	err := row.Scan(&s.ID, &s.KidUsername, &s.Title, &s.CreatedAt, &s.LastMessageAt, &s.ClosedAt)
+	return s, err
+}
+
+// Create starts a session for kid and returns its ID.
+func (r *ChatSessionRepo) Create(ctx context.Context, kid string) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO chat_sessions(kid_username) VALUES(?) RETURNING id", kid,
+	).Scan(&id)
+	return id, err
+}
+
+// Get returns the session with the given ID, or ErrNotFound.
+func (r *ChatSessionRepo) Get(ctx context.Context, id int64) (ChatSession, error) {
+	s, err := scanChatSession(r.q.QueryRowContext(ctx,
+		"SELECT "+chatSessionColumns+" FROM chat_sessions WHERE id = ?", id))
+	return s, notFound(err)
+}
+
+// List returns kid's sessions, the most recently used first.
+func (r *ChatSessionRepo) List(ctx context.Context, kid string) ([]ChatSession, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+chatSessionColumns+` FROM chat_sessions WHERE kid_username = ?
+		 ORDER BY COALESCE(last_message_at, created_at) DESC, id DESC`, kid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ChatSession
+	for rows.Next() {
+		s, err := scanChatSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Rename sets the session's title.
+func (r *ChatSessionRepo) Rename(ctx context.Context, id int64, title string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE chat_sessions SET title = ? WHERE id = ?", nullString(title), id))
+}
+
+// Close stops the session from taking new messages.
+func (r *ChatSessionRepo) Close(ctx context.Context, id int64) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE chat_sessions SET closed_at = ? WHERE id = ? AND closed_at IS NULL", time.Now().UTC(), id))
+}
+
+// Reopen lets a closed session take messages again.
+func (r *ChatSessionRepo) Reopen(ctx context.Context, id int64) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE chat_sessions SET closed_at = NULL WHERE id = ?", id))
+}
+
+// Delete removes the session with its messages and summary. Use it inside InTx.
+func (r *ChatSessionRepo) Delete(ctx context.Context, id int64) error {
+	if _, err := r.q.ExecContext(ctx, "DELETE FROM chat_summaries WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := r.q.ExecContext(ctx, "DELETE FROM chat_messages WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	return mustAffect(r.q.ExecContext(ctx, "DELETE FROM chat_sessions WHERE id = ?", id))
+}
+
+// Summary returns the session's stored summary, or ErrNotFound if there is none yet.
+func (r *ChatSessionRepo) Summary(ctx context.Context, sid int64) (ChatSummary, error) {
+	var s ChatSummary
+	err := r.q.QueryRowContext(ctx,
+		"SELECT summary, through_message_id FROM chat_summaries WHERE session_id = ?", sid,
+	).Scan(&s.Summary, &s.ThroughMessageID)
+	return s, notFound(err)
+}
+
+// SaveSummary creates or replaces the session's summary.
+func (r *ChatSessionRepo) SaveSummary(ctx context.Context, sid int64, s ChatSummary) error {
+	_, err := r.q.ExecContext(ctx,
+		`INSERT INTO chat_summaries(session_id, summary, through_message_id) VALUES(?,?,?)
+		 ON CONFLICT(session_id) DO UPDATE SET summary = excluded.summary,
+		   through_message_id = excluded.through_message_id, updated_at = CURRENT_TIMESTAMP`,
+		sid, s.Summary, s.ThroughMessageID,
+	)
+	return err
+}
+
+// ChatMessageRepo reads and writes chat_messages.
+type ChatMessageRepo struct{ q Querier }
+
+// Add stores a message, marks its session as just used and returns the message ID.
+func (r *ChatMessageRepo) Add(ctx context.Context, m ChatMessage) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO chat_messages(session_id,sender,content,status) VALUES(?,?,?,?) RETURNING id",
+		m.SessionID, m.Sender, m.Content, m.Status,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.q.ExecContext(ctx,
+		"UPDATE chat_sessions SET last_message_at = ? WHERE id = ?", time.Now().UTC(), m.SessionID)
+	return id, err
+}
+
+// List returns a session's messages with IDs greater than afterID, oldest first.
+// Pass 0 for the full history.
+func (r *ChatMessageRepo) List(ctx context.Context, sid, afterID int64) ([]ChatMessage, error) {
+	rows, err := r.q.QueryContext(ctx,
+		`SELECT id, sender, content, status, timestamp FROM chat_messages
+		 WHERE session_id = ? AND id > ? ORDER BY id`,
+		sid, afterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ChatMessage
+	for rows.Next() {
+		m := ChatMessage{SessionID: sid}
+		if err := rows.Scan(&m.ID, &m.Sender, &m.Content, &m.Status, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,73 @@
+package store
+
+import "context"
+
+// Group is an RBAC group synced to Permit.
+type Group struct {
+	ID   int64
+	Name string
+}
+
+// GroupRepo reads and writes groups and their members.
+type GroupRepo struct{ q Querier }
+
+// Get returns the group with the given ID, or ErrNotFound.
+func (r *GroupRepo) Get(ctx context.Context, id int64) (Group, error) {
+	g := Group{ID: id}
+	err := r.q.QueryRowContext(ctx, "SELECT name FROM groups WHERE id = ?", id).Scan(&g.Name)
+	return g, notFound(err)
+}
+
+// List returns every group ordered by name.
+func (r *GroupRepo) List(ctx context.Context) ([]Group, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT id, name FROM groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Group
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.ID, &g.Name); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// Create adds a group and returns its ID, or ErrDuplicate if the name is taken.
+func (r *GroupRepo) Create(ctx context.Context, name string) (int64, error) {
+	var exists bool
+	if err := r.q.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM groups WHERE name = ?)", name,
+	).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, ErrDuplicate
+	}
+	var id int64
+	err := r.q.QueryRowContext(ctx, "INSERT INTO groups(name) VALUES(?) RETURNING id", name).Scan(&id)
+	return id, err
+}
+
+// AddMember puts username in the group. It returns ErrNotFound for an unknown
+// group and ErrDuplicate if the user is already a member.
+func (r *GroupRepo) AddMember(ctx context.Context, groupID int64, username string) error {
+	if _, err := r.Get(ctx, groupID); err != nil {
+		return err
+	}
+	var exists bool
+	if err := r.q.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND username = ?)", groupID, username,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicate
+	}
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO group_members(group_id, username) VALUES(?,?)", groupID, username)
+	return err
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Scope limits listings to the kids one guardian looks after. The zero Scope, used
+// for admins, covers every kid.
+type Scope struct{ Guardian string }
+
+// All reports whether the scope covers every kid.
+func (s Scope) All() bool { return s.Guardian == "" }
+
+// kidFilter returns a condition restricting column, a kid username, to the scope,
+// and its arguments.
+func (s Scope) kidFilter(column string) (string, []any) {
+	if s.All() {
+		return "1 = 1", nil
+	}
+	return column + " IN (SELECT kid_username FROM guardians WHERE guardian = ?)", []any{s.Guardian}
+}
+
+// GuardianRepo reads and writes which accounts look after which kids.
+type GuardianRepo struct{ q Querier }
+
+// Add makes guardian responsible for kid. It returns ErrDuplicate if they already are.
+func (r *GuardianRepo) Add(ctx context.Context, kid, guardian, createdBy string) error {
+	err := mustAffect(r.q.ExecContext(ctx, `
+		INSERT INTO guardians(kid_username, guardian, created_by, created_at) VALUES(?,?,?,?)
+		ON CONFLICT(kid_username, guardian) DO NOTHING`,
+		kid, guardian, nullString(createdBy), time.Now().UTC(),
+	))
+	if errors.Is(err, ErrNotFound) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Remove ends guardian's responsibility for kid, or returns ErrNotFound.
+func (r *GuardianRepo) Remove(ctx context.Context, kid, guardian string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"DELETE FROM guardians WHERE kid_username = ? AND guardian = ?", kid, guardian))
+}
+
+// IsGuardian reports whether guardian looks after kid.
+func (r *GuardianRepo) IsGuardian(ctx context.Context, kid, guardian string) (bool, error) {
+	var ok bool
+	err := r.q.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM guardians WHERE kid_username = ? AND guardian = ?)", kid, guardian,
+	).Scan(&ok)
+	return ok, err
+}
+
+// Of returns the guardians of kid ordered by username.
+func (r *GuardianRepo) Of(ctx context.Context, kid string) ([]string, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT guardian FROM guardians WHERE kid_username = ? ORDER BY guardian", kid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// ByKid returns the guardians of every kid in scope, keyed by kid.
+func (r *GuardianRepo) ByKid(ctx context.Context, scope Scope) (map[string][]string, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT kid_username, guardian FROM guardians WHERE "+cond+" ORDER BY kid_username, guardian", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]string{}
+	for rows.Next() {
+		var kid, guardian string
+		if err := rows.Scan(&kid, &guardian); err != nil {
+			return nil, err
+		}
+		out[kid] = append(out[kid], guardian)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIsGuardian(t *testing.T) {
+	ctx := context.Background()
+	r, _ := testRepos(t)
+	addKid(t, r, "bob", "ann", "carl")
+	addKid(t, r, "alice", "dora")
+	if err := r.Guardians.Remove(ctx, "bob", "carl"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		kid      string
+		guardian string
+		want     bool
+	}{
+		{"guardian", "bob", "ann", true},
+		{"removed guardian", "bob", "carl", false},
+		{"guardian of another kid", "bob", "dora", false},
+		{"unknown account", "bob", "eve", false},
+		{"unknown kid", "zed", "ann", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Guardians.IsGuardian(ctx, tt.kid, tt.guardian)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsGuardian(%s, %s) = %v, want %v", tt.kid, tt.guardian, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardianAddAndRemoveTwice(t *testing.T) {
+	ctx := context.Background()
+	r, _ := testRepos(t)
+	addKid(t, r, "bob", "ann")
+	if err := r.Guardians.Add(ctx, "bob", "ann", "admin"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("second Add error = %v, want ErrDuplicate", err)
+	}
+	if err := r.Guardians.Remove(ctx, "bob", "ann"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Guardians.Remove(ctx, "bob", "ann"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Remove error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Identity is an OpenID Connect identity linked to an account.
+type Identity struct {
+	ID          int64
+	Username    string
+	Issuer      string
+	Subject     string
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt *time.Time
+}
+
+// IdentityRepo reads and writes user_identities.
+type IdentityRepo struct{ q Querier }
+
+const identityColumns = `id, username, issuer, subject, COALESCE(email, ''), created_at, last_login_at`
+
+func scanIdentity(row scanner) (Identity, error) {
+	var i Identity
+	err := row.Scan(&i.ID, &i.Username, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt)
+	return i, err
+}
+
+// Get returns the identity with the given issuer and subject, or ErrNotFound.
+func (r *IdentityRepo) Get(ctx context.Context, issuer, subject string) (Identity, error) {
+	i, err := scanIdentity(r.q.QueryRowContext(ctx,
+		"SELECT "+identityColumns+" FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject))
+	return i, notFound(err)
+}
+
+// Link attaches an identity to an account. It returns ErrDuplicate if the identity
+// is already linked.
+func (r *IdentityRepo) Link(ctx context.Context, i Identity) error {
+	err := mustAffect(r.q.ExecContext(ctx, `
+		INSERT INTO user_identities(username, issuer, subject, email, created_at) VALUES(?,?,?,?,?)
+		ON CONFLICT(issuer, subject) DO NOTHING`,
+		i.Username, i.Issuer, i.Subject, nullString(i.Email), time.Now().UTC(),
+	))
+	if errors.Is(err, ErrNotFound) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// LoginSucceeded records a login with the identity and its current email address.
+func (r *IdentityRepo) LoginSucceeded(ctx context.Context, id int64, email string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE user_identities SET email = ?, last_login_at = ? WHERE id = ?",
+		nullString(email), time.Now().UTC(), id))
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AI job states.
+const (
+	JobQueued  = "queued"  // waiting for a worker, possibly until RunAfter
+	JobRunning = "running" // claimed by a worker, until its lease runs out
+	JobDone    = "done"    // the answer was stored
+	JobFailed  = "failed"  // gave up; the request was marked failed
+)
+
+// Job is a queued AI generation for an approved prompt request.
+type Job struct {
+	ID          int64
+	RequestID   int64
+	Status      string
+	Attempts    int // also tells claims of the same job apart
+	LastError   string
+	RunAfter    time.Time
+	CreatedAt   time.Time
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+	LockedUntil *time.Time // lease of a running job
+}
+
+// JobRepo reads and writes ai_jobs.
+type JobRepo struct{ q Querier }
+
+const jobColumns = `id, request_id, status, attempts, COALESCE(last_error, ''), run_after, created_at,
+	started_at, finished_at, locked_until`
+
+func scanJob(row scanner) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.RequestID, &j.Status, &j.Attempts, &j.LastError, &j.RunAfter, &j.CreatedAt,
+		&j.StartedAt, &j.FinishedAt, &j.LockedUntil)
+	return j, err
+}
+
+// Enqueue queues a job for the request and returns its ID.
+func (r *JobRepo) Enqueue(ctx context.Context, requestID int64) (int64, error) {
+	now := time.Now().UTC()
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO ai_jobs(request_id, status, run_after, created_at) VALUES(?,?,?,?) RETURNING id",
+		requestID, JobQueued, now, now,
+	).Scan(&id)
+	return id, err
+}
+
+// Get returns the job with the given ID, or ErrNotFound.
+func (r *JobRepo) Get(ctx context.Context, id int64) (Job, error) {
+	j, err := scanJob(r.q.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM ai_jobs WHERE id = ?", id))
+	return j, notFound(err)
+}
+
+// Claim marks the oldest job that is due as running under a lease of the given
+// length, counts the attempt and returns it. Running jobs whose lease has expired
+// are due again. It returns ErrNotFound when nothing is due. The guard on the outer
+// UPDATE keeps two workers from claiming the same job.
+func (r *JobRepo) Claim(ctx context.Context, lease time.Duration) (Job, error) {
+	now := time.Now().UTC()
+	j, err := scanJob(r.q.QueryRowContext(ctx, `
+		UPDATE ai_jobs SET status = ?, attempts = attempts + 1, started_at = ?, locked_until = ?
+		WHERE id = (
+			SELECT id FROM ai_jobs
+			WHERE (status = ? AND run_after <= ?) OR (status = ? AND (locked_until IS NULL OR locked_until < ?))
+			ORDER BY run_after, id LIMIT 1)
+		AND (status = ? OR (status = ? AND (locked_until IS NULL OR locked_until < ?)))
+		RETURNING `+jobColumns,
+		JobRunning, now, now.Add(lease),
+		JobQueued, now, JobRunning, now,
+		JobQueued, JobRunning, now,
+	))
+	return j, notFound(err)
+}
+
+// Renew extends the lease of a claimed job. It returns ErrNotFound once the job is
+// no longer the caller's, e.g. because its lease ran out and it was claimed again.
+func (r *JobRepo) Renew(ctx context.Context, j Job, lease time.Duration) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE ai_jobs SET locked_until = ? WHERE id = ? AND status = ? AND attempts = ?",
+		time.Now().UTC().Add(lease), j.ID, JobRunning, j.Attempts,
+	))
+}
+
+// Complete marks a claimed job done.
+func (r *JobRepo) Complete(ctx context.Context, j Job) error {
+	return r.finish(ctx, j, JobDone, "")
+}
+
+// Fail marks a claimed job failed for good.
+func (r *JobRepo) Fail(ctx context.Context, j Job, reason string) error {
+	return r.finish(ctx, j, JobFailed, reason)
+}
+
+func (r *JobRepo) finish(ctx context.Context, j Job, status, reason string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE ai_jobs SET status = ?, last_error = ?, finished_at = ?, locked_until = NULL WHERE id = ? AND status = ? AND attempts = ?",
+		status, nullString(reason), time.Now().UTC(), j.ID, JobRunning, j.Attempts,
+	))
+}
+
+// Retry puts a claimed job back in the queue, to run no earlier than runAfter.
+func (r *JobRepo) Retry(ctx context.Context, j Job, reason string, runAfter time.Time) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE ai_jobs SET status = ?, last_error = ?, run_after = ?, locked_until = NULL WHERE id = ? AND status = ? AND attempts = ?",
+		JobQueued, nullString(reason), runAfter.UTC(), j.ID, JobRunning, j.Attempts,
+	))
+}
+
+// Release puts a claimed job back in the queue without counting the attempt, for
+// work interrupted by shutdown.
+func (r *JobRepo) Release(ctx context.Context, j Job) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE ai_jobs SET status = ?, attempts = attempts - 1, locked_until = NULL WHERE id = ? AND status = ? AND attempts = ?",
+		JobQueued, j.ID, JobRunning, j.Attempts,
+	))
+}
+
+// nullString stores "" as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClaimConcurrently has workers claim jobs at the same time and checks that
+// each job is handed out once.
+func testClaimConcurrently(t *testing.T, r Repos) {
+	ctx := context.Background()
+	const jobs, workers, claimsPerWorker = 40, 8, 15
+	for i := 0; i < jobs; i++ {
+		id, err := r.PromptRequests.Create(ctx, "bob", "question")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Jobs.Enqueue(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = map[int64]int{}
+		wg      sync.WaitGroup
+	)
+	claim := func() error {
+		j, err := r.Jobs.Claim(ctx, time.Minute)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		claimed[j.ID]++
+		mu.Unlock()
+		return nil
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < claimsPerWorker; i++ {
+				// A claim that loses a race finds nothing; the job is someone else's.
+				if err := claim(); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for {
+		err := claim()
+		if errors.Is(err, ErrNotFound) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(claimed) != jobs {
+		t.Errorf("claimed %d jobs, want %d", len(claimed), jobs)
+	}
+	for id, n := range claimed {
+		if n > 1 {
+			t.Errorf("job %d was claimed %d times", id, n)
+		}
+	}
+}
+
+func TestClaimConcurrently(t *testing.T) {
+	r, _ := testRepos(t)
+	testClaimConcurrently(t, r)
+}
+
+func TestPostgresClaimConcurrently(t *testing.T) {
+	testClaimConcurrently(t, testPostgres(t))
+}
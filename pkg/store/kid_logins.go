@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// KidCredential is a kid's login secret and its failed-attempt state.
+type KidCredential struct {
+	Kid          string
+	Kind         string // "pin" or "picture"
+	SecretHash   string
+	FailedLogins int
+	LockedUntil  *time.Time
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
+
+// Locked reports whether logins are refused at now.
+func (k KidCredential) Locked(now time.Time) bool {
+	return k.LockedUntil != nil && now.Before(*k.LockedUntil)
+}
+
+// KidCredentialRepo reads and writes kid_credentials.
+type KidCredentialRepo struct{ q Querier }
+
+const kidCredentialColumns = `kid_username, kind, secret_hash, failed_logins, locked_until,
+	COALESCE(updated_by, ''), updated_at`
+
+func scanKidCredential(row scanner) (KidCredential, error) {
+	var k KidCredential
+	err := row.Scan(&k.Kid, &k.Kind, &k.SecretHash, &k.FailedLogins, &k.LockedUntil, &k.UpdatedBy, &k.UpdatedAt)
+	return k, err
+}
+
+// Get returns the kid's credential, or ErrNotFound if none is set.
+func (r *KidCredentialRepo) Get(ctx context.Context, kid string) (KidCredential, error) {
+	k, err := scanKidCredential(r.q.QueryRowContext(ctx,
+		"SELECT "+kidCredentialColumns+" FROM kid_credentials WHERE kid_username = ?", kid))
+	return k, notFound(err)
+}
+
+// List returns the credentials of the kids in scope, keyed by kid.
+func (r *KidCredentialRepo) List(ctx context.Context, scope Scope) (map[string]KidCredential, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx, "SELECT "+kidCredentialColumns+" FROM kid_credentials WHERE "+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]KidCredential{}
+	for rows.Next() {
+		k, err := scanKidCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[k.Kid] = k
+	}
+	return out, rows.Err()
+}
+
+// Set replaces the kid's secret and clears any lockout.
+func (r *KidCredentialRepo) Set(ctx context.Context, kid, kind, hash, updatedBy string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO kid_credentials(kid_username, kind, secret_hash, updated_by, updated_at) VALUES(?,?,?,?,?)
+		ON CONFLICT(kid_username) DO UPDATE SET kind = excluded.kind, secret_hash = excluded.secret_hash,
+			failed_logins = 0, locked_until = NULL, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		kid, kind, hash, nullString(updatedBy), time.Now().UTC(),
+	)
+	return err
+}
+
+// LoginFailed counts a failed login and returns the number of failures in a row.
+func (r *KidCredentialRepo) LoginFailed(ctx context.Context, kid string) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE kid_credentials SET failed_logins = failed_logins + 1 WHERE kid_username = ? RETURNING failed_logins", kid,
+	).Scan(&n)
+	return n, notFound(err)
+}
+
+// Lock refuses the kid's logins until the given time.
+func (r *KidCredentialRepo) Lock(ctx context.Context, kid string, until time.Time) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE kid_credentials SET locked_until = ? WHERE kid_username = ?", until.UTC(), kid))
+}
+
+// Unlock clears a lockout and the failure count. Logging in successfully does the same.
+func (r *KidCredentialRepo) Unlock(ctx context.Context, kid string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE kid_credentials SET failed_logins = 0, locked_until = NULL WHERE kid_username = ?", kid))
+}
+
+// KidLoginCodeRepo reads and writes one-time kid login codes.
+type KidLoginCodeRepo struct{ q Querier }
+
+// Create stores the hash of a new code for kid and returns its ID.
+func (r *KidLoginCodeRepo) Create(ctx context.Context, kid, hash, createdBy string, expiresAt time.Time) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO kid_login_codes(kid_username, code_hash, created_by, created_at, expires_at)
+		VALUES(?,?,?,?,?) RETURNING id`,
+		kid, hash, createdBy, time.Now().UTC(), expiresAt.UTC(),
+	).Scan(&id)
+	return id, err
+}
+
+// Redeem marks the unused, unexpired code with the given hash as used and returns
+// its kid. Unknown, used and expired codes give ErrNotFound.
+func (r *KidLoginCodeRepo) Redeem(ctx context.Context, hash string) (string, error) {
+	now := time.Now().UTC()
+	var kid string
+	err := r.q.QueryRowContext(ctx, `
+		UPDATE kid_login_codes SET used_at = ?
+		WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING kid_username`,
+		now, hash, now,
+	).Scan(&kid)
+	return kid, notFound(err)
+}
@@ -0,0 +1,52 @@
+package store
+
+import "context"
+
+// Kid is a child account.
+type Kid struct {
+	Username string
+	Age      int
+	Language string // the AI answers in it; empty is English
+}
+
+// KidRepo reads and writes the kids table.
+type KidRepo struct{ q Querier }
+
+// Get returns the kid with the given username, or ErrNotFound.
+func (r *KidRepo) Get(ctx context.Context, username string) (Kid, error) {
+	k := Kid{Username: username}
+	err := r.q.QueryRowContext(ctx,
+		"SELECT age, COALESCE(language, '') FROM kids WHERE username = ?", username,
+	).Scan(&k.Age, &k.Language)
+	return k, notFound(err)
+}
+
+// List returns the kids in scope ordered by username.
+func (r *KidRepo) List(ctx context.Context, scope Scope) ([]Kid, error) {
+	cond, args := scope.kidFilter("username")
+	rows, err := r.q.QueryContext(ctx, "SELECT username, age, COALESCE(language, '') FROM kids WHERE "+cond+" ORDER BY username", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Kid
+	for rows.Next() {
+		var k Kid
+		if err := rows.Scan(&k.Username, &k.Age, &k.Language); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// Save creates the kid or updates their age, and their language unless it is empty.
+func (r *KidRepo) Save(ctx context.Context, k Kid) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO kids(username, age, language) VALUES(?,?,?)
+		ON CONFLICT(username) DO UPDATE SET age = excluded.age,
+			language = COALESCE(excluded.language, kids.language)`,
+		k.Username, k.Age, nullString(k.Language),
+	)
+	return err
+}
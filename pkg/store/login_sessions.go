@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// LoginSession is a browser session. The token in the cookie is never stored, only
+// its hash.
+type LoginSession struct {
+	ID          int64
+	Username    string // logged-in account, if any
+	KidUsername string // logged-in kid, if any
+	Data        string // encoded session values
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+}
+
+// LoginSessionRepo reads and writes login_sessions.
+type LoginSessionRepo struct{ q Querier }
+
+const loginSessionColumns = `id, COALESCE(username, ''), COALESCE(kid_username, ''), data,
+	COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at, expires_at`
+
+// liveSession matches sessions that have not expired and whose account or kid still
+// exists. SQLite does not enforce the foreign keys that would otherwise delete them.
+const liveSession = `expires_at > ?
+	AND (username IS NULL OR EXISTS(SELECT 1 FROM users u WHERE u.username = login_sessions.username))
+	AND (kid_username IS NULL OR EXISTS(SELECT 1 FROM kids k WHERE k.username = login_sessions.kid_username))`
+
+func scanLoginSession(row scanner) (LoginSession, error) {
+	var s LoginSession
+	err := row.Scan(&s.ID, &s.Username, &s.KidUsername, &s.Data, &s.IP, &s.UserAgent,
+		&s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	return s, err
+}
+
+// GetByHash returns the live session with the given token hash, or ErrNotFound.
+func (r *LoginSessionRepo) GetByHash(ctx context.Context, hash string) (LoginSession, error) {
+	s, err := scanLoginSession(r.q.QueryRowContext(ctx,
+		"SELECT "+loginSessionColumns+" FROM login_sessions WHERE token_hash = ? AND "+liveSession,
+		hash, time.Now().UTC()))
+	return s, notFound(err)
+}
+
+// Get returns the live session with the given ID, or ErrNotFound.
+func (r *LoginSessionRepo) Get(ctx context.Context, id int64) (LoginSession, error) {
+	s, err := scanLoginSession(r.q.QueryRowContext(ctx,
+		"SELECT "+loginSessionColumns+" FROM login_sessions WHERE id = ? AND "+liveSession,
+		id, time.Now().UTC()))
+	return s, notFound(err)
+}
+
+// Save creates or updates the session stored under hash.
+func (r *LoginSessionRepo) Save(ctx context.Context, hash string, s LoginSession) error {
+	now := time.Now().UTC()
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO login_sessions(token_hash, username, kid_username, data, ip, user_agent,
+			created_at, last_seen_at, expires_at)
+		VALUES(?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(token_hash) DO UPDATE SET username = excluded.username,
+			kid_username = excluded.kid_username, data = excluded.data, ip = excluded.ip,
+			user_agent = excluded.user_agent, last_seen_at = excluded.last_seen_at,
+			expires_at = excluded.expires_at`,
+		hash, nullString(s.Username), nullString(s.KidUsername), s.Data, nullString(s.IP),
+		nullString(s.UserAgent), now, now, s.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+// Touch records that the session was used just now, and from where.
+func (r *LoginSessionRepo) Touch(ctx context.Context, id int64, ip, userAgent string) error {
+	_, err := r.q.ExecContext(ctx,
+		"UPDATE login_sessions SET last_seen_at = ?, ip = ?, user_agent = ? WHERE id = ?",
+		time.Now().UTC(), nullString(ip), nullString(userAgent), id)
+	return err
+}
+
+// DeleteByHash ends the session stored under hash, e.g. on logout.
+func (r *LoginSessionRepo) DeleteByHash(ctx context.Context, hash string) error {
+	_, err := r.q.ExecContext(ctx, "DELETE FROM login_sessions WHERE token_hash = ?", hash)
+	return err
+}
+
+// Delete ends the session with the given ID, or returns ErrNotFound.
+func (r *LoginSessionRepo) Delete(ctx context.Context, id int64) error {
+	return mustAffect(r.q.ExecContext(ctx, "DELETE FROM login_sessions WHERE id = ?", id))
+}
+
+// DeleteForUser ends the account's sessions except the one stored under keepHash,
+// if any, and returns how many were ended.
+func (r *LoginSessionRepo) DeleteForUser(ctx context.Context, username, keepHash string) (int64, error) {
+	res, err := r.q.ExecContext(ctx,
+		"DELETE FROM login_sessions WHERE username = ? AND token_hash <> ?", username, keepHash)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteForKid ends all of the kid's sessions and returns how many were ended.
+func (r *LoginSessionRepo) DeleteForKid(ctx context.Context, kid string) (int64, error) {
+	res, err := r.q.ExecContext(ctx, "DELETE FROM login_sessions WHERE kid_username = ?", kid)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpired removes sessions that expired before now and returns how many.
+func (r *LoginSessionRepo) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.q.ExecContext(ctx, "DELETE FROM login_sessions WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// List returns the live logged-in sessions in scope, most recently used first: for
+// a guardian their own and their kids' sessions, otherwise every account's and kid's.
+func (r *LoginSessionRepo) List(ctx context.Context, scope Scope) ([]LoginSession, error) {
+	cond, args := "(username IS NOT NULL OR kid_username IS NOT NULL)", []any{}
+	if !scope.All() {
+		var kidArgs []any
+		cond, kidArgs = scope.kidFilter("kid_username")
+		cond = "(username = ? OR " + cond + ")"
+		args = append([]any{scope.Guardian}, kidArgs...)
+	}
+	args = append(args, time.Now().UTC())
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+loginSessionColumns+" FROM login_sessions WHERE "+cond+" AND "+liveSession+
+			" ORDER BY last_seen_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LoginSession
+	for rows.Next() {
+		s, err := scanLoginSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
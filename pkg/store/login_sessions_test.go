@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginSessionLiveness(t *testing.T) {
+	ctx := context.Background()
+	r, db := testRepos(t)
+	addKid(t, r, "bob", "ann")
+	addKid(t, r, "alice", "ann")
+	if _, err := r.Users.Create(ctx, User{Username: "gone", Role: "parent"}); err != nil {
+		t.Fatal(err)
+	}
+	later, earlier := time.Now().Add(time.Hour), time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		hash string
+		s    LoginSession
+		live bool
+	}{
+		{"account", LoginSession{Username: "ann", ExpiresAt: later}, true},
+		{"kid", LoginSession{KidUsername: "bob", ExpiresAt: later}, true},
+		{"expired", LoginSession{Username: "ann", ExpiresAt: earlier}, false},
+		{"deleted account", LoginSession{Username: "gone", ExpiresAt: later}, false},
+		{"deleted kid", LoginSession{KidUsername: "alice", ExpiresAt: later}, false},
+	}
+	for _, tt := range tests {
+		tt.s.UserAgent = tt.hash // tells the listed sessions apart
+		if err := r.LoginSessions.Save(ctx, tt.hash, tt.s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE username = 'gone'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM kids WHERE username = 'alice'"); err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := r.LoginSessions.List(ctx, Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			_, err := r.LoginSessions.GetByHash(ctx, tt.hash)
+			if tt.live && err != nil {
+				t.Errorf("GetByHash: %v", err)
+			} else if !tt.live && !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetByHash error = %v, want ErrNotFound", err)
+			}
+			inList := false
+			for _, s := range listed {
+				inList = inList || s.UserAgent == tt.hash
+			}
+			if inList != tt.live {
+				t.Errorf("listed = %v, want %v", inList, tt.live)
+			}
+		})
+	}
+
+	n, err := r.LoginSessions.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired removed %d sessions, want 1", n)
+	}
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// UserTOTP is an account's TOTP key. Enrollment is pending until EnabledAt is set.
+type UserTOTP struct {
+	Username  string
+	Secret    string // base32 key
+	LastStep  int64  // last accepted time step
+	CreatedAt time.Time
+	EnabledAt *time.Time
+}
+
+// TOTPRepo reads and writes user_totp.
+type TOTPRepo struct{ q Querier }
+
+// Get returns the account's TOTP key, or ErrNotFound if it has none.
+func (r *TOTPRepo) Get(ctx context.Context, username string) (UserTOTP, error) {
+	var t UserTOTP
+	err := r.q.QueryRowContext(ctx,
+		"SELECT username, secret, last_step, created_at, enabled_at FROM user_totp WHERE username = ?", username,
+	).Scan(&t.Username, &t.Secret, &t.LastStep, &t.CreatedAt, &t.EnabledAt)
+	return t, notFound(err)
+}
+
+// Enabled returns the usernames of the accounts with TOTP on.
+func (r *TOTPRepo) Enabled(ctx context.Context) (map[string]bool, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT username FROM user_totp WHERE enabled_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]bool{}
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		out[u] = true
+	}
+	return out, rows.Err()
+}
+
+// Begin stores a new key for the account with enrollment pending, replacing any
+// earlier pending or enabled key.
+func (r *TOTPRepo) Begin(ctx context.Context, username, secret string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO user_totp(username, secret, created_at) VALUES(?,?,?)
+		ON CONFLICT(username) DO UPDATE SET secret = excluded.secret, last_step = 0,
+			created_at = excluded.created_at, enabled_at = NULL`,
+		username, secret, time.Now().UTC(),
+	)
+	return err
+}
+
+// Enable completes enrollment.
+func (r *TOTPRepo) Enable(ctx context.Context, username string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE user_totp SET enabled_at = ? WHERE username = ?", time.Now().UTC(), username))
+}
+
+// UseStep records a code from time step as used. It returns ErrNotFound if a code
+// from that step or a later one was already accepted.
+func (r *TOTPRepo) UseStep(ctx context.Context, username string, step int64) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE user_totp SET last_step = ? WHERE username = ? AND last_step < ?", step, username, step))
+}
+
+// Delete removes the account's key, turning TOTP off.
+func (r *TOTPRepo) Delete(ctx context.Context, username string) error {
+	return mustAffect(r.q.ExecContext(ctx, "DELETE FROM user_totp WHERE username = ?", username))
+}
+
+// RecoveryCodeRepo reads and writes recovery_codes.
+type RecoveryCodeRepo struct{ q Querier }
+
+// Replace swaps the account's recovery codes for the given hashes. Run it in a
+// transaction.
+func (r *RecoveryCodeRepo) Replace(ctx context.Context, username string, hashes []string) error {
+	if _, err := r.q.ExecContext(ctx, "DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, h := range hashes {
+		if _, err := r.q.ExecContext(ctx,
+			"INSERT INTO recovery_codes(username, code_hash, created_at) VALUES(?,?,?)", username, h, now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Use marks the account's unused code with the given hash as used, or returns
+// ErrNotFound.
+func (r *RecoveryCodeRepo) Use(ctx context.Context, username, hash string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = ? WHERE username = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), username, hash))
+}
+
+// Remaining counts the account's unused codes.
+func (r *RecoveryCodeRepo) Remaining(ctx context.Context, username string) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM recovery_codes WHERE username = ? AND used_at IS NULL", username,
+	).Scan(&n)
+	return n, err
+}
+
+// DeleteAll removes the account's codes.
+func (r *RecoveryCodeRepo) DeleteAll(ctx context.Context, username string) error {
+	_, err := r.q.ExecContext(ctx, "DELETE FROM recovery_codes WHERE username = ?", username)
+	return err
+}
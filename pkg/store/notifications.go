@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Notification is a message for a parent or admin account.
+type Notification struct {
+	ID        int64
+	Recipient string
+	Kind      string // e.g. "kid_locked"
+	Message   string
+	CreatedAt time.Time
+}
+
+// NotificationRepo reads and writes notifications.
+type NotificationRepo struct{ q Querier }
+
+// Add stores a notification for recipient.
+func (r *NotificationRepo) Add(ctx context.Context, recipient, kind, message string) error {
+	_, err := r.q.ExecContext(ctx,
+		"INSERT INTO notifications(recipient, kind, message, created_at) VALUES(?,?,?,?)",
+		recipient, kind, message, time.Now().UTC())
+	return err
+}
+
+// Unread returns recipient's unread notifications, newest first.
+func (r *NotificationRepo) Unread(ctx context.Context, recipient string) ([]Notification, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT id, recipient, kind, message, created_at FROM notifications
+		WHERE recipient = ? AND read_at IS NULL
+		ORDER BY id DESC`, recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Recipient, &n.Kind, &n.Message, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// MarkRead dismisses one of recipient's notifications, or returns ErrNotFound.
+func (r *NotificationRepo) MarkRead(ctx context.Context, id int64, recipient string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE notifications SET read_at = ? WHERE id = ? AND recipient = ? AND read_at IS NULL",
+		time.Now().UTC(), id, recipient))
+}
@@ -0,0 +1,218 @@
+package store
+
+import "context"
+
+// Topic is an entry in the topic catalog.
+type Topic struct {
+	ID          int64
+	Name        string
+	Description string
+}
+
+// AgeBand supplies default rules to every kid whose age falls within it.
+type AgeBand struct {
+	ID     int64
+	Name   string
+	MinAge int
+	MaxAge int
+}
+
+// Rule is a stored topic rule. Validation and resolution live in package policy.
+type Rule struct {
+	ID       int64
+	Scope    string // "kid", "group" or "age_band"
+	ScopeKey string // kid username, group ID or age band ID
+	TopicID  int64
+	Topic    string
+	Action   string
+	Severity int
+}
+
+// LegacyPolicy is a row of the old comma-separated content_policies table.
+type LegacyPolicy struct {
+	Kid        string
+	Allowed    string
+	Restricted string
+}
+
+// PolicyRepo reads and writes the topic catalog, age bands and topic rules.
+type PolicyRepo struct{ q Querier }
+
+// ListTopics returns the topic catalog ordered by name.
+func (r *PolicyRepo) ListTopics(ctx context.Context) ([]Topic, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT id, name, description FROM topics ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Topic
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetTopic returns the topic with the given ID, or ErrNotFound.
+func (r *PolicyRepo) GetTopic(ctx context.Context, id int64) (Topic, error) {
+	t := Topic{ID: id}
+	err := r.q.QueryRowContext(ctx,
+		"SELECT name, description FROM topics WHERE id = ?", id,
+	).Scan(&t.Name, &t.Description)
+	return t, notFound(err)
+}
+
+// AddTopic inserts a topic unless its name already exists, and returns its ID either way.
+func (r *PolicyRepo) AddTopic(ctx context.Context, name, description string) (int64, error) {
+	if _, err := r.q.ExecContext(ctx,
+		"INSERT INTO topics(name, description) VALUES(?,?) ON CONFLICT(name) DO NOTHING", name, description,
+	); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := r.q.QueryRowContext(ctx, "SELECT id FROM topics WHERE name = ?", name).Scan(&id)
+	return id, err
+}
+
+// ListAgeBands returns age bands ordered by starting age.
+func (r *PolicyRepo) ListAgeBands(ctx context.Context) ([]AgeBand, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT id, name, min_age, max_age FROM age_bands ORDER BY min_age")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AgeBand
+	for rows.Next() {
+		var b AgeBand
+		if err := rows.Scan(&b.ID, &b.Name, &b.MinAge, &b.MaxAge); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// GetAgeBand returns the age band with the given ID, or ErrNotFound.
+func (r *PolicyRepo) GetAgeBand(ctx context.Context, id int64) (AgeBand, error) {
+	b := AgeBand{ID: id}
+	err := r.q.QueryRowContext(ctx,
+		"SELECT name, min_age, max_age FROM age_bands WHERE id = ?", id,
+	).Scan(&b.Name, &b.MinAge, &b.MaxAge)
+	return b, notFound(err)
+}
+
+// AddAgeBand inserts an age band and returns its ID.
+func (r *PolicyRepo) AddAgeBand(ctx context.Context, b AgeBand) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO age_bands(name, min_age, max_age) VALUES(?,?,?) RETURNING id", b.Name, b.MinAge, b.MaxAge,
+	).Scan(&id)
+	return id, err
+}
+
+const ruleColumns = `r.id, r.scope, r.scope_key, r.topic_id, t.name, r.action, r.severity`
+
+func (r *PolicyRepo) rules(ctx context.Context, query string, args ...any) ([]Rule, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Rule
+	for rows.Next() {
+		var x Rule
+		if err := rows.Scan(&x.ID, &x.Scope, &x.ScopeKey, &x.TopicID, &x.Topic, &x.Action, &x.Severity); err != nil {
+			return nil, err
+		}
+		out = append(out, x)
+	}
+	return out, rows.Err()
+}
+
+// ListRules returns every rule with its topic name.
+func (r *PolicyRepo) ListRules(ctx context.Context) ([]Rule, error) {
+	return r.rules(ctx, `
+		SELECT `+ruleColumns+`
+		FROM topic_rules r JOIN topics t ON t.id = r.topic_id
+		ORDER BY r.scope, r.scope_key, t.name`)
+}
+
+// GetRule returns a rule by ID, or ErrNotFound.
+func (r *PolicyRepo) GetRule(ctx context.Context, id int64) (Rule, error) {
+	rules, err := r.rules(ctx, `
+		SELECT `+ruleColumns+`
+		FROM topic_rules r JOIN topics t ON t.id = r.topic_id
+		WHERE r.id = ?`, id)
+	if err == nil && len(rules) == 0 {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return Rule{}, err
+	}
+	return rules[0], nil
+}
+
+// RulesFor returns the rules that can apply to a kid: their own, their groups'
+// and, when the age is known, the rules of age bands containing it.
+func (r *PolicyRepo) RulesFor(ctx context.Context, kid string, age int, ageKnown bool) ([]Rule, error) {
+	return r.rules(ctx, `
+		SELECT `+ruleColumns+`
+		FROM topic_rules r JOIN topics t ON t.id = r.topic_id
+		WHERE (r.scope = 'kid' AND r.scope_key = ?)
+		   OR (r.scope = 'group' AND r.scope_key IN (
+		         SELECT CAST(group_id AS TEXT) FROM group_members WHERE username = ?))
+		   OR (r.scope = 'age_band' AND ? AND r.scope_key IN (
+		         SELECT CAST(id AS TEXT) FROM age_bands WHERE ? BETWEEN min_age AND max_age))
+	`, kid, kid, ageKnown, age)
+}
+
+// SaveRule creates or replaces the rule for its scope and topic.
+func (r *PolicyRepo) SaveRule(ctx context.Context, x Rule) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO topic_rules(scope, scope_key, topic_id, action, severity) VALUES(?,?,?,?,?)
+		ON CONFLICT(scope, scope_key, topic_id) DO UPDATE SET action = excluded.action, severity = excluded.severity
+	`, x.Scope, x.ScopeKey, x.TopicID, x.Action, x.Severity)
+	return err
+}
+
+// AddRuleIfMissing inserts the rule unless one already exists for its scope and topic.
+func (r *PolicyRepo) AddRuleIfMissing(ctx context.Context, x Rule) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO topic_rules(scope, scope_key, topic_id, action, severity) VALUES(?,?,?,?,?)
+		ON CONFLICT(scope, scope_key, topic_id) DO NOTHING
+	`, x.Scope, x.ScopeKey, x.TopicID, x.Action, x.Severity)
+	return err
+}
+
+// DeleteRule removes a rule by ID, or returns ErrNotFound.
+func (r *PolicyRepo) DeleteRule(ctx context.Context, id int64) error {
+	return mustAffect(r.q.ExecContext(ctx, "DELETE FROM topic_rules WHERE id = ?", id))
+}
+
+// LegacyPolicies returns the rows left in content_policies.
+func (r *PolicyRepo) LegacyPolicies(ctx context.Context) ([]LegacyPolicy, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT kid_username, COALESCE(allowed, ''), COALESCE(restricted, '') FROM content_policies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LegacyPolicy
+	for rows.Next() {
+		var l LegacyPolicy
+		if err := rows.Scan(&l.Kid, &l.Allowed, &l.Restricted); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// DeleteLegacyPolicy removes a kid's content_policies row.
+func (r *PolicyRepo) DeleteLegacyPolicy(ctx context.Context, kid string) error {
+	_, err := r.q.ExecContext(ctx, "DELETE FROM content_policies WHERE kid_username = ?", kid)
+	return err
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Prompt request states.
+const (
+	RequestPending  = "pending"  // waiting for a parent
+	RequestApproved = "approved" // approved, answer not generated yet
+	RequestDenied   = "denied"   // a parent said no
+	RequestAnswered = "answered" // the answer is stored and visible to the kid
+	RequestExpired  = "expired"  // nobody decided in time
+	RequestFailed   = "failed"   // generating the answer failed; may be approved again
+)
+
+// requestTransitions lists the states each target state may be entered from.
+var requestTransitions = map[string][]string{
+	RequestApproved: {RequestPending, RequestFailed},
+	RequestDenied:   {RequestPending},
+	RequestExpired:  {RequestPending},
+	RequestAnswered: {RequestApproved},
+	RequestFailed:   {RequestApproved},
+}
+
+// ErrInvalidTransition is returned when a request is not in a state that allows the change.
+var ErrInvalidTransition = errors.New("invalid request state transition")
+
+// PromptRequest is a kid's question and its way through parent approval.
+type PromptRequest struct {
+	ID         int64
+	Username   string
+	Prompt     string
+	Status     string
+	Reviewer   string
+	Reason     string
+	Answer     string
+	Error      string
+	CreatedAt  time.Time
+	ApprovedAt *time.Time
+	DeniedAt   *time.Time
+	AnsweredAt *time.Time
+	FailedAt   *time.Time
+	ExpiredAt  *time.Time
+}
+
+// Decided reports whether a parent has approved or denied the request.
+func (p PromptRequest) Decided() bool {
+	return p.Status != RequestPending && p.Status != RequestExpired
+}
+
+// PromptRequestRepo reads and writes prompt_requests.
+type PromptRequestRepo struct{ q Querier }
+
+const requestColumns = `id, kid_username, prompt, status, COALESCE(reviewer, ''), COALESCE(reason, ''),
+	COALESCE(answer, ''), COALESCE(error, ''), created_at,
+	approved_at, denied_at, answered_at, failed_at, expired_at`
+
+type scanner interface{ Scan(dest ...any) error }
+
+func scanRequest(row scanner) (PromptRequest, error) {
+	var p PromptRequest
+	err := row.Scan(&p.ID, &p.Username, &p.Prompt, &p.Status, &p.Reviewer, &p.Reason,
+		&p.Answer, &p.Error, &p.CreatedAt,
+		&p.ApprovedAt, &p.DeniedAt, &p.AnsweredAt, &p.FailedAt, &p.ExpiredAt)
+	return p, err
+}
+
+// Create stores a new pending request and returns its ID.
+func (r *PromptRequestRepo) Create(ctx context.Context, kid, prompt string) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx,
+		"INSERT INTO prompt_requests(kid_username, prompt, status, created_at) VALUES(?,?,?,?) RETURNING id",
+		kid, prompt, RequestPending, time.Now().UTC(),
+	).Scan(&id)
+	return id, err
+}
+
+// Get returns the request with the given ID, or ErrNotFound.
+func (r *PromptRequestRepo) Get(ctx context.Context, id int64) (PromptRequest, error) {
+	p, err := scanRequest(r.q.QueryRowContext(ctx,
+		"SELECT "+requestColumns+" FROM prompt_requests WHERE id = ?", id))
+	return p, notFound(err)
+}
+
+// List returns the requests of kids in scope, newest first.
+func (r *PromptRequestRepo) List(ctx context.Context, scope Scope) ([]PromptRequest, error) {
+	cond, args := scope.kidFilter("kid_username")
+	return r.list(ctx, "SELECT "+requestColumns+" FROM prompt_requests WHERE "+cond+" ORDER BY created_at DESC", args...)
+}
+
+// ListByKid returns up to limit of kid's requests, newest first.
+func (r *PromptRequestRepo) ListByKid(ctx context.Context, kid string, limit int) ([]PromptRequest, error) {
+	return r.list(ctx, "SELECT "+requestColumns+" FROM prompt_requests WHERE kid_username = ? ORDER BY created_at DESC, id DESC LIMIT ?",
+		kid, limit)
+}
+
+func (r *PromptRequestRepo) list(ctx context.Context, query string, args ...any) ([]PromptRequest, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PromptRequest
+	for rows.Next() {
+		p, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// Approve moves a pending (or failed) request to approved on behalf of reviewer.
+func (r *PromptRequestRepo) Approve(ctx context.Context, id int64, reviewer, reason string) error {
+	return r.transition(ctx, id, RequestApproved,
+		"reviewer = ?, reason = ?, error = NULL, approved_at = CURRENT_TIMESTAMP", reviewer, reason)
+}
+
+// Deny moves a pending request to denied on behalf of reviewer.
+func (r *PromptRequestRepo) Deny(ctx context.Context, id int64, reviewer, reason string) error {
+	return r.transition(ctx, id, RequestDenied,
+		"reviewer = ?, reason = ?, denied_at = CURRENT_TIMESTAMP", reviewer, reason)
+}
+
+// Answer stores the generated answer for an approved request.
+func (r *PromptRequestRepo) Answer(ctx context.Context, id int64, answer string) error {
+	return r.transition(ctx, id, RequestAnswered, "answer = ?, answered_at = CURRENT_TIMESTAMP", answer)
+}
+
+// Fail records why generating the answer for an approved request failed.
+func (r *PromptRequestRepo) Fail(ctx context.Context, id int64, reason string) error {
+	return r.transition(ctx, id, RequestFailed, "error = ?, failed_at = CURRENT_TIMESTAMP", reason)
+}
+
+// ExpirePending expires pending requests created before cutoff and returns how many were expired.
+func (r *PromptRequestRepo) ExpirePending(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := r.q.ExecContext(ctx,
+		"UPDATE prompt_requests SET status = ?, expired_at = CURRENT_TIMESTAMP WHERE status = ? AND created_at < ?",
+		RequestExpired, RequestPending, cutoff.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// transition sets status to `to` plus the extra assignments in set, but only if the
+// request is currently in a state that may move to `to`. It returns ErrNotFound for
+// an unknown request and ErrInvalidTransition otherwise.
+func (r *PromptRequestRepo) transition(ctx context.Context, id int64, to, set string, args ...any) error {
+	from := requestTransitions[to]
+	query := fmt.Sprintf("UPDATE prompt_requests SET status = ?, %s WHERE id = ? AND status IN (?%s)",
+		set, strings.Repeat(",?", len(from)-1))
+
+	params := append([]any{to}, args...)
+	params = append(params, id)
+	for _, s := range from {
+		params = append(params, s)
+	}
+	err := mustAffect(r.q.ExecContext(ctx, query, params...))
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	cur, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: request %d is %s, cannot become %s", ErrInvalidTransition, id, cur.Status, to)
+}
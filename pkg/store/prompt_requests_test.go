@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromptRequestTransitions(t *testing.T) {
+	ctx := context.Background()
+	r, _ := testRepos(t)
+	steps := map[string]func(id int64) error{
+		"approve": func(id int64) error { return r.PromptRequests.Approve(ctx, id, "ann", "") },
+		"deny":    func(id int64) error { return r.PromptRequests.Deny(ctx, id, "ann", "not now") },
+		"answer":  func(id int64) error { return r.PromptRequests.Answer(ctx, id, "42") },
+		"fail":    func(id int64) error { return r.PromptRequests.Fail(ctx, id, "provider down") },
+		"expire": func(id int64) error {
+			_, err := r.PromptRequests.ExpirePending(ctx, time.Now().Add(time.Hour))
+			return err
+		},
+	}
+
+	tests := []struct {
+		before  []string // steps that bring the request into its state
+		step    string
+		want    string // status afterwards
+		wantErr error
+	}{
+		{step: "approve", want: RequestApproved},
+		{step: "deny", want: RequestDenied},
+		{step: "expire", want: RequestExpired},
+		{step: "answer", want: RequestPending, wantErr: ErrInvalidTransition},
+		{step: "fail", want: RequestPending, wantErr: ErrInvalidTransition},
+		{before: []string{"approve"}, step: "answer", want: RequestAnswered},
+		{before: []string{"approve"}, step: "fail", want: RequestFailed},
+		{before: []string{"approve"}, step: "approve", want: RequestApproved, wantErr: ErrInvalidTransition},
+		{before: []string{"approve"}, step: "deny", want: RequestApproved, wantErr: ErrInvalidTransition},
+		{before: []string{"approve", "fail"}, step: "approve", want: RequestApproved},
+		{before: []string{"approve", "fail"}, step: "deny", want: RequestFailed, wantErr: ErrInvalidTransition},
+		{before: []string{"approve", "answer"}, step: "fail", want: RequestAnswered, wantErr: ErrInvalidTransition},
+		{before: []string{"deny"}, step: "approve", want: RequestDenied, wantErr: ErrInvalidTransition},
+		{before: []string{"expire"}, step: "approve", want: RequestExpired, wantErr: ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		name := tt.step
+		for i := len(tt.before) - 1; i >= 0; i-- {
+			name = tt.before[i] + "/" + name
+		}
+		t.Run(name, func(t *testing.T) {
+			id, err := r.PromptRequests.Create(ctx, "bob", "why is the sky blue?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.before {
+				if err := steps[s](id); err != nil {
+					t.Fatalf("%s: %v", s, err)
+				}
+			}
+			if err := steps[tt.step](id); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s error = %v, want %v", tt.step, err, tt.wantErr)
+			}
+			got, err := r.PromptRequests.Get(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptRequestTransitionOfUnknownRequest(t *testing.T) {
+	r, _ := testRepos(t)
+	if err := r.PromptRequests.Approve(context.Background(), 99, "ann", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Approve error = %v, want ErrNotFound", err)
+	}
+}
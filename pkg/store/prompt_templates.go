@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultBand is the age band ID of the template for kids whose band has none.
+const DefaultBand = 0
+
+// PromptTemplate is one version of an age band's system prompt template.
+type PromptTemplate struct {
+	ID        int64
+	AgeBandID int64 // DefaultBand for the default template
+	Version   int
+	Body      string // Go text/template; see pkg/prompts
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// PromptTemplateRepo reads and writes prompt_templates.
+type PromptTemplateRepo struct{ q Querier }
+
+const promptTemplateColumns = `id, age_band_id, version, body, COALESCE(created_by, ''), created_at`
+
+func (r *PromptTemplateRepo) list(ctx context.Context, query string, args ...any) ([]PromptTemplate, error) {
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PromptTemplate
+	for rows.Next() {
+		var t PromptTemplate
+		if err := rows.Scan(&t.ID, &t.AgeBandID, &t.Version, &t.Body, &t.CreatedBy, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// Current returns the templates in use, one per age band that has any.
+func (r *PromptTemplateRepo) Current(ctx context.Context) ([]PromptTemplate, error) {
+	return r.list(ctx, `
+		SELECT `+promptTemplateColumns+` FROM prompt_templates t
+		WHERE version = (SELECT MAX(version) FROM prompt_templates WHERE age_band_id = t.age_band_id)
+		ORDER BY age_band_id`)
+}
+
+// History returns every version of an age band's template, newest first.
+func (r *PromptTemplateRepo) History(ctx context.Context, band int64) ([]PromptTemplate, error) {
+	return r.list(ctx,
+		"SELECT "+promptTemplateColumns+" FROM prompt_templates WHERE age_band_id = ? ORDER BY version DESC", band)
+}
+
+// Add saves body as the next version of an age band's template and returns the
+// version.
+func (r *PromptTemplateRepo) Add(ctx context.Context, band int64, body, createdBy string) (int, error) {
+	var version int
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO prompt_templates(age_band_id, version, body, created_by, created_at)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ? FROM prompt_templates WHERE age_band_id = ?
+		RETURNING version`,
+		band, body, nullString(createdBy), time.Now().UTC(), band,
+	).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimitState is what a rate limiting algorithm keeps for one bucket.
+type RateLimitState struct {
+	Value float64
+	Prev  float64
+	Stamp int64 // unix nanoseconds
+}
+
+// RateLimitRepo reads and writes rate_limits.
+type RateLimitRepo struct{ q Querier }
+
+// Lock returns the bucket's state, creating it empty, and keeps the row locked
+// until the transaction ends. Expired state reads as empty. Use it inside InTx.
+func (r *RateLimitRepo) Lock(ctx context.Context, bucket string) (RateLimitState, error) {
+	var s RateLimitState
+	var expires time.Time
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO rate_limits(bucket, value, prev, stamp, expires_at) VALUES(?,0,0,0,?)
+		ON CONFLICT(bucket) DO UPDATE SET bucket = excluded.bucket
+		RETURNING value, prev, stamp, expires_at`,
+		bucket, time.Now().UTC(),
+	).Scan(&s.Value, &s.Prev, &s.Stamp, &expires)
+	if err == nil && !expires.After(time.Now()) {
+		s = RateLimitState{}
+	}
+	return s, err
+}
+
+// Save stores the bucket's state until expires.
+func (r *RateLimitRepo) Save(ctx context.Context, bucket string, s RateLimitState, expires time.Time) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE rate_limits SET value = ?, prev = ?, stamp = ?, expires_at = ? WHERE bucket = ?",
+		s.Value, s.Prev, s.Stamp, expires.UTC(), bucket))
+}
+
+// DeleteExpired removes buckets whose state has expired and returns how many.
+func (r *RateLimitRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := r.q.ExecContext(ctx, "DELETE FROM rate_limits WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
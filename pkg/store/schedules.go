@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// KidSchedule says when a kid may use the AI. Windows are lists like
+// "07:00-08:00, 15:30-20:00", or "none"; see pkg/schedule.
+type KidSchedule struct {
+	KidUsername  string
+	Timezone     string // IANA name; empty for the server's
+	Weekdays     string // allowed windows Monday to Friday
+	Weekends     string // allowed windows on Saturday and Sunday
+	DailyMinutes int    // zero is no limit
+	ExtraUntil   *time.Time
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
+
+// KidScheduleRepo reads and writes kid_schedules.
+type KidScheduleRepo struct{ q Querier }
+
+const kidScheduleColumns = `kid_username, timezone, weekdays, weekends, COALESCE(daily_minutes, 0),
+	extra_until, COALESCE(updated_by, ''), updated_at`
+
+func scanKidSchedule(row scanner) (KidSchedule, error) {
+	var s KidSchedule
+	err := row.Scan(&s.KidUsername, &s.Timezone, &s.Weekdays, &s.Weekends, &s.DailyMinutes,
+		&s.ExtraUntil, &s.UpdatedBy, &s.UpdatedAt)
+	return s, err
+}
+
+// Get returns kid's schedule, or ErrNotFound if kid may use the AI at any time.
+func (r *KidScheduleRepo) Get(ctx context.Context, kid string) (KidSchedule, error) {
+	s, err := scanKidSchedule(r.q.QueryRowContext(ctx,
+		"SELECT "+kidScheduleColumns+" FROM kid_schedules WHERE kid_username = ?", kid))
+	return s, notFound(err)
+}
+
+// List returns the schedules of the kids in scope.
+func (r *KidScheduleRepo) List(ctx context.Context, scope Scope) ([]KidSchedule, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+kidScheduleColumns+" FROM kid_schedules WHERE "+cond+" ORDER BY kid_username", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []KidSchedule
+	for rows.Next() {
+		s, err := scanKidSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Save creates or replaces kid's schedule. Extra time already granted stays.
+func (r *KidScheduleRepo) Save(ctx context.Context, s KidSchedule, updatedBy string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO kid_schedules(kid_username, timezone, weekdays, weekends, daily_minutes,
+			updated_by, updated_at)
+		VALUES(?,?,?,?,?,?,?)
+		ON CONFLICT(kid_username) DO UPDATE SET timezone = excluded.timezone,
+			weekdays = excluded.weekdays, weekends = excluded.weekends,
+			daily_minutes = excluded.daily_minutes, updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at`,
+		s.KidUsername, s.Timezone, s.Weekdays, s.Weekends, nullInt(int64(s.DailyMinutes)),
+		nullString(updatedBy), time.Now().UTC(),
+	)
+	return err
+}
+
+// Delete removes kid's schedule, or returns ErrNotFound.
+func (r *KidScheduleRepo) Delete(ctx context.Context, kid string) error {
+	return mustAffect(r.q.ExecContext(ctx, "DELETE FROM kid_schedules WHERE kid_username = ?", kid))
+}
+
+// GrantExtraTime lets kid use the AI until the given time whatever the schedule
+// says. It returns ErrNotFound if kid has no schedule.
+func (r *KidScheduleRepo) GrantExtraTime(ctx context.Context, kid string, until time.Time) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE kid_schedules SET extra_until = ? WHERE kid_username = ?", until.UTC(), kid))
+}
+
+// ScheduleOverride replaces a kid's weekly schedule on one date.
+type ScheduleOverride struct {
+	KidUsername string
+	Day         string // YYYY-MM-DD in the kid's time zone
+	Windows     string
+	Note        string // e.g. "Christmas"
+	CreatedBy   string
+	CreatedAt   time.Time
+}
+
+// ScheduleOverrideRepo reads and writes schedule_overrides.
+type ScheduleOverrideRepo struct{ q Querier }
+
+// List returns the overrides of the kids in scope from the given day on, by kid and
+// date.
+func (r *ScheduleOverrideRepo) List(ctx context.Context, scope Scope, from string) ([]ScheduleOverride, error) {
+	cond, args := scope.kidFilter("kid_username")
+	return r.list(ctx, "day >= ? AND "+cond, append([]any{from}, args...)...)
+}
+
+// For returns kid's overrides from the given day on, by date.
+func (r *ScheduleOverrideRepo) For(ctx context.Context, kid, from string) ([]ScheduleOverride, error) {
+	return r.list(ctx, "kid_username = ? AND day >= ?", kid, from)
+}
+
+func (r *ScheduleOverrideRepo) list(ctx context.Context, cond string, args ...any) ([]ScheduleOverride, error) {
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT kid_username, day, windows, COALESCE(note, ''), COALESCE(created_by, ''), created_at
+		FROM schedule_overrides WHERE `+cond+` ORDER BY kid_username, day`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ScheduleOverride
+	for rows.Next() {
+		var o ScheduleOverride
+		if err := rows.Scan(&o.KidUsername, &o.Day, &o.Windows, &o.Note, &o.CreatedBy, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Set creates or replaces the override of a kid's day.
+func (r *ScheduleOverrideRepo) Set(ctx context.Context, o ScheduleOverride, createdBy string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO schedule_overrides(kid_username, day, windows, note, created_by, created_at)
+		VALUES(?,?,?,?,?,?)
+		ON CONFLICT(kid_username, day) DO UPDATE SET windows = excluded.windows,
+			note = excluded.note, created_by = excluded.created_by, created_at = excluded.created_at`,
+		o.KidUsername, o.Day, o.Windows, nullString(o.Note), nullString(createdBy), time.Now().UTC(),
+	)
+	return err
+}
+
+// Delete removes the override of a kid's day, or returns ErrNotFound.
+func (r *ScheduleOverrideRepo) Delete(ctx context.Context, kid, day string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"DELETE FROM schedule_overrides WHERE kid_username = ? AND day = ?", kid, day))
+}
+
+// ScreenTimeRepo reads and writes screen_time.
+type ScreenTimeRepo struct{ q Querier }
+
+// Touch records that kid was active at now and returns kid's screen time on day so
+// far. The time since kid's last activity counts up to idle: a longer gap counts
+// as idle, so that spacing requests out does not make them free.
+func (r *ScreenTimeRepo) Touch(ctx context.Context, kid, day string, now time.Time, idle time.Duration) (time.Duration, error) {
+	ts := now.Unix()
+	var seconds int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO screen_time(kid_username, day, seconds, last_seen) VALUES(?,?,0,?)
+		ON CONFLICT(kid_username, day) DO UPDATE SET
+			seconds = screen_time.seconds + CASE
+				WHEN excluded.last_seen <= screen_time.last_seen THEN 0
+				WHEN excluded.last_seen - screen_time.last_seen < ? THEN excluded.last_seen - screen_time.last_seen
+				ELSE ? END,
+			last_seen = CASE WHEN excluded.last_seen > screen_time.last_seen
+				THEN excluded.last_seen ELSE screen_time.last_seen END
+		RETURNING seconds`,
+		kid, day, ts, int64(idle/time.Second), int64(idle/time.Second),
+	).Scan(&seconds)
+	return time.Duration(seconds) * time.Second, err
+}
+
+// Get returns kid's screen time on day.
+func (r *ScreenTimeRepo) Get(ctx context.Context, kid, day string) (time.Duration, error) {
+	var seconds int64
+	err := r.q.QueryRowContext(ctx,
+		"SELECT seconds FROM screen_time WHERE kid_username = ? AND day = ?", kid, day).Scan(&seconds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return time.Duration(seconds) * time.Second, err
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScreenTimeTouch(t *testing.T) {
+	r, _ := testRepos(t)
+	ctx := context.Background()
+	addKid(t, r, "bob", "mum")
+	start := time.Date(2026, 3, 4, 16, 0, 0, 0, time.UTC)
+	idle := 5 * time.Minute
+
+	steps := []struct {
+		after time.Duration // since start
+		want  time.Duration
+	}{
+		{0, 0},
+		{2 * time.Minute, 2 * time.Minute},
+		{time.Minute, 2 * time.Minute}, // out of order
+		{3 * time.Minute, 3 * time.Minute},
+		{time.Hour, 8 * time.Minute}, // a long gap counts as idle
+		{time.Hour + 4*time.Minute, 12 * time.Minute},
+	}
+	for _, s := range steps {
+		got, err := r.ScreenTime.Touch(ctx, "bob", "2026-03-04", start.Add(s.after), idle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != s.want {
+			t.Errorf("Touch at +%v = %v, want %v", s.after, got, s.want)
+		}
+	}
+	if got, err := r.ScreenTime.Get(ctx, "bob", "2026-03-04"); err != nil || got != 12*time.Minute {
+		t.Errorf("Get = %v, %v, want 12m", got, err)
+	}
+}
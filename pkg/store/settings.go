@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// SettingRepo reads and writes settings, runtime options admins can change.
+type SettingRepo struct{ q Querier }
+
+// Get returns the setting's value, or ErrNotFound if it was never set.
+func (r *SettingRepo) Get(ctx context.Context, key string) (string, error) {
+	var v string
+	err := r.q.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = ?", key).Scan(&v)
+	return v, notFound(err)
+}
+
+// Set stores the setting's value.
+func (r *SettingRepo) Set(ctx context.Context, key, value, updatedBy string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO settings(key, value, updated_by, updated_at) VALUES(?,?,?,?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at`,
+		key, value, nullString(updatedBy), time.Now().UTC(),
+	)
+	return err
+}
@@ -0,0 +1,144 @@
+// Package store holds typed repositories over the application database. Handlers
+// and services go through these instead of writing SQL inline.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches no row.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when creating something that already exists.
+	ErrDuplicate = errors.New("already exists")
+)
+
+// Querier is the subset of *database.Store and *database.Tx that repositories use.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Repos groups every repository over one connection or transaction.
+type Repos struct {
+	Kids              *KidRepo
+	Groups            *GroupRepo
+	Policies          *PolicyRepo
+	PromptRequests    *PromptRequestRepo
+	Jobs              *JobRepo
+	ChatSessions      *ChatSessionRepo
+	ChatMessages      *ChatMessageRepo
+	Audit             *AuditRepo
+	APITokens         *APITokenRepo
+	Users             *UserRepo
+	Guardians         *GuardianRepo
+	KidCredentials    *KidCredentialRepo
+	KidLoginCodes     *KidLoginCodeRepo
+	Notifications     *NotificationRepo
+	LoginSessions     *LoginSessionRepo
+	TOTP              *TOTPRepo
+	RecoveryCodes     *RecoveryCodeRepo
+	Settings          *SettingRepo
+	Identities        *IdentityRepo
+	RateLimits        *RateLimitRepo
+	Usage             *UsageRepo
+	UsageBudgets      *UsageBudgetRepo
+	Schedules         *KidScheduleRepo
+	ScheduleOverrides *ScheduleOverrideRepo
+	ScreenTime        *ScreenTimeRepo
+	PromptTemplates   *PromptTemplateRepo
+
+	db *database.Store // nil when the repos are bound to a transaction
+}
+
+// Default is bound to database.DB by Init.
+var Default Repos
+
+// Init binds Default to the opened database.
+func Init(db *database.Store) {
+	Default = New(db)
+}
+
+// New returns repositories that run against db.
+func New(db *database.Store) Repos {
+	r := bind(db, db.Dialect)
+	r.db = db
+	return r
+}
+
+func bind(q Querier, d database.Dialect) Repos {
+	return Repos{
+		Kids:              &KidRepo{q: q},
+		Groups:            &GroupRepo{q: q},
+		Policies:          &PolicyRepo{q: q},
+		PromptRequests:    &PromptRequestRepo{q: q},
+		Jobs:              &JobRepo{q: q},
+		ChatSessions:      &ChatSessionRepo{q: q},
+		ChatMessages:      &ChatMessageRepo{q: q},
+		Audit:             &AuditRepo{q: q, dialect: d},
+		APITokens:         &APITokenRepo{q: q},
+		Users:             &UserRepo{q: q},
+		Guardians:         &GuardianRepo{q: q},
+		KidCredentials:    &KidCredentialRepo{q: q},
+		KidLoginCodes:     &KidLoginCodeRepo{q: q},
+		Notifications:     &NotificationRepo{q: q},
+		LoginSessions:     &LoginSessionRepo{q: q},
+		TOTP:              &TOTPRepo{q: q},
+		RecoveryCodes:     &RecoveryCodeRepo{q: q},
+		Settings:          &SettingRepo{q: q},
+		Identities:        &IdentityRepo{q: q},
+		RateLimits:        &RateLimitRepo{q: q},
+		Usage:             &UsageRepo{q: q},
+		UsageBudgets:      &UsageBudgetRepo{q: q},
+		Schedules:         &KidScheduleRepo{q: q},
+		ScheduleOverrides: &ScheduleOverrideRepo{q: q},
+		ScreenTime:        &ScreenTimeRepo{q: q},
+		PromptTemplates:   &PromptTemplateRepo{q: q},
+	}
+}
+
+// InTx runs fn with repositories bound to a single transaction, committing if fn
+// returns nil and rolling back otherwise. Called on repos that are already inside
+// a transaction, fn simply joins it.
+func (r Repos) InTx(ctx context.Context, fn func(tx Repos) error) error {
+	if r.db == nil {
+		return fn(r)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(bind(tx, tx.Dialect)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// mustAffect returns ErrNotFound when res touched no rows.
+func mustAffect(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/schoolboylurk/data-sentinel/pkg/database"
+	"github.com/schoolboylurk/data-sentinel/pkg/database/dbtest"
+)
+
+// testRepos returns repositories on a freshly migrated SQLite database, along with
+// the database for setting up rows the repositories cannot write.
+func testRepos(t *testing.T) (Repos, *database.Store) {
+	t.Helper()
+	db := dbtest.Migrate(t, dbtest.SQLite(t))
+	return New(db), db
+}
+
+// testPostgres returns repositories on a new, migrated schema of the PostgreSQL
+// database at TEST_DATABASE_URL. It skips the test when the variable is unset.
+func testPostgres(t *testing.T) Repos {
+	t.Helper()
+	return New(dbtest.Migrate(t, dbtest.Postgres(t)))
+}
+
+// addKid creates kid with the given guardians, creating their accounts as needed.
+func addKid(t *testing.T, r Repos, kid string, guardians ...string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := r.Kids.Save(ctx, Kid{Username: kid, Age: 9}); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range guardians {
+		if _, err := r.Users.Get(ctx, g); err != nil {
+			if _, err := r.Users.Create(ctx, User{Username: g, Role: "parent"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Guardians.Add(ctx, kid, g, "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
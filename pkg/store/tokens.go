@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// APIToken is a bearer token for programmatic access. The token itself is never
+// stored, only its hash.
+type APIToken struct {
+	ID         int64
+	Name       string
+	Username   string   // principal the token acts as
+	Prefix     string   // first characters of the token, for telling tokens apart
+	Scopes     []string // Permit actions the token may be used for
+	CreatedBy  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Active reports whether the token is neither revoked nor expired at now.
+func (t APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// APITokenRepo reads and writes api_tokens.
+type APITokenRepo struct{ q Querier }
+
+const tokenColumns = `id, name, username, prefix, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func scanToken(row scanner) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	err := row.Scan(&t.ID, &t.Name, &t.Username, &t.Prefix, &scopes, &t.CreatedBy, &t.CreatedAt, &t.ExpiresAt,
+		&t.LastUsedAt, &t.RevokedAt)
+	t.Scopes = strings.Fields(scopes)
+	return t, err
+}
+
+// Create stores a token under the hash of its secret and returns its ID.
+func (r *APITokenRepo) Create(ctx context.Context, t APIToken, hash string) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO api_tokens(name, username, token_hash, prefix, scopes, created_by, created_at, expires_at)
+		VALUES(?,?,?,?,?,?,?,?) RETURNING id`,
+		t.Name, t.Username, hash, t.Prefix, strings.Join(t.Scopes, " "), t.CreatedBy,
+		time.Now().UTC(), t.ExpiresAt.UTC(),
+	).Scan(&id)
+	return id, err
+}
+
+// GetByHash returns the token with the given hash, or ErrNotFound.
+func (r *APITokenRepo) GetByHash(ctx context.Context, hash string) (APIToken, error) {
+	t, err := scanToken(r.q.QueryRowContext(ctx,
+		"SELECT "+tokenColumns+" FROM api_tokens WHERE token_hash = ?", hash))
+	return t, notFound(err)
+}
+
+// List returns every token, newest first.
+func (r *APITokenRepo) List(ctx context.Context) ([]APIToken, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT "+tokenColumns+" FROM api_tokens ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []APIToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// Revoke revokes a token, or returns ErrNotFound if there is no such unrevoked token.
+func (r *APITokenRepo) Revoke(ctx context.Context, id int64) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id))
+}
+
+// Touch records that the token was just used.
+func (r *APITokenRepo) Touch(ctx context.Context, id int64) error {
+	_, err := r.q.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return err
+}
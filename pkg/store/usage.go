@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Periods a usage budget covers.
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// Household is the kid username of budgets on everyone's usage together.
+const Household = ""
+
+// Sources of calls that count towards token budgets but are not messages.
+const (
+	SourceSummary    = "summary"    // folding old chat turns into a summary
+	SourceClassifier = "classifier" // checking a prompt against restricted topics
+)
+
+// UsageRecord is one AI provider call.
+type UsageRecord struct {
+	ID               int64
+	KidUsername      string // the kid the call was made for, if any
+	Username         string // who made the call
+	Source           string // chat, request, report, summary, ...
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64 // estimated
+	CreatedAt        time.Time
+}
+
+// UsageTotals adds up usage records.
+type UsageTotals struct {
+	Tokens   int64
+	Messages int64 // calls other than summaries and classifier checks
+	Cost     float64
+}
+
+// UsageRepo reads and writes ai_usage.
+type UsageRepo struct{ q Querier }
+
+const usageTotalsColumns = `COALESCE(SUM(prompt_tokens + completion_tokens), 0),
+	COALESCE(SUM(CASE WHEN source NOT IN ('` + SourceSummary + `', '` + SourceClassifier + `') THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(cost), 0)`
+
+// Add records a provider call.
+func (r *UsageRepo) Add(ctx context.Context, u UsageRecord) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO ai_usage(kid_username, username, source, model, prompt_tokens,
+			completion_tokens, cost, created_at)
+		VALUES(?,?,?,?,?,?,?,?)`,
+		nullString(u.KidUsername), nullString(u.Username), u.Source, u.Model,
+		u.PromptTokens, u.CompletionTokens, u.Cost, time.Now().UTC(),
+	)
+	return err
+}
+
+// Totals adds up kid's usage since the given time, or everyone's for Household.
+func (r *UsageRepo) Totals(ctx context.Context, kid string, since time.Time) (UsageTotals, error) {
+	query := "SELECT " + usageTotalsColumns + " FROM ai_usage WHERE created_at >= ?"
+	args := []any{since.UTC()}
+	if kid != Household {
+		query += " AND kid_username = ?"
+		args = append(args, kid)
+	}
+	var t UsageTotals
+	err := r.q.QueryRowContext(ctx, query, args...).Scan(&t.Tokens, &t.Messages, &t.Cost)
+	return t, err
+}
+
+// TotalsByKid adds up the usage since the given time of each kid in scope that has
+// any.
+func (r *UsageRepo) TotalsByKid(ctx context.Context, scope Scope, since time.Time) (map[string]UsageTotals, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT kid_username, `+usageTotalsColumns+` FROM ai_usage
+		WHERE kid_username IS NOT NULL AND created_at >= ? AND `+cond+`
+		GROUP BY kid_username`, append([]any{since.UTC()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]UsageTotals{}
+	for rows.Next() {
+		var kid string
+		var t UsageTotals
+		if err := rows.Scan(&kid, &t.Tokens, &t.Messages, &t.Cost); err != nil {
+			return nil, err
+		}
+		out[kid] = t
+	}
+	return out, rows.Err()
+}
+
+// Recent returns the latest provider calls made for kids in scope, newest first.
+// Admins also see calls made for no kid.
+func (r *UsageRepo) Recent(ctx context.Context, scope Scope, limit int) ([]UsageRecord, error) {
+	cond, args := scope.kidFilter("kid_username")
+	rows, err := r.q.QueryContext(ctx, `
+		SELECT id, COALESCE(kid_username, ''), COALESCE(username, ''), source, model,
+			prompt_tokens, completion_tokens, cost, created_at
+		FROM ai_usage WHERE `+cond+`
+		ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UsageRecord
+	for rows.Next() {
+		var u UsageRecord
+		if err := rows.Scan(&u.ID, &u.KidUsername, &u.Username, &u.Source, &u.Model,
+			&u.PromptTokens, &u.CompletionTokens, &u.Cost, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// UsageBudget limits a kid's AI usage, or the household's, per period. Zero
+// maximums are no limit. Parents are warned once usage reaches WarnPercent.
+type UsageBudget struct {
+	KidUsername string // Household for the household cap
+	Period      string
+	MaxTokens   int64
+	MaxMessages int64
+	WarnPercent int
+	WarnedAt    *time.Time // last warning sent
+	UpdatedBy   string
+	UpdatedAt   time.Time
+}
+
+// UsageBudgetRepo reads and writes usage_budgets.
+type UsageBudgetRepo struct{ q Querier }
+
+const usageBudgetColumns = `kid_username, period, COALESCE(max_tokens, 0), COALESCE(max_messages, 0),
+	warn_percent, warned_at, COALESCE(updated_by, ''), updated_at`
+
+func (r *UsageBudgetRepo) list(ctx context.Context, cond string, args ...any) ([]UsageBudget, error) {
+	rows, err := r.q.QueryContext(ctx,
+		"SELECT "+usageBudgetColumns+" FROM usage_budgets WHERE "+cond+" ORDER BY kid_username, period", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UsageBudget
+	for rows.Next() {
+		var b UsageBudget
+		if err := rows.Scan(&b.KidUsername, &b.Period, &b.MaxTokens, &b.MaxMessages,
+			&b.WarnPercent, &b.WarnedAt, &b.UpdatedBy, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// For returns kid's budgets, or the household's for Household.
+func (r *UsageBudgetRepo) For(ctx context.Context, kid string) ([]UsageBudget, error) {
+	return r.list(ctx, "kid_username = ?", kid)
+}
+
+// List returns the budgets of the kids in scope.
+func (r *UsageBudgetRepo) List(ctx context.Context, scope Scope) ([]UsageBudget, error) {
+	cond, args := scope.kidFilter("kid_username")
+	return r.list(ctx, "kid_username <> '' AND "+cond, args...)
+}
+
+// Set creates or replaces a budget. Changing it makes its warning due again.
+func (r *UsageBudgetRepo) Set(ctx context.Context, b UsageBudget, updatedBy string) error {
+	_, err := r.q.ExecContext(ctx, `
+		INSERT INTO usage_budgets(kid_username, period, max_tokens, max_messages, warn_percent,
+			updated_by, updated_at)
+		VALUES(?,?,?,?,?,?,?)
+		ON CONFLICT(kid_username, period) DO UPDATE SET max_tokens = excluded.max_tokens,
+			max_messages = excluded.max_messages, warn_percent = excluded.warn_percent,
+			warned_at = NULL, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		b.KidUsername, b.Period, nullInt(b.MaxTokens), nullInt(b.MaxMessages), b.WarnPercent,
+		nullString(updatedBy), time.Now().UTC(),
+	)
+	return err
+}
+
+// Delete removes a budget, or returns ErrNotFound.
+func (r *UsageBudgetRepo) Delete(ctx context.Context, kid, period string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"DELETE FROM usage_budgets WHERE kid_username = ? AND period = ?", kid, period))
+}
+
+// MarkWarned records that parents were warned about a budget, unless they already
+// were since the given time. It reports whether the warning is still to be sent,
+// so that only one replica sends it.
+func (r *UsageBudgetRepo) MarkWarned(ctx context.Context, kid, period string, since time.Time) (bool, error) {
+	err := mustAffect(r.q.ExecContext(ctx, `
+		UPDATE usage_budgets SET warned_at = ?
+		WHERE kid_username = ? AND period = ? AND (warned_at IS NULL OR warned_at < ?)`,
+		time.Now().UTC(), kid, period, since.UTC()))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nullInt stores zero as NULL.
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
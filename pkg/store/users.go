@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// User is a parent, admin or ai-agent account. Kids are in KidRepo.
+type User struct {
+	ID                int64
+	Username          string
+	PasswordHash      string // empty for accounts that cannot log in with a password
+	Role              string
+	FailedLogins      int
+	LockedUntil       *time.Time
+	LastLoginAt       *time.Time
+	PasswordChangedAt *time.Time
+	Email             string // from the identity provider; see IdentityRepo
+	Name              string
+	CreatedBy         string
+	CreatedAt         time.Time
+}
+
+// Locked reports whether logins are refused at now.
+func (u User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// UserRepo reads and writes users.
+type UserRepo struct{ q Querier }
+
+const userColumns = `id, username, COALESCE(password_hash, ''), role, failed_logins, locked_until,
+	last_login_at, password_changed_at, COALESCE(email, ''), COALESCE(display_name, ''),
+	COALESCE(created_by, ''), created_at`
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.FailedLogins, &u.LockedUntil,
+		&u.LastLoginAt, &u.PasswordChangedAt, &u.Email, &u.Name, &u.CreatedBy, &u.CreatedAt)
+	return u, err
+}
+
+// Get returns the user with the given username, or ErrNotFound.
+func (r *UserRepo) Get(ctx context.Context, username string) (User, error) {
+	u, err := scanUser(r.q.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+	return u, notFound(err)
+}
+
+// GetByEmail returns the user with the given email address, ignoring case, or
+// ErrNotFound.
+func (r *UserRepo) GetByEmail(ctx context.Context, email string) (User, error) {
+	u, err := scanUser(r.q.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE LOWER(email) = LOWER(?) ORDER BY id LIMIT 1", email))
+	return u, notFound(err)
+}
+
+// List returns every user ordered by username.
+func (r *UserRepo) List(ctx context.Context) ([]User, error) {
+	rows, err := r.q.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// Count returns the number of users.
+func (r *UserRepo) Count(ctx context.Context) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// Create adds a user and returns its ID, or ErrDuplicate if the username is taken.
+func (r *UserRepo) Create(ctx context.Context, u User) (int64, error) {
+	var id int64
+	err := r.q.QueryRowContext(ctx, `
+		INSERT INTO users(username, password_hash, role, password_changed_at, email, display_name, created_by, created_at)
+		VALUES(?,?,?,?,?,?,?,?)
+		ON CONFLICT(username) DO NOTHING
+		RETURNING id`,
+		u.Username, nullString(u.PasswordHash), u.Role, u.PasswordChangedAt,
+		nullString(u.Email), nullString(u.Name), nullString(u.CreatedBy), time.Now().UTC(),
+	).Scan(&id)
+	if errors.Is(notFound(err), ErrNotFound) {
+		return 0, ErrDuplicate
+	}
+	return id, err
+}
+
+// SetPassword replaces a user's password hash and clears any lockout.
+func (r *UserRepo) SetPassword(ctx context.Context, username, hash string) error {
+	return mustAffect(r.q.ExecContext(ctx, `
+		UPDATE users SET password_hash = ?, password_changed_at = ?, failed_logins = 0, locked_until = NULL
+		WHERE username = ?`,
+		hash, time.Now().UTC(), username,
+	))
+}
+
+// SetProfile replaces a user's email address and display name.
+func (r *UserRepo) SetProfile(ctx context.Context, username, email, name string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE users SET email = ?, display_name = ? WHERE username = ?",
+		nullString(email), nullString(name), username))
+}
+
+// SetRole changes a user's role.
+func (r *UserRepo) SetRole(ctx context.Context, username, role string) error {
+	return mustAffect(r.q.ExecContext(ctx, "UPDATE users SET role = ? WHERE username = ?", role, username))
+}
+
+// LoginFailed counts a failed login and returns the number of failures in a row.
+func (r *UserRepo) LoginFailed(ctx context.Context, username string) (int, error) {
+	var n int
+	err := r.q.QueryRowContext(ctx,
+		"UPDATE users SET failed_logins = failed_logins + 1 WHERE username = ? RETURNING failed_logins", username,
+	).Scan(&n)
+	return n, notFound(err)
+}
+
+// Lock refuses logins for the user until the given time.
+func (r *UserRepo) Lock(ctx context.Context, username string, until time.Time) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE users SET locked_until = ? WHERE username = ?", until.UTC(), username))
+}
+
+// Unlock clears a lockout and the failure count.
+func (r *UserRepo) Unlock(ctx context.Context, username string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE users SET failed_logins = 0, locked_until = NULL WHERE username = ?", username))
+}
+
+// LoginSucceeded resets the failure count and records the login time.
+func (r *UserRepo) LoginSucceeded(ctx context.Context, username string) error {
+	return mustAffect(r.q.ExecContext(ctx,
+		"UPDATE users SET failed_logins = 0, locked_until = NULL, last_login_at = ? WHERE username = ?",
+		time.Now().UTC(), username))
+}